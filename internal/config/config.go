@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -13,39 +14,63 @@ type Config struct {
 	Server        ServerConfig
 	GitHub        GitHubConfig
 	Claude        ClaudeConfig
-	Gemini        GeminiConfig
 	ClaudeCodeCLI ClaudeCodeCLIConfig
+	Agent         AgentConfig
 	Git           GitConfig
+	Queue         QueueConfig
+	Admin         AdminConfig
 }
 
 // ServerConfig 服务器配置
 type ServerConfig struct {
-	Port string
-	Mode string // debug/release
+	Port                   string
+	Mode                   string // debug/release
+	ShutdownTimeoutSeconds int    // 优雅关闭等待时间（秒），包括等待后台任务完成
+}
+
+// QueueConfig 后台任务队列配置
+type QueueConfig struct {
+	Workers     int // 同时执行的最大任务数，同一仓库的任务依次执行
+	Size        int // 队列容量，超出后拒绝新任务
+	MaxAttempts int // 任务因服务重启中断后的最大尝试次数
 }
 
 // GitHubConfig GitHub相关配置
 type GitHubConfig struct {
-	Token         string
-	WebhookSecret string
+	Token                  string
+	APIURL                 string   // REST API地址，为空时使用 github.com 或由GitHost推导
+	GitHost                string   // git主机，GitHub Enterprise Server时如 ghe.example.com；为空时取APIURL的主机
+	EnterpriseHosts        []string // 允许webhook中的仓库使用的其他GitHub Enterprise Server主机（可带端口）
+	AppID                  string   // GitHub App ID，配置后使用App的安装token代替Token
+	AppPrivateKey          string   // GitHub App私钥（PEM）
+	AppPrivateKeyPath      string   // GitHub App私钥文件路径，优先于AppPrivateKey
+	WebhookSecret          string
+	DeliveryTTLHours       int // 重复投递检查的有效期（小时）
+	PermissionCacheMinutes int // 协作者权限缓存的有效期（分钟）
 }
 
-// ClaudeConfig Claude API相关配置
-type ClaudeConfig struct {
-	APIKey    string
-	Model     string
-	MaxTokens int
+// AdminConfig 管理端点和投递记录配置
+type AdminConfig struct {
+	Token                  string // 管理端点认证token，为空时禁用管理端点
+	DeliveryRetentionHours int    // 投递记录保留时间（小时）
+	MaxDeliveries          int    // 最多保留的投递记录数量
 }
 
-// GeminiConfig Gemini CLI相关配置（已弃用）
-type GeminiConfig struct {
+// ClaudeConfig Anthropic Messages API相关配置，用于 anthropic-api 提供方
+type ClaudeConfig struct {
 	APIKey         string
 	Model          string
 	MaxTokens      int
-	ProjectID      string
+	BaseURL        string
 	TimeoutSeconds int
 }
 
+// AgentConfig AI提供方选择配置
+type AgentConfig struct {
+	Provider         string            // 默认提供方：claude-cli 或 anthropic-api
+	CommandProviders map[string]string // 按命令指定提供方，如 review=anthropic-api
+}
+
 // ClaudeCodeCLIConfig Claude Code CLI相关配置
 type ClaudeCodeCLIConfig struct {
 	APIKey         string
@@ -64,24 +89,28 @@ func Load() *Config {
 
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
-			Mode: getEnv("GIN_MODE", "debug"),
+			Port:                   getEnv("SERVER_PORT", "8080"),
+			Mode:                   getEnv("GIN_MODE", "debug"),
+			ShutdownTimeoutSeconds: getEnvAsInt("SHUTDOWN_TIMEOUT_SECONDS", 30),
 		},
 		GitHub: GitHubConfig{
-			Token:         getEnv("GITHUB_TOKEN", ""),
-			WebhookSecret: getEnv("GITHUB_WEBHOOK_SECRET", "your-webhook-secret"),
+			Token:                  getEnv("GITHUB_TOKEN", ""),
+			APIURL:                 getEnv("GITHUB_API_URL", ""),
+			GitHost:                getEnv("GITHUB_GIT_HOST", ""),
+			EnterpriseHosts:        getEnvAsList("GITHUB_ENTERPRISE_HOSTS"),
+			AppID:                  getEnv("GITHUB_APP_ID", ""),
+			AppPrivateKey:          strings.ReplaceAll(getEnv("GITHUB_APP_PRIVATE_KEY", ""), `\n`, "\n"),
+			AppPrivateKeyPath:      getEnv("GITHUB_APP_PRIVATE_KEY_PATH", ""),
+			WebhookSecret:          getEnv("GITHUB_WEBHOOK_SECRET", "your-webhook-secret"),
+			DeliveryTTLHours:       getEnvAsInt("GITHUB_DELIVERY_TTL_HOURS", 72),
+			PermissionCacheMinutes: getEnvAsInt("GITHUB_PERMISSION_CACHE_MINUTES", 5),
 		},
 		Claude: ClaudeConfig{
-			APIKey:    getEnv("CLAUDE_API_KEY", ""),
-			Model:     getEnv("CLAUDE_MODEL", "claude-3-5-sonnet-20241022"),
-			MaxTokens: getEnvAsInt("CLAUDE_MAX_TOKENS", 4000),
-		},
-		Gemini: GeminiConfig{
-			APIKey:         getEnv("GEMINI_API_KEY", ""),
-			Model:          getEnv("GEMINI_MODEL", "gemini-2.0-flash-exp"),
-			MaxTokens:      getEnvAsInt("GEMINI_MAX_TOKENS", 8000),
-			ProjectID:      getEnv("GOOGLE_CLOUD_PROJECT", ""),
-			TimeoutSeconds: getEnvAsInt("GEMINI_TIMEOUT_SECONDS", 120),
+			APIKey:         getEnv("CLAUDE_API_KEY", ""),
+			Model:          getEnv("CLAUDE_MODEL", "claude-3-5-sonnet-20241022"),
+			MaxTokens:      getEnvAsInt("CLAUDE_MAX_TOKENS", 4000),
+			BaseURL:        getEnv("CLAUDE_API_BASE_URL", "https://api.anthropic.com"),
+			TimeoutSeconds: getEnvAsInt("CLAUDE_TIMEOUT_SECONDS", 120),
 		},
 		ClaudeCodeCLI: ClaudeCodeCLIConfig{
 			APIKey:         getEnv("CLAUDE_CODE_CLI_API_KEY", ""),
@@ -90,6 +119,20 @@ func Load() *Config {
 			TimeoutSeconds: getEnvAsInt("CLAUDE_CODE_CLI_TIMEOUT_SECONDS", 120),
 			BaseURL:        getEnv("ANTHROPIC_BASE_URL", ""),
 		},
+		Agent: AgentConfig{
+			Provider:         getEnv("AGENT_PROVIDER", "claude-cli"),
+			CommandProviders: getEnvAsMap("AGENT_COMMAND_PROVIDERS"),
+		},
+		Queue: QueueConfig{
+			Workers:     getEnvAsInt("JOB_WORKERS", 2),
+			Size:        getEnvAsInt("JOB_QUEUE_SIZE", 100),
+			MaxAttempts: getEnvAsInt("JOB_MAX_ATTEMPTS", 2),
+		},
+		Admin: AdminConfig{
+			Token:                  getEnv("ADMIN_TOKEN", ""),
+			DeliveryRetentionHours: getEnvAsInt("DELIVERY_RETENTION_HOURS", 72),
+			MaxDeliveries:          getEnvAsInt("DELIVERY_MAX_RECORDS", 500),
+		},
 	}
 }
 
@@ -122,3 +165,31 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	}
 	return defaultValue
 }
+
+// getEnvAsList 获取逗号分隔的环境变量，忽略空项
+func getEnvAsList(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getEnvAsMap 获取 key=value,key=value 形式的环境变量
+func getEnvAsMap(key string) map[string]string {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			log.Printf("警告: 环境变量 %s 中的 %q 不是 key=value 格式，已忽略", key, pair)
+			continue
+		}
+		result[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/webhook-demo/internal/services"
+)
+
+// AdminHandler 管理端点处理器，用于查看和重放记录的webhook投递
+type AdminHandler struct {
+	deliveryRecorder *services.DeliveryRecorder
+	jobQueue         *services.JobQueue
+	jobStore         *services.JobStore
+	eventProcessor   *services.EventProcessor
+}
+
+// NewAdminHandler 创建新的管理端点处理器
+func NewAdminHandler(deliveryRecorder *services.DeliveryRecorder, jobQueue *services.JobQueue, jobStore *services.JobStore, eventProcessor *services.EventProcessor) *AdminHandler {
+	return &AdminHandler{
+		deliveryRecorder: deliveryRecorder,
+		jobQueue:         jobQueue,
+		jobStore:         jobStore,
+		eventProcessor:   eventProcessor,
+	}
+}
+
+// ListDeliveries 列出记录的投递
+func (h *AdminHandler) ListDeliveries(c *gin.Context) {
+	deliveries, err := h.deliveryRecorder.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// GetDelivery 获取单个投递的请求头和payload
+func (h *AdminHandler) GetDelivery(c *gin.Context) {
+	delivery, err := h.deliveryRecorder.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":          delivery.ID,
+		"event_type":  delivery.EventType,
+		"headers":     delivery.Headers,
+		"payload":     delivery.ToEvent().GetPayloadAsString(),
+		"received_at": delivery.ReceivedAt,
+	})
+}
+
+// ReplayDelivery 将记录的投递重新交给EventProcessor处理
+// dry_run=true时只解析事件和命令，不执行任何操作
+func (h *AdminHandler) ReplayDelivery(c *gin.Context) {
+	delivery, err := h.deliveryRecorder.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	event := delivery.ToEvent()
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	if dryRun {
+		commands, ctx := h.eventProcessor.PeekCommands(event)
+		response := gin.H{
+			"dry_run":     true,
+			"delivery_id": delivery.ID,
+			"event_type":  delivery.EventType,
+			"commands":    commands,
+		}
+		if ctx != nil {
+			response["repository"] = ctx.Repository.FullName
+			response["user"] = ctx.User.Login
+			if ctx.Issue != nil {
+				response["issue"] = ctx.Issue.Number
+			}
+			if ctx.PullRequest != nil {
+				response["pull_request"] = ctx.PullRequest.Number
+			}
+		}
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// 重放的投递在首次接收时已通过签名验证
+	h.eventProcessor.RememberRepository(event)
+	job, err := h.jobQueue.Enqueue(event)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("重放投递: DeliveryID=%s, JobID=%s", delivery.ID, job.ID)
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "投递已重新入队",
+		"delivery_id": delivery.ID,
+		"job_id":      job.ID,
+	})
+}
+
+// GetJob 获取任务记录，用于查看重放结果
+func (h *AdminHandler) GetJob(c *gin.Context) {
+	record, err := h.jobStore.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, record)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"io"
 	"log"
 	"net/http"
@@ -16,15 +17,21 @@ import (
 
 // WebhookHandler 处理GitHub webhook请求
 type WebhookHandler struct {
-	eventProcessor *services.EventProcessor
-	webhookSecret  string
+	jobQueue         *services.JobQueue
+	eventProcessor   *services.EventProcessor
+	deliveryLedger   *services.DeliveryLedger
+	deliveryRecorder *services.DeliveryRecorder
+	webhookSecret    string
 }
 
 // NewWebhookHandler 创建新的webhook处理器
-func NewWebhookHandler(eventProcessor *services.EventProcessor, webhookSecret string) *WebhookHandler {
+func NewWebhookHandler(jobQueue *services.JobQueue, eventProcessor *services.EventProcessor, deliveryLedger *services.DeliveryLedger, deliveryRecorder *services.DeliveryRecorder, webhookSecret string) *WebhookHandler {
 	return &WebhookHandler{
-		eventProcessor: eventProcessor,
-		webhookSecret:  webhookSecret,
+		jobQueue:         jobQueue,
+		eventProcessor:   eventProcessor,
+		deliveryLedger:   deliveryLedger,
+		deliveryRecorder: deliveryRecorder,
+		webhookSecret:    webhookSecret,
 	}
 }
 
@@ -54,6 +61,24 @@ func (h *WebhookHandler) HandleWebhook(c *gin.Context) {
 
 	log.Printf("签名验证成功: DeliveryID=%s", deliveryID)
 
+	// 记录原始投递，便于调试时重放
+	if err := h.deliveryRecorder.Record(deliveryID, eventType, c.Request.Header, body); err != nil {
+		log.Printf("记录投递失败: DeliveryID=%s, %v", deliveryID, err)
+	}
+
+	// 重复投递检查：GitHub重试或手动Redeliver会使用相同的DeliveryID
+	// 需要重新处理时使用需要认证的 /admin/deliveries/:id/replay
+	if deliveryID != "" && !h.deliveryLedger.MarkSeen(deliveryID) {
+		log.Printf("重复投递，已忽略: DeliveryID=%s", deliveryID)
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "重复投递，已忽略",
+			"event_type":  eventType,
+			"delivery_id": deliveryID,
+			"duplicate":   true,
+		})
+		return
+	}
+
 	// 创建事件对象
 	event := &models.GitHubEvent{
 		Type:       eventType,
@@ -61,18 +86,31 @@ func (h *WebhookHandler) HandleWebhook(c *gin.Context) {
 		Payload:    body,
 	}
 
-	// 处理事件
-	if err := h.eventProcessor.ProcessEvent(event); err != nil {
-		log.Printf("处理事件失败: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "处理事件失败"})
+	// 签名验证通过后才记录payload中仓库所在的GitHub实例，其他实例的主机还需要在 GITHUB_ENTERPRISE_HOSTS 中
+	h.eventProcessor.RememberRepository(event)
+
+	// 放入后台队列，避免长时间任务阻塞GitHub的投递请求（GitHub超时时间为10秒）
+	job, err := h.jobQueue.Enqueue(event)
+	if err != nil {
+		log.Printf("事件入队失败: DeliveryID=%s, %v", deliveryID, err)
+		// 未能入队的投递允许GitHub重试
+		if deliveryID != "" {
+			h.deliveryLedger.Forget(deliveryID)
+		}
+		if errors.Is(err, services.ErrQueueFull) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "任务队列已满，请稍后重试"})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "服务正在关闭"})
 		return
 	}
 
-	// 返回成功响应
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "事件处理成功",
+	// 返回已接受响应
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":     "事件已接受，正在后台处理",
 		"event_type":  eventType,
 		"delivery_id": deliveryID,
+		"job_id":      job.ID,
 	})
 }
 
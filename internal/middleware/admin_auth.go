@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth 管理端点认证中间件，要求请求头 Authorization: Bearer <token>
+// token未配置时管理端点不可用
+func AdminAuth(token string) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "管理端点未启用，请配置ADMIN_TOKEN"})
+			return
+		}
+
+		provided := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "认证失败"})
+			return
+		}
+
+		c.Next()
+	})
+}
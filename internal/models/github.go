@@ -34,6 +34,13 @@ type User struct {
 	Login     string `json:"login"`
 	HTMLURL   string `json:"html_url"`
 	AvatarURL string `json:"avatar_url"`
+	Type      string `json:"type"` // "User"、"Bot" 或 "Organization"
+}
+
+// Installation GitHub App的安装信息，只有GitHub App投递的webhook带有该字段
+type Installation struct {
+	ID      int64 `json:"id"`
+	Account User  `json:"account"` // 安装所在的组织或用户，部分事件中没有
 }
 
 // Issue Issue信息
@@ -50,6 +57,14 @@ type Issue struct {
 	Labels    []Label   `json:"labels"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	PullRequest *IssuePullRequest `json:"pull_request,omitempty"` // Issue是PR时存在，只包含链接，PR详情需要另外查询
+}
+
+// IssuePullRequest Issue对应的PR链接，PR在Issue API和issue_comment事件中以这种形式出现
+type IssuePullRequest struct {
+	URL     string `json:"url"`
+	HTMLURL string `json:"html_url"`
 }
 
 // PullRequest Pull Request信息
@@ -65,6 +80,7 @@ type PullRequest struct {
 	Base      PRBranch  `json:"base"`
 	Merged    bool      `json:"merged"`
 	Draft     bool      `json:"draft"`
+	Labels    []Label   `json:"labels"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -104,12 +120,11 @@ type IssuesEvent struct {
 
 // IssueCommentEvent Issue评论事件
 type IssueCommentEvent struct {
-	Action      string       `json:"action"`
-	Issue       Issue        `json:"issue"`
-	Comment     Comment      `json:"comment"`
-	Repository  Repository   `json:"repository"`
-	Sender      User         `json:"sender"`
-	PullRequest *PullRequest `json:"pull_request,omitempty"` // PR相关的评论会包含这个字段
+	Action     string     `json:"action"`
+	Issue      Issue      `json:"issue"`
+	Comment    Comment    `json:"comment"`
+	Repository Repository `json:"repository"`
+	Sender     User       `json:"sender"`
 }
 
 // PullRequestEvent Pull Request事件
@@ -119,6 +134,8 @@ type PullRequestEvent struct {
 	PullRequest PullRequest `json:"pull_request"`
 	Repository  Repository  `json:"repository"`
 	Sender      User        `json:"sender"`
+	Before      string      `json:"before"` // synchronize事件中推送前的head SHA
+	After       string      `json:"after"`  // synchronize事件中推送后的head SHA
 }
 
 // PullRequestReviewCommentEvent PR Review评论事件
@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 内置的AI服务提供方名称
+const (
+	ProviderClaudeCLI    = "claude-cli"    // Claude Code CLI，可以读写仓库文件
+	ProviderAnthropicAPI = "anthropic-api" // Anthropic Messages API，只能处理提示词中的内容，看不到仓库文件
+)
+
+// AgentProvider AI服务提供方，命令处理通过该接口调用AI，便于替换实现或在测试中使用假实现
+// 返回error时调用失败，否则AgentResult.Output为AI的最终回复；ctx取消时应尽快终止并返回 ErrJobCanceled
+type AgentProvider interface {
+	Name() string                                                                             // 提供方名称
+	GenerateInRepo(ctx context.Context, prompt string, repoPath string) (*AgentResult, error) // 生成或修改代码，repoPath为空时只返回文本
+	ReviewInRepo(ctx context.Context, prompt string, repoPath string) (*AgentResult, error)   // 代码审查，不修改文件
+	Summarize(ctx context.Context, prompt string, repoPath string) (*AgentResult, error)      // 总结内容，不修改文件
+	WithRepoConfig(cfg *RepoConfig) AgentProvider                                             // 返回应用了仓库配置（模型、超时）的提供方
+}
+
+// RepoAccessor 提供方可以实现该接口声明能否读写repoPath中的仓库文件，未实现时视为可以
+type RepoAccessor interface {
+	CanAccessRepo() bool
+}
+
+// repoCommands 需要读取或修改仓库文件的命令，只能使用可以读写仓库文件的提供方
+// PR中的 /review 把diff放在提示词中，不在此列；Issue中的 /review 通过 SelectInRepo 选择
+var repoCommands = map[string]bool{"code": true, "continue": true, "fix": true, "summary": true}
+
+// canAccessRepo 检查提供方能否读写仓库文件
+func canAccessRepo(provider AgentProvider) bool {
+	accessor, ok := provider.(RepoAccessor)
+	return !ok || accessor.CanAccessRepo()
+}
+
+// AgentResult AI调用结果
+type AgentResult struct {
+	Output    string        // AI的最终回复
+	Provider  string        // 提供方名称
+	Model     string        // 实际使用的模型
+	SessionID string        // 会话ID（如果有）
+	NumTurns  int           // 对话轮次
+	CostUSD   float64       // 费用（美元），提供方不支持时为0
+	Duration  time.Duration // 耗时
+	ToolCalls []ToolCall    // 工具调用记录
+}
+
+// ToolCall 一次工具调用
+type ToolCall struct {
+	Name    string // 工具名称，如 Edit、Write
+	Target  string // 操作对象，如文件路径
+	IsError bool   // 工具执行是否失败
+}
+
+// Summary 调用结果的简要统计，用于日志和回复
+func (r *AgentResult) Summary() string {
+	if r == nil {
+		return ""
+	}
+
+	parts := []string{fmt.Sprintf("提供方: %s", r.Provider)}
+	if r.NumTurns > 0 {
+		parts = append(parts, fmt.Sprintf("对话轮次: %d", r.NumTurns))
+	}
+	if len(r.ToolCalls) > 0 {
+		failed := 0
+		for _, call := range r.ToolCalls {
+			if call.IsError {
+				failed++
+			}
+		}
+		toolSummary := fmt.Sprintf("工具调用: %d 次", len(r.ToolCalls))
+		if failed > 0 {
+			toolSummary += fmt.Sprintf("（%d 次失败）", failed)
+		}
+		parts = append(parts, toolSummary)
+	}
+	if r.CostUSD > 0 {
+		parts = append(parts, fmt.Sprintf("费用: $%.4f", r.CostUSD))
+	}
+	if r.Duration > 0 {
+		parts = append(parts, fmt.Sprintf("耗时: %s", r.Duration.Round(time.Second)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ChangedFiles 通过工具调用修改过的文件，按首次出现的顺序去重
+func (r *AgentResult) ChangedFiles() []string {
+	if r == nil {
+		return nil
+	}
+
+	var files []string
+	for _, call := range r.ToolCalls {
+		if call.IsError || call.Target == "" || !isEditTool(call.Name) {
+			continue
+		}
+		if !containsString(files, call.Target) {
+			files = append(files, call.Target)
+		}
+	}
+	return files
+}
+
+// isEditTool 检查工具是否会修改文件
+func isEditTool(name string) bool {
+	switch name {
+	case "Edit", "MultiEdit", "Write", "NotebookEdit":
+		return true
+	}
+	return false
+}
+
+// AgentRegistry AI服务提供方注册表，按命令和仓库配置选择提供方，需在启动时完成配置
+type AgentRegistry struct {
+	providers        map[string]AgentProvider
+	defaultName      string
+	commandProviders map[string]string // 命令名称 -> 提供方名称
+}
+
+// NewAgentRegistry 创建提供方注册表，defaultProvider为默认提供方
+func NewAgentRegistry(defaultProvider AgentProvider) *AgentRegistry {
+	return &AgentRegistry{
+		providers:        map[string]AgentProvider{defaultProvider.Name(): defaultProvider},
+		defaultName:      defaultProvider.Name(),
+		commandProviders: make(map[string]string),
+	}
+}
+
+// Register 注册提供方，同名提供方会被替换
+func (r *AgentRegistry) Register(provider AgentProvider) {
+	r.providers[provider.Name()] = provider
+}
+
+// Has 检查提供方是否已注册
+func (r *AgentRegistry) Has(name string) bool {
+	_, exists := r.providers[name]
+	return exists
+}
+
+// Names 已注册的提供方名称
+func (r *AgentRegistry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SetDefault 设置默认提供方
+func (r *AgentRegistry) SetDefault(name string) error {
+	if !r.Has(name) {
+		return fmt.Errorf("未注册的AI提供方: %s（可用: %s）", name, strings.Join(r.Names(), ", "))
+	}
+	r.defaultName = name
+	return nil
+}
+
+// SetCommandProvider 为指定命令设置提供方
+func (r *AgentRegistry) SetCommandProvider(command, name string) error {
+	if !r.Has(name) {
+		return fmt.Errorf("命令 /%s 使用了未注册的AI提供方: %s（可用: %s）", command, name, strings.Join(r.Names(), ", "))
+	}
+	if repoCommands[command] && !r.CanAccessRepo(name) {
+		return fmt.Errorf("命令 /%s 需要读取仓库文件，AI提供方 %s 无法读写仓库文件", command, name)
+	}
+	r.commandProviders[command] = name
+	return nil
+}
+
+// CanAccessRepo 检查已注册的提供方能否读写仓库文件
+func (r *AgentRegistry) CanAccessRepo(name string) bool {
+	provider, exists := r.providers[name]
+	return exists && canAccessRepo(provider)
+}
+
+// Select 选择命令使用的提供方，优先级：仓库命令配置 > 仓库默认配置 > 全局命令配置 > 全局默认
+// 需要仓库文件的命令跳过无法读写仓库文件的提供方，都不可用时使用任一可以读写仓库文件的提供方
+func (r *AgentRegistry) Select(command string, cfg *RepoConfig) AgentProvider {
+	return r.selectProvider(command, cfg, repoCommands[command])
+}
+
+// SelectInRepo 为在仓库目录中执行的命令选择提供方，如Issue中的 /review，总是跳过无法读写仓库文件的提供方
+func (r *AgentRegistry) SelectInRepo(command string, cfg *RepoConfig) AgentProvider {
+	return r.selectProvider(command, cfg, true)
+}
+
+// selectProvider 按优先级选择提供方，needsRepo为true时只选择可以读写仓库文件的提供方
+func (r *AgentRegistry) selectProvider(command string, cfg *RepoConfig, needsRepo bool) AgentProvider {
+	candidates := []string{}
+	if cfg != nil {
+		candidates = append(candidates, cfg.Providers[command], cfg.Provider)
+	}
+	candidates = append(candidates, r.commandProviders[command], r.defaultName)
+
+	skipped := make(map[string]bool)
+	for _, name := range candidates {
+		provider, exists := r.providers[name]
+		if !exists || skipped[name] {
+			continue
+		}
+		if needsRepo && !canAccessRepo(provider) {
+			log.Printf("AI提供方 %s 无法读写仓库文件，命令 /%s 改用其他提供方", name, command)
+			skipped[name] = true
+			continue
+		}
+		return provider.WithRepoConfig(cfg)
+	}
+
+	for _, name := range r.Names() {
+		if provider := r.providers[name]; canAccessRepo(provider) {
+			return provider.WithRepoConfig(cfg)
+		}
+	}
+	return r.providers[r.defaultName].WithRepoConfig(cfg)
+}
+
+// buildCodeGenerationPrompt 构建代码生成提示
+func buildCodeGenerationPrompt(requirement string, context string) string {
+	return fmt.Sprintf("你是一个专业的软件开发助手，专门帮助用户生成高质量的代码。\n\n"+
+		"**需求描述:**\n"+
+		"%s\n\n"+
+		"**项目上下文:**\n"+
+		"%s\n\n"+
+		"**要求:**\n"+
+		"1. 生成完整、可运行的代码\n"+
+		"2. 包含必要的注释和文档\n"+
+		"3. 遵循最佳实践和代码规范\n"+
+		"4. 考虑错误处理和边界情况\n"+
+		"5. 如果涉及多个文件，请明确标注文件名\n\n"+
+		"**输出格式:**\n"+
+		"请直接输出代码，不需要额外的解释。如果需要多个文件，请使用 ```filename:path/to/file``` 格式标注。\n\n"+
+		"请开始生成代码:", requirement, context)
+}
+
+// buildContinuePrompt 构建继续开发提示，previousDiff为分支上已有的修改，conversation为Issue/PR中的讨论
+func buildContinuePrompt(instruction, context, branch, previousDiff, conversation string) string {
+	if strings.TrimSpace(instruction) == "" {
+		instruction = "根据讨论内容和已有修改，继续完成未完成的部分"
+	}
+
+	return fmt.Sprintf("你正在继续一个软件开发项目。当前目录已检出分支 %s，其中包含之前生成的代码。请根据以下指令直接修改仓库中的文件：\n\n"+
+		"**继续指令:**\n"+
+		"%s\n\n"+
+		"**当前项目上下文:**\n"+
+		"%s\n\n"+
+		"**分支上已有的修改:**\n"+
+		"```diff\n%s\n```\n\n"+
+		"**讨论记录:**\n"+
+		"%s\n\n"+
+		"**要求:**\n"+
+		"1. 基于分支上已有的代码继续开发，不要重复实现已有的功能\n"+
+		"2. 保持代码风格的一致性\n"+
+		"3. 确保新代码与现有代码兼容\n"+
+		"4. 添加必要的注释说明\n\n"+
+		"请继续开发:", branch, instruction, context, previousDiff, conversation)
+}
+
+// buildFixPrompt 构建代码修复提示，AI在检出的仓库中直接修改文件
+func buildFixPrompt(problem string, codeContext string) string {
+	return fmt.Sprintf("你正在修复代码中的问题。当前目录是项目仓库，请分析并直接修改仓库中的文件修复以下问题：\n\n"+
+		"**问题描述:**\n"+
+		"%s\n\n"+
+		"**代码上下文:**\n"+
+		"%s\n\n"+
+		"**要求:**\n"+
+		"1. 分析问题的根本原因\n"+
+		"2. 只做修复问题所需的最小修改，不要重构无关代码\n"+
+		"3. 确保修复后的代码正确运行\n"+
+		"4. 添加必要的注释说明修复内容\n\n"+
+		"最后简要说明问题原因和所做的修改。", problem, codeContext)
+}
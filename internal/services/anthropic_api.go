@@ -0,0 +1,191 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/webhook-demo/internal/config"
+)
+
+// anthropicAPIVersion Messages API版本
+const anthropicAPIVersion = "2023-06-01"
+
+// AnthropicAPIService 直接调用Anthropic Messages API的提供方
+// 无法访问仓库文件，只适合审查、总结等所需上下文已包含在提示词中的只读命令
+type AnthropicAPIService struct {
+	config *config.ClaudeConfig
+	client *http.Client
+}
+
+// NewAnthropicAPIService 创建新的Anthropic Messages API服务
+func NewAnthropicAPIService(cfg *config.ClaudeConfig) *AnthropicAPIService {
+	timeout := 120 * time.Second
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+
+	return &AnthropicAPIService{
+		config: cfg,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Name 提供方名称
+func (s *AnthropicAPIService) Name() string {
+	return ProviderAnthropicAPI
+}
+
+// WithRepoConfig 返回使用仓库配置覆盖模型和超时的服务副本
+func (s *AnthropicAPIService) WithRepoConfig(repoConfig *RepoConfig) AgentProvider {
+	if repoConfig == nil || (repoConfig.Model == "" && repoConfig.TimeoutSeconds == 0) {
+		return s
+	}
+
+	cfg := *s.config
+	if repoConfig.Model != "" {
+		cfg.Model = repoConfig.Model
+	}
+	if repoConfig.TimeoutSeconds > 0 {
+		cfg.TimeoutSeconds = repoConfig.TimeoutSeconds
+	}
+
+	return NewAnthropicAPIService(&cfg)
+}
+
+// CanAccessRepo Messages API只处理提示词中的内容，无法读写仓库文件
+func (s *AnthropicAPIService) CanAccessRepo() bool {
+	return false
+}
+
+// GenerateInRepo 生成代码文本，无法修改仓库文件
+func (s *AnthropicAPIService) GenerateInRepo(ctx context.Context, prompt string, repoPath string) (*AgentResult, error) {
+	if repoPath != "" {
+		return nil, fmt.Errorf("%s 无法修改仓库文件，请为该命令使用 %s", ProviderAnthropicAPI, ProviderClaudeCLI)
+	}
+	return s.createMessage(ctx, prompt)
+}
+
+// ReviewInRepo 代码审查，只使用提示词中的内容
+func (s *AnthropicAPIService) ReviewInRepo(ctx context.Context, prompt string, repoPath string) (*AgentResult, error) {
+	return s.createMessage(ctx, prompt)
+}
+
+// Summarize 总结内容，只使用提示词中的内容
+func (s *AnthropicAPIService) Summarize(ctx context.Context, prompt string, repoPath string) (*AgentResult, error) {
+	return s.createMessage(ctx, prompt)
+}
+
+// anthropicMessageRequest Messages API请求
+type anthropicMessageRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	Messages  []anthropicMessage `json:"messages"`
+}
+
+// anthropicMessage 对话消息
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// anthropicMessageResponse Messages API响应
+type anthropicMessageResponse struct {
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Error      *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// createMessage 调用Messages API并返回文本内容
+func (s *AnthropicAPIService) createMessage(ctx context.Context, prompt string) (*AgentResult, error) {
+	if s.config.APIKey == "" {
+		return nil, fmt.Errorf("未配置 CLAUDE_API_KEY，无法使用 %s", ProviderAnthropicAPI)
+	}
+
+	maxTokens := s.config.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = 4000
+	}
+
+	payload, err := json.Marshal(anthropicMessageRequest{
+		Model:     s.config.Model,
+		MaxTokens: maxTokens,
+		Messages:  []anthropicMessage{{Role: "user", Content: prompt}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求数据失败: %v", err)
+	}
+
+	url := strings.TrimSuffix(s.config.BaseURL, "/") + "/v1/messages"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", s.config.APIKey)
+	req.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	log.Printf("调用Anthropic Messages API，模型: %s, 提示词长度: %d 字符", s.config.Model, len(prompt))
+	startTime := time.Now()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if canceled := canceledError(ctx); canceled != nil {
+			return nil, canceled
+		}
+		return nil, fmt.Errorf("Anthropic API请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+
+	var response anthropicMessageResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v, 状态码: %d", err, resp.StatusCode)
+	}
+
+	if resp.StatusCode >= 400 {
+		if response.Error != nil {
+			return nil, fmt.Errorf("Anthropic API错误: %d %s: %s", resp.StatusCode, response.Error.Type, response.Error.Message)
+		}
+		return nil, fmt.Errorf("Anthropic API错误: %d", resp.StatusCode)
+	}
+
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	output := strings.TrimSpace(text.String())
+	if output == "" {
+		return nil, fmt.Errorf("Anthropic API没有返回任何文本，stop_reason: %s", response.StopReason)
+	}
+
+	result := &AgentResult{
+		Output:   output,
+		Provider: ProviderAnthropicAPI,
+		Model:    response.Model,
+		NumTurns: 1,
+		Duration: time.Since(startTime),
+	}
+	log.Printf("Anthropic API调用成功，%s, 输出长度: %d 字符", result.Summary(), len(output))
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/webhook-demo/internal/models"
+)
+
+// 自动审查正文末尾的隐藏标记，用于找到之前的自动审查并标记为已取代
+const (
+	autoReviewMarker           = "<!-- codeagent:auto-review -->"
+	supersededAutoReviewMarker = "<!-- codeagent:auto-review superseded -->"
+)
+
+// handlePullRequestAutoReview PR打开、标记为可审查或推送新提交时自动审查
+// sinceSHA不为空时只审查该提交之后新推送的变更
+func (ep *EventProcessor) handlePullRequestAutoReview(jobCtx context.Context, event *models.PullRequestEvent, sinceSHA string) error {
+	pr := &event.PullRequest
+	ctx := &CommandContext{
+		Repository:  event.Repository,
+		PullRequest: pr,
+		User:        event.Sender,
+		Context:     jobCtx,
+	}
+
+	if err := ep.loadRepoConfig(ctx); err != nil {
+		// 配置无效时不自动审查，也不在PR上回复，避免每次推送都产生评论
+		log.Printf("PR #%d 跳过自动审查: %v", pr.Number, err)
+		return nil
+	}
+	if reason := ctx.Config.AutoReviewSkipReason(pr); reason != "" {
+		log.Printf("PR #%d 跳过自动审查: %s", pr.Number, reason)
+		return nil
+	}
+	if reason := ep.untrustedAutoReviewReason(ctx); reason != "" {
+		log.Printf("PR #%d 跳过自动审查: %s", pr.Number, reason)
+		return nil
+	}
+
+	log.Printf("自动审查PR #%d (head %s)", pr.Number, shortSHA(pr.Head.SHA))
+	return ep.reviewPullRequest(ctx, nil, prReviewOptions{
+		command:  "review",
+		scope:    "PR代码变更",
+		sinceSHA: sinceSHA,
+		auto:     true,
+	})
+}
+
+// untrustedAutoReviewReason 检查PR作者是否可信，不可信时返回跳过的原因
+// 没有 auto_review.untrusted_authors 时只审查本仓库分支上、作者拥有写权限的PR；机器人是否审查由 auto_review.bots 决定
+func (ep *EventProcessor) untrustedAutoReviewReason(ctx *CommandContext) string {
+	pr := ctx.PullRequest
+	if ctx.Config.AutoReview.UntrustedAuthors {
+		return ""
+	}
+	if pr.Head.Repo.FullName != "" && !strings.EqualFold(pr.Head.Repo.FullName, ctx.Repository.FullName) {
+		return fmt.Sprintf("来自fork仓库 %s 的PR", pr.Head.Repo.FullName)
+	}
+	if isBotUser(pr.User) {
+		return ""
+	}
+
+	role, err := ep.githubService.GetCollaboratorPermission(ctx.Repository.Owner.Login, ctx.Repository.Name, pr.User.Login)
+	if err != nil {
+		return fmt.Sprintf("无法确认作者 %s 的权限: %v", pr.User.Login, err)
+	}
+	if granted := ParsePermission(role); granted < PermissionWrite {
+		return fmt.Sprintf("作者 %s 没有写权限（%s）", pr.User.Login, granted)
+	}
+	return ""
+}
+
+// autoReviewBody 为自动审查正文加上审查范围说明和隐藏标记
+func autoReviewBody(body, sinceSHA, headSHA string) string {
+	header := fmt.Sprintf("🤖 **自动审查** `%s`", shortSHA(headSHA))
+	if sinceSHA != "" {
+		header = fmt.Sprintf("🤖 **自动审查**（增量）`%s..%s`", shortSHA(sinceSHA), shortSHA(headSHA))
+	}
+	return fmt.Sprintf("%s\n\n%s\n\n%s", header, body, autoReviewMarker)
+}
+
+// supersedeAutoReviews 将之前的自动审查标记为已被current取代
+// 新的审查覆盖整个PR时，同时撤销之前要求修改的自动审查；增量审查不撤销，之前的问题可能仍未修复
+func (ep *EventProcessor) supersedeAutoReviews(ctx *CommandContext, current *ReviewResponse, fullReview bool) {
+	owner, repo, number := ctx.Repository.Owner.Login, ctx.Repository.Name, ctx.PullRequest.Number
+
+	reviews, err := ep.githubService.ListPullRequestReviews(owner, repo, number)
+	if err != nil {
+		log.Printf("获取PR审查列表失败，无法标记之前的自动审查: %v", err)
+		return
+	}
+
+	notice := "> ⚠️ 此自动审查已被之后的自动审查取代。"
+	if current.HTMLURL != "" {
+		notice = fmt.Sprintf("> ⚠️ 此自动审查已被 [新的自动审查](%s) 取代。", current.HTMLURL)
+	}
+
+	for _, review := range reviews {
+		if review.ID == current.ID || !strings.Contains(review.Body, autoReviewMarker) {
+			continue
+		}
+
+		body := notice + "\n\n" + strings.Replace(review.Body, autoReviewMarker, supersededAutoReviewMarker, 1)
+		if err := ep.githubService.UpdatePullRequestReview(owner, repo, number, review.ID, body); err != nil {
+			log.Printf("更新自动审查 %d 失败: %v", review.ID, err)
+			continue
+		}
+
+		if fullReview && review.State == "CHANGES_REQUESTED" {
+			if err := ep.githubService.DismissPullRequestReview(owner, repo, number, review.ID, "已被新的自动审查取代"); err != nil {
+				log.Printf("撤销自动审查 %d 失败: %v", review.ID, err)
+			}
+		}
+	}
+}
+
+// shortSHA 提交SHA的缩写
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
@@ -1,9 +1,12 @@
 package services
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -13,6 +16,33 @@ import (
 	"github.com/webhook-demo/internal/config"
 )
 
+// maxStreamLineSize stream-json 单行事件的最大长度，工具调用结果可能包含整个文件
+const maxStreamLineSize = 16 * 1024 * 1024
+
+// agentSecretEnv 服务自身使用的密钥，不传给Claude CLI进程：AI会读取Issue、PR等外部提交的内容，可能被诱导输出或外发环境变量
+var agentSecretEnv = map[string]bool{
+	"GITHUB_TOKEN":                true,
+	"GH_TOKEN":                    true,
+	"GITHUB_APP_PRIVATE_KEY":      true,
+	"GITHUB_APP_PRIVATE_KEY_PATH": true,
+	"GITHUB_WEBHOOK_SECRET":       true,
+	"ADMIN_TOKEN":                 true,
+	"CLAUDE_API_KEY":              true,
+	askpassTokenEnv:               true,
+}
+
+// agentEnviron 去掉服务密钥后的当前进程环境变量
+func agentEnviron() []string {
+	var env []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !agentSecretEnv[name] {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
 // ClaudeCodeCLIService Claude Code CLI服务
 type ClaudeCodeCLIService struct {
 	config *config.ClaudeCodeCLIConfig
@@ -25,83 +55,87 @@ func NewClaudeCodeCLIService(cfg *config.ClaudeCodeCLIConfig) *ClaudeCodeCLIServ
 	}
 }
 
-// GenerateCode 生成代码
-func (ccs *ClaudeCodeCLIService) GenerateCode(requirement string, context string) (string, error) {
-	prompt := ccs.buildCodeGenerationPrompt(requirement, context)
-	return ccs.callClaudeCodeCLI(prompt)
+// Name 提供方名称
+func (ccs *ClaudeCodeCLIService) Name() string {
+	return ProviderClaudeCLI
 }
 
-// ContinueCode 继续代码开发
-func (ccs *ClaudeCodeCLIService) ContinueCode(instruction string, context string) (string, error) {
-	prompt := ccs.buildContinuePrompt(instruction, context)
-	return ccs.callClaudeCodeCLI(prompt)
-}
-
-// FixCode 修复代码问题
-func (ccs *ClaudeCodeCLIService) FixCode(problem string, codeContext string) (string, error) {
-	prompt := ccs.buildFixPrompt(problem, codeContext)
-	return ccs.callClaudeCodeCLI(prompt)
-}
+// WithRepoConfig 返回使用仓库配置覆盖模型和超时的服务副本
+func (ccs *ClaudeCodeCLIService) WithRepoConfig(repoConfig *RepoConfig) AgentProvider {
+	if repoConfig == nil || (repoConfig.Model == "" && repoConfig.TimeoutSeconds == 0) {
+		return ccs
+	}
 
-// Summarize 总结内容
-func (ccs *ClaudeCodeCLIService) Summarize(summaryPrompt string) (string, error) {
-	return ccs.callClaudeCodeCLI(summaryPrompt)
-}
+	cfg := *ccs.config
+	if repoConfig.Model != "" {
+		cfg.Model = repoConfig.Model
+	}
+	if repoConfig.TimeoutSeconds > 0 {
+		cfg.TimeoutSeconds = repoConfig.TimeoutSeconds
+	}
 
-// SummarizeInRepo 在指定仓库目录中总结内容
-func (ccs *ClaudeCodeCLIService) SummarizeInRepo(summaryPrompt string, repoPath string) (string, error) {
-	return ccs.callClaudeCodeCLIInDir(summaryPrompt, repoPath)
+	return &ClaudeCodeCLIService{config: &cfg}
 }
 
-// ReviewCode 代码审查
-func (ccs *ClaudeCodeCLIService) ReviewCode(reviewPrompt string, context string) (string, error) {
-	prompt := ccs.buildReviewPrompt(reviewPrompt, context)
-	return ccs.callClaudeCodeCLI(prompt)
+// GenerateInRepo 在指定仓库目录中生成或修改代码，repoPath为空时只返回生成的文本
+func (ccs *ClaudeCodeCLIService) GenerateInRepo(ctx context.Context, prompt string, repoPath string) (*AgentResult, error) {
+	if repoPath == "" {
+		return ccs.callClaudeCodeCLI(ctx, prompt)
+	}
+	return ccs.callClaudeCodeCLIInDirWithRetry(ctx, prompt, repoPath, 2)
 }
 
-// ReviewCodeInRepo 在指定仓库目录中进行代码审查
-func (ccs *ClaudeCodeCLIService) ReviewCodeInRepo(reviewPrompt string, repoPath string) (string, error) {
-	// 直接使用传入的reviewPrompt，不再调用buildReviewPrompt避免重复
-	return ccs.callClaudeCodeCLIInDir(reviewPrompt, repoPath)
+// ReviewInRepo 在指定仓库目录中进行代码审查
+func (ccs *ClaudeCodeCLIService) ReviewInRepo(ctx context.Context, reviewPrompt string, repoPath string) (*AgentResult, error) {
+	return ccs.callClaudeCodeCLIInDir(ctx, reviewPrompt, repoPath)
 }
 
-// GenerateCodeInRepo 在指定仓库目录中生成代码
-func (ccs *ClaudeCodeCLIService) GenerateCodeInRepo(prompt string, repoPath string) (string, error) {
-	return ccs.callClaudeCodeCLIInDirWithRetry(prompt, repoPath, 2)
+// Summarize 在指定仓库目录中总结内容
+func (ccs *ClaudeCodeCLIService) Summarize(ctx context.Context, summaryPrompt string, repoPath string) (*AgentResult, error) {
+	return ccs.callClaudeCodeCLIInDir(ctx, summaryPrompt, repoPath)
 }
 
 // callClaudeCodeCLIInDirWithRetry 带重试的CLI调用
-func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDirWithRetry(prompt string, workDir string, maxRetries int) (string, error) {
+func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDirWithRetry(ctx context.Context, prompt string, workDir string, maxRetries int) (*AgentResult, error) {
 	var lastErr error
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
 			log.Printf("Claude CLI调用失败，进行第%d次重试", attempt)
-			time.Sleep(time.Duration(attempt) * 2 * time.Second) // 逐渐增加延迟
+			select {
+			case <-time.After(time.Duration(attempt) * 2 * time.Second): // 逐渐增加延迟
+			case <-ctx.Done():
+				return nil, ErrJobCanceled
+			}
 		}
 
-		result, err := ccs.callClaudeCodeCLIInDir(prompt, workDir)
+		result, err := ccs.callClaudeCodeCLIInDir(ctx, prompt, workDir)
 		if err == nil {
 			return result, nil
 		}
 
 		lastErr = err
 		log.Printf("第%d次尝试失败: %v", attempt+1, err)
+
+		var cliErr *CLIError
+		if errors.Is(err, ErrJobCanceled) || (errors.As(err, &cliErr) && !cliErr.Retryable()) {
+			return nil, err
+		}
 	}
 
-	return "", fmt.Errorf("Claude CLI调用在%d次尝试后仍然失败: %v", maxRetries+1, lastErr)
+	return nil, fmt.Errorf("Claude CLI调用在%d次尝试后仍然失败: %v", maxRetries+1, lastErr)
 }
 
 // callClaudeCodeCLI 调用Claude Code CLI
-func (ccs *ClaudeCodeCLIService) callClaudeCodeCLI(prompt string) (string, error) {
-	return ccs.callClaudeCodeCLIInDir(prompt, "")
+func (ccs *ClaudeCodeCLIService) callClaudeCodeCLI(ctx context.Context, prompt string) (*AgentResult, error) {
+	return ccs.callClaudeCodeCLIInDir(ctx, prompt, "")
 }
 
-// callClaudeCodeCLIInDir 在指定目录中调用Claude Code CLI
-func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDir(prompt string, workDir string) (string, error) {
+// callClaudeCodeCLIInDir 在指定目录中调用Claude Code CLI，parent取消时终止CLI进程
+func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDir(parent context.Context, prompt string, workDir string) (*AgentResult, error) {
 	// 检查Claude Code CLI是否已安装
 	if !ccs.isClaudeCodeCLIInstalled() {
-		return "", fmt.Errorf("claude Code CLI未安装，请先运行: npm install -g @anthropic-ai/claude-code")
+		return nil, fmt.Errorf("claude Code CLI未安装，请先运行: npm install -g @anthropic-ai/claude-code")
 	}
 
 	// 构建命令参数
@@ -111,8 +145,8 @@ func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDir(prompt string, workDir s
 	args = append(args, "--allowedTools", "Edit,MultiEdit,Write,NotebookEdit,WebSearch,WebFetch")
 	args = append(args, "--disallowedTools", "Bash")
 
-	// 添加详细输出参数（用于调试）
-	args = append(args, "--verbose")
+	// 非交互模式，输出逐行的JSON事件（stream-json 需要同时指定 --verbose）
+	args = append(args, "--print", "--output-format", "stream-json", "--verbose")
 
 	// 添加模型参数（如果指定）
 	if ccs.config.Model != "" {
@@ -123,8 +157,8 @@ func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDir(prompt string, workDir s
 		ccs.config.Model, ccs.config.BaseURL)
 	log.Printf("提示词长度: %d 字符", len(prompt))
 
-	// 设置环境变量
-	env := os.Environ()
+	// 设置环境变量，不包含GitHub token等服务密钥
+	env := agentEnviron()
 	if ccs.config.APIKey != "" {
 		env = append(env, "ANTHROPIC_API_KEY="+ccs.config.APIKey)
 	}
@@ -150,7 +184,7 @@ func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDir(prompt string, workDir s
 		timeout = time.Duration(ccs.config.TimeoutSeconds) * time.Second
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
 	// 执行命令，使用context控制超时
@@ -166,74 +200,107 @@ func (ccs *ClaudeCodeCLIService) callClaudeCodeCLIInDir(prompt string, workDir s
 	// 通过stdin传递提示词，避免命令行参数长度限制
 	cmd.Stdin = strings.NewReader(prompt)
 
-	// 使用管道分别获取stdout和stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
+	// stdout为逐行的stream-json事件，边读取边解析；stderr只用于诊断
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("创建Claude CLI输出管道失败: %v", err)
+	}
+	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
+	// CLI被终止后，其子进程可能仍持有输出管道，限制等待时间避免取消后长时间阻塞
+	cmd.WaitDelay = 5 * time.Second
 
 	log.Printf("开始执行Claude CLI命令，超时时间: %v", timeout)
 	log.Printf("命令详情: %s %v", cmd.Path, cmd.Args)
 	log.Printf("工作目录: %s", cmd.Dir)
 	startTime := time.Now()
 
-	// 执行命令
-	log.Printf("开始执行Claude CLI命令")
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动Claude Code CLI失败: %v", err)
+	}
 
-	err := cmd.Run()
+	// 取消或超时时关闭输出管道，使读取循环立即结束
+	readDone := make(chan struct{})
+	defer close(readDone)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stdout.Close()
+		case <-readDone:
+		}
+	}()
+
+	parser := newClaudeStreamParser(workDir)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLineSize)
+	for scanner.Scan() {
+		parser.HandleLine(scanner.Bytes())
+	}
+	scanErr := scanner.Err()
+	if scanErr != nil {
+		// 继续读取剩余输出，避免CLI因管道阻塞无法退出
+		io.Copy(io.Discard, stdout)
+	}
+
+	err = cmd.Wait()
 	duration := time.Since(startTime)
 
 	log.Printf("Claude CLI执行完成，耗时: %v", duration)
 
-	// 获取输出内容
-	outputStr := strings.TrimSpace(stdout.String())
 	stderrStr := strings.TrimSpace(stderr.String())
-
-	// 记录错误输出（如果有）
 	if stderrStr != "" {
 		log.Printf("Claude CLI stderr: %s", stderrStr)
 	}
 
-	// 处理执行错误
-	if err != nil {
-		if ctx.Err() == context.DeadlineExceeded {
-			return "", fmt.Errorf("claude Code CLI调用超时 (%v)", timeout)
-		}
+	if canceled := canceledError(parent); canceled != nil {
+		log.Printf("Claude CLI已取消")
+		return nil, canceled
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("claude Code CLI调用超时 (%v)", timeout)
+	}
+	if scanErr != nil {
+		return nil, fmt.Errorf("读取Claude Code CLI输出失败: %v", scanErr)
+	}
 
-		errorMsg := "claude Code CLI执行失败: " + err.Error()
-		if stderrStr != "" {
-			errorMsg += fmt.Sprintf(", 错误输出: %s", stderrStr)
+	result, parseErr := parser.Finish()
+	if parseErr != nil {
+		// 结果事件缺失时，进程退出错误和stderr更能说明原因
+		var cliErr *CLIError
+		if errors.As(parseErr, &cliErr) && cliErr.Subtype == cliResultMissingEvent && err != nil {
+			errorMsg := err.Error()
+			if stderrStr != "" {
+				errorMsg += fmt.Sprintf(", 错误输出: %s", stderrStr)
+			}
+			return nil, &CLIError{Subtype: cliResultDuringExec, Message: errorMsg, Result: cliErr.Result}
 		}
-		return "", fmt.Errorf(errorMsg)
+		log.Printf("Claude CLI执行出错: %v", parseErr)
+		return nil, parseErr
 	}
 
-	// 检查输出内容
-	if outputStr == "" {
-		if stderrStr != "" {
-			return "", fmt.Errorf("claude Code CLI没有返回任何输出，错误信息: %s", stderrStr)
-		}
-		return "", fmt.Errorf("claude Code CLI没有返回任何输出")
+	if err != nil {
+		return nil, fmt.Errorf("claude Code CLI报告成功但进程异常退出: %v", err)
 	}
 
-	// 检查是否有明显的CLI执行错误信息（不检查内容中的error词汇）
-	lowerOutput := strings.ToLower(outputStr)
-	if strings.Contains(lowerOutput, "permission denied") ||
-		strings.Contains(lowerOutput, "command not found") ||
-		strings.Contains(lowerOutput, "authentication failed") ||
-		strings.HasPrefix(lowerOutput, "error:") ||
-		strings.HasPrefix(lowerOutput, "fatal:") {
-		log.Printf("Claude CLI执行出错: %s", outputStr)
-		return "", fmt.Errorf("claude Code CLI执行出错: %s", outputStr)
+	if result.Output == "" {
+		return nil, &CLIError{Subtype: cliResultDuringExec, Message: "没有返回任何输出", Result: result}
+	}
+	if result.Duration == 0 {
+		result.Duration = duration
+	}
+	if result.Model == "" {
+		result.Model = ccs.config.Model
 	}
 
 	// 记录输出信息
-	if len(outputStr) < 200 {
-		log.Printf("Claude CLI输出: %s", outputStr)
+	if len(result.Output) < 200 {
+		log.Printf("Claude CLI输出: %s", result.Output)
 	} else {
-		log.Printf("Claude CLI输出长度: %d 字符, 预览: %s...", len(outputStr), outputStr[:200])
+		log.Printf("Claude CLI输出长度: %d 字符, 预览: %s...", len(result.Output), result.Output[:200])
 	}
 
-	log.Printf("Claude Code CLI调用成功，输出长度: %d 字符", len(outputStr))
-	return outputStr, nil
+	log.Printf("Claude Code CLI调用成功，%s", result.Summary())
+	return result, nil
 }
 
 // isClaudeCodeCLIInstalled 检查Claude Code CLI是否已安装
@@ -243,78 +310,6 @@ func (ccs *ClaudeCodeCLIService) isClaudeCodeCLIInstalled() bool {
 	return err == nil
 }
 
-// buildCodeGenerationPrompt 构建代码生成提示
-func (ccs *ClaudeCodeCLIService) buildCodeGenerationPrompt(requirement string, context string) string {
-	return fmt.Sprintf("你是一个专业的软件开发助手，专门帮助用户生成高质量的代码。\n\n"+
-		"**需求描述:**\n"+
-		"%s\n\n"+
-		"**项目上下文:**\n"+
-		"%s\n\n"+
-		"**要求:**\n"+
-		"1. 生成完整、可运行的代码\n"+
-		"2. 包含必要的注释和文档\n"+
-		"3. 遵循最佳实践和代码规范\n"+
-		"4. 考虑错误处理和边界情况\n"+
-		"5. 如果涉及多个文件，请明确标注文件名\n\n"+
-		"**输出格式:**\n"+
-		"请直接输出代码，不需要额外的解释。如果需要多个文件，请使用 ```filename:path/to/file``` 格式标注。\n\n"+
-		"请开始生成代码:", requirement, context)
-}
-
-// buildContinuePrompt 构建继续开发提示
-func (ccs *ClaudeCodeCLIService) buildContinuePrompt(instruction string, context string) string {
-	return fmt.Sprintf("你正在继续一个软件开发项目。请根据以下指令继续开发：\n\n"+
-		"**继续指令:**\n"+
-		"%s\n\n"+
-		"**当前项目上下文:**\n"+
-		"%s\n\n"+
-		"**要求:**\n"+
-		"1. 基于现有代码继续开发\n"+
-		"2. 保持代码风格的一致性\n"+
-		"3. 确保新代码与现有代码兼容\n"+
-		"4. 添加必要的注释说明\n\n"+
-		"请继续开发:", instruction, context)
-}
-
-// buildFixPrompt 构建代码修复提示
-func (ccs *ClaudeCodeCLIService) buildFixPrompt(problem string, codeContext string) string {
-	return fmt.Sprintf("你正在修复代码中的问题。请分析并修复以下问题：\n\n"+
-		"**问题描述:**\n"+
-		"%s\n\n"+
-		"**代码上下文:**\n"+
-		"%s\n\n"+
-		"**要求:**\n"+
-		"1. 分析问题的根本原因\n"+
-		"2. 提供修复方案\n"+
-		"3. 确保修复后的代码正确运行\n"+
-		"4. 添加必要的注释说明修复内容\n\n"+
-		"请修复代码:", problem, codeContext)
-}
-
-// buildReviewPrompt 构建代码审查提示
-func (ccs *ClaudeCodeCLIService) buildReviewPrompt(reviewPrompt string, context string) string {
-	return fmt.Sprintf("你是一个资深的代码审查专家，请对以下代码进行专业的审查：\n\n"+
-		"**审查需求:**\n"+
-		"%s\n\n"+
-		"**项目上下文:**\n"+
-		"%s\n\n"+
-		"**审查标准:**\n"+
-		"1. **代码质量:** 可读性、可维护性、代码结构\n"+
-		"2. **安全性:** 安全漏洞、输入验证、权限控制\n"+
-		"3. **性能:** 算法效率、资源使用、优化机会\n"+
-		"4. **最佳实践:** 设计模式、编码规范、架构原则\n"+
-		"5. **错误处理:** 异常处理、边界条件、容错机制\n"+
-		"6. **测试:** 测试覆盖度、测试质量\n"+
-		"7. **文档:** 代码注释、API文档\n\n"+
-		"**输出要求:**\n"+
-		"- 使用Markdown格式\n"+
-		"- 提供具体的代码位置和建议\n"+
-		"- 按严重程度分类问题（严重/中等/轻微）\n"+
-		"- 给出具体的改进建议和示例代码\n"+
-		"- 提供总体评分和改进建议\n\n"+
-		"请开始代码审查:", reviewPrompt, context)
-}
-
 // maskAPIKey 遮盖API密钥用于日志显示
 func (ccs *ClaudeCodeCLIService) maskAPIKey(apiKey string) string {
 	if len(apiKey) <= 8 {
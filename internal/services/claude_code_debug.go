@@ -2,7 +2,6 @@ package services
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -72,7 +71,7 @@ func (ccs *ClaudeCodeCLIService) getClaudeCLIVersion() (string, error) {
 // testConnection 测试连接
 func (ccs *ClaudeCodeCLIService) testConnection(prompt string) (string, error) {
 	// 设置环境变量
-	env := os.Environ()
+	env := agentEnviron()
 	if ccs.config.APIKey != "" {
 		env = append(env, "ANTHROPIC_API_KEY="+ccs.config.APIKey)
 	}
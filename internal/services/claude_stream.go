@@ -0,0 +1,224 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Claude CLI 结果事件的subtype
+const (
+	cliResultSuccess      = "success"
+	cliResultMaxTurns     = "error_max_turns"
+	cliResultDuringExec   = "error_during_execution"
+	cliResultMissingEvent = "missing_result"
+)
+
+// CLIError Claude CLI 在结果事件中报告的失败
+type CLIError struct {
+	Subtype string       // 失败类型，如 error_max_turns、error_during_execution
+	Message string       // CLI返回的错误说明
+	Result  *AgentResult // 失败前已收集的元数据
+}
+
+// Error 错误信息
+func (e *CLIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("claude Code CLI执行失败: %s", e.Subtype)
+	}
+	return fmt.Sprintf("claude Code CLI执行失败: %s: %s", e.Subtype, e.Message)
+}
+
+// Retryable 是否值得重试，达到最大轮次的任务重试也会得到相同的结果
+func (e *CLIError) Retryable() bool {
+	return e.Subtype != cliResultMaxTurns
+}
+
+// claudeStreamEvent stream-json 输出中的一行事件
+type claudeStreamEvent struct {
+	Type      string `json:"type"`    // system / assistant / user / result
+	Subtype   string `json:"subtype"` // system: init；result: success / error_*
+	SessionID string `json:"session_id"`
+	Model     string `json:"model"`
+	Message   *struct {
+		Content []claudeContentBlock `json:"content"`
+	} `json:"message"`
+	Result       string  `json:"result"`
+	IsError      bool    `json:"is_error"`
+	NumTurns     int     `json:"num_turns"`
+	TotalCostUSD float64 `json:"total_cost_usd"`
+	DurationMs   int64   `json:"duration_ms"`
+}
+
+// claudeContentBlock 消息内容块
+type claudeContentBlock struct {
+	Type      string          `json:"type"` // text / tool_use / tool_result
+	Text      string          `json:"text"`
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Input     json.RawMessage `json:"input"`
+	ToolUseID string          `json:"tool_use_id"`
+	IsError   bool            `json:"is_error"`
+}
+
+// claudeStreamParser 逐行解析 stream-json 输出
+type claudeStreamParser struct {
+	result      *AgentResult
+	workDir     string         // CLI工作目录，文件路径转换为相对路径
+	toolIndex   map[string]int // tool_use id -> ToolCalls下标
+	lastText    string         // 最后一条助手文本，结果事件缺少result时使用
+	resultSeen  bool
+	subtype     string
+	isError     bool
+	resultText  string
+	skippedLine int
+}
+
+// newClaudeStreamParser 创建解析器
+func newClaudeStreamParser(workDir string) *claudeStreamParser {
+	return &claudeStreamParser{
+		result:    &AgentResult{Provider: ProviderClaudeCLI},
+		workDir:   workDir,
+		toolIndex: make(map[string]int),
+	}
+}
+
+// HandleLine 处理一行输出，无法解析的行会被记录并跳过
+func (p *claudeStreamParser) HandleLine(line []byte) {
+	trimmed := strings.TrimSpace(string(line))
+	if trimmed == "" {
+		return
+	}
+
+	var event claudeStreamEvent
+	if err := json.Unmarshal([]byte(trimmed), &event); err != nil {
+		p.skippedLine++
+		log.Printf("跳过无法解析的Claude CLI输出: %s", truncateForLog(trimmed, 200))
+		return
+	}
+
+	if event.SessionID != "" {
+		p.result.SessionID = event.SessionID
+	}
+
+	switch event.Type {
+	case "system":
+		if event.Subtype == "init" && event.Model != "" {
+			p.result.Model = event.Model
+		}
+	case "assistant":
+		if event.Message == nil {
+			return
+		}
+		for _, block := range event.Message.Content {
+			switch block.Type {
+			case "text":
+				if text := strings.TrimSpace(block.Text); text != "" {
+					p.lastText = text
+				}
+			case "tool_use":
+				call := ToolCall{Name: block.Name, Target: p.relativePath(toolTarget(block.Input))}
+				p.toolIndex[block.ID] = len(p.result.ToolCalls)
+				p.result.ToolCalls = append(p.result.ToolCalls, call)
+				log.Printf("Claude CLI工具调用: %s %s", call.Name, call.Target)
+			}
+		}
+	case "user":
+		if event.Message == nil {
+			return
+		}
+		for _, block := range event.Message.Content {
+			if block.Type != "tool_result" || !block.IsError {
+				continue
+			}
+			if index, exists := p.toolIndex[block.ToolUseID]; exists {
+				p.result.ToolCalls[index].IsError = true
+				log.Printf("Claude CLI工具调用失败: %s %s", p.result.ToolCalls[index].Name, p.result.ToolCalls[index].Target)
+			}
+		}
+	case "result":
+		p.resultSeen = true
+		p.subtype = event.Subtype
+		p.isError = event.IsError
+		p.resultText = strings.TrimSpace(event.Result)
+		p.result.NumTurns = event.NumTurns
+		p.result.CostUSD = event.TotalCostUSD
+		if event.DurationMs > 0 {
+			p.result.Duration = time.Duration(event.DurationMs) * time.Millisecond
+		}
+	}
+}
+
+// Finish 在输出结束后返回调用结果，结果事件缺失或报告失败时返回 *CLIError
+func (p *claudeStreamParser) Finish() (*AgentResult, error) {
+	if !p.resultSeen {
+		message := "输出中没有结果事件"
+		if p.skippedLine > 0 {
+			message += fmt.Sprintf("，%d 行输出无法解析，请确认CLI版本支持 --output-format stream-json", p.skippedLine)
+		}
+		return nil, &CLIError{Subtype: cliResultMissingEvent, Message: message, Result: p.result}
+	}
+
+	if p.isError || p.subtype != cliResultSuccess {
+		subtype := p.subtype
+		if subtype == "" || subtype == cliResultSuccess {
+			subtype = cliResultDuringExec
+		}
+		message := p.resultText
+		if message == "" && subtype == cliResultMaxTurns {
+			message = fmt.Sprintf("达到最大对话轮次 (%d)", p.result.NumTurns)
+		}
+		return nil, &CLIError{Subtype: subtype, Message: message, Result: p.result}
+	}
+
+	p.result.Output = p.resultText
+	if p.result.Output == "" {
+		p.result.Output = p.lastText
+	}
+	return p.result, nil
+}
+
+// relativePath 将工作目录下的绝对路径转换为相对路径
+func (p *claudeStreamParser) relativePath(target string) string {
+	if p.workDir == "" || !filepath.IsAbs(target) {
+		return target
+	}
+	if rel, err := filepath.Rel(p.workDir, target); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return target
+}
+
+// toolTarget 从工具参数中提取操作对象
+func toolTarget(input json.RawMessage) string {
+	if len(input) == 0 {
+		return ""
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(input, &fields); err != nil {
+		return ""
+	}
+
+	for _, key := range []string{"file_path", "notebook_path", "path", "url", "pattern", "query"} {
+		if value, ok := fields[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// truncateForLog 截断过长的日志内容，截断位置退到字符边界，避免把多字节字符截成两半
+func truncateForLog(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit] + "..."
+}
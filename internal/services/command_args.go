@@ -0,0 +1,284 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FlagType 命令参数类型
+type FlagType int
+
+const (
+	FlagBool   FlagType = iota // 开关参数，如 --draft
+	FlagString                 // 字符串参数，如 --base develop
+	FlagList                   // 列表参数，可重复或用逗号分隔，如 --files a/**,b/**
+)
+
+// FlagSpec 命令参数定义
+type FlagSpec struct {
+	Name   string   // 参数名称，不含 --
+	Type   FlagType // 参数类型
+	Usage  string   // 参数说明
+	Values []string // 允许的取值，为空时不限制
+}
+
+// String 参数的用法描述，如 "--base <value>"
+func (f FlagSpec) String() string {
+	switch f.Type {
+	case FlagBool:
+		return "--" + f.Name
+	default:
+		if len(f.Values) > 0 {
+			return fmt.Sprintf("--%s <%s>", f.Name, strings.Join(f.Values, "|"))
+		}
+		return fmt.Sprintf("--%s <value>", f.Name)
+	}
+}
+
+// Command 命令结构
+type Command struct {
+	Command string
+	Args    string            // 去除参数后的自由文本，包含多行参数
+	RawArgs string            // 命令所在行中命令名称之后的原始文本
+	Body    string            // 命令之后到下一个命令之前的多行文本，不解析参数
+	Flags   map[string]string // 解析后的参数，列表参数以逗号连接
+}
+
+// Flag 获取字符串参数
+func (c *Command) Flag(name string) string {
+	return c.Flags[name]
+}
+
+// BoolFlag 获取开关参数
+func (c *Command) BoolFlag(name string) bool {
+	value, err := strconv.ParseBool(c.Flags[name])
+	return err == nil && value
+}
+
+// ListFlag 获取列表参数
+func (c *Command) ListFlag(name string) []string {
+	value := c.Flags[name]
+	if value == "" {
+		return nil
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// FlagError 参数解析错误
+type FlagError struct {
+	Message string
+}
+
+// Error 错误信息
+func (e *FlagError) Error() string {
+	return e.Message
+}
+
+// flagNamePattern 参数形式：--name 或 --name=value
+var flagNamePattern = regexp.MustCompile(`^--([a-zA-Z][a-zA-Z0-9_-]*)(?:=(.*))?$`)
+
+// argToken 参数分词结果
+type argToken struct {
+	value  string // 去除引号后的值
+	raw    string // 原始文本
+	quoted bool   // 是否带引号
+}
+
+// ParseFlags 根据参数定义解析RawArgs，解析结果写入Flags，剩余文本和Body写入Args
+func (c *Command) ParseFlags(specs []FlagSpec) error {
+	c.Flags = make(map[string]string)
+	if c.RawArgs == "" && c.Body == "" {
+		c.RawArgs = c.Args
+	}
+
+	specMap := make(map[string]FlagSpec)
+	for _, spec := range specs {
+		specMap[spec.Name] = spec
+	}
+
+	tokens, err := tokenizeArgs(c.RawArgs)
+	if err != nil {
+		return err
+	}
+
+	var text []string
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+
+		// -- 之后全部作为文本
+		if !token.quoted && token.value == "--" {
+			for _, rest := range tokens[i+1:] {
+				text = append(text, rest.raw)
+			}
+			break
+		}
+
+		matches := flagNamePattern.FindStringSubmatch(token.value)
+		if token.quoted || matches == nil {
+			if !token.quoted && strings.HasPrefix(token.value, "--") {
+				return &FlagError{Message: fmt.Sprintf("无法识别的参数格式: `%s`", token.value)}
+			}
+			text = append(text, token.raw)
+			continue
+		}
+
+		name := matches[1]
+		spec, exists := specMap[name]
+		if !exists {
+			return &FlagError{Message: fmt.Sprintf("不支持的参数: `--%s`", name)}
+		}
+
+		hasValue := strings.Contains(token.value, "=")
+		value := matches[2]
+
+		switch spec.Type {
+		case FlagBool:
+			if !hasValue {
+				value = "true"
+			}
+			if _, err := strconv.ParseBool(value); err != nil {
+				return &FlagError{Message: fmt.Sprintf("参数 `--%s` 只接受 true/false，收到: `%s`", name, value)}
+			}
+		default:
+			if !hasValue {
+				if i+1 >= len(tokens) || (!tokens[i+1].quoted && strings.HasPrefix(tokens[i+1].value, "--")) {
+					return &FlagError{Message: fmt.Sprintf("参数 `--%s` 缺少取值", name)}
+				}
+				i++
+				value = tokens[i].value
+			}
+			if value == "" {
+				return &FlagError{Message: fmt.Sprintf("参数 `--%s` 的取值不能为空", name)}
+			}
+			if len(spec.Values) > 0 && !containsString(spec.Values, value) {
+				return &FlagError{Message: fmt.Sprintf("参数 `--%s` 的取值必须是 %s 之一，收到: `%s`",
+					name, strings.Join(spec.Values, "/"), value)}
+			}
+		}
+
+		if spec.Type == FlagList && c.Flags[name] != "" {
+			c.Flags[name] += "," + value
+		} else {
+			c.Flags[name] = value
+		}
+	}
+
+	c.Args = strings.TrimSpace(strings.Join(text, " ") + "\n" + c.Body)
+	return nil
+}
+
+// tokenizeArgs 按空白分词，支持单引号和双引号
+func tokenizeArgs(input string) ([]argToken, error) {
+	var tokens []argToken
+	var value, raw strings.Builder
+	var quote rune
+	inToken, quoted := false, false
+
+	flush := func() {
+		if inToken {
+			tokens = append(tokens, argToken{value: value.String(), raw: raw.String(), quoted: quoted})
+		}
+		value.Reset()
+		raw.Reset()
+		inToken, quoted = false, false
+	}
+
+	for _, r := range input {
+		switch {
+		case quote != 0:
+			raw.WriteRune(r)
+			if r == quote {
+				quote = 0
+			} else {
+				value.WriteRune(r)
+			}
+		case (r == '"' || r == '\'') && !inToken:
+			// 只有位于词首的引号才开始引用，单词中间的撇号（如 don't）按普通字符处理
+			quote = r
+			inToken, quoted = true, true
+			raw.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		default:
+			inToken = true
+			value.WriteRune(r)
+			raw.WriteRune(r)
+		}
+	}
+
+	if quote != 0 {
+		return nil, &FlagError{Message: fmt.Sprintf("引号未闭合: `%c`", quote)}
+	}
+	flush()
+
+	return tokens, nil
+}
+
+// containsString 检查切片中是否包含指定字符串
+func containsString(items []string, target string) bool {
+	for _, item := range items {
+		if item == target {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAnyPathPattern 检查路径是否匹配任意一个路径模式
+func matchAnyPathPattern(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matchPathPattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathPattern 检查路径是否匹配路径模式
+// 支持 * 和 ? 匹配单级路径中的字符，** 匹配任意多级目录；不含通配符的模式按目录前缀匹配
+func matchPathPattern(pattern, path string) bool {
+	pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "./")
+	path = strings.TrimPrefix(path, "./")
+
+	if !strings.ContainsAny(pattern, "*?") {
+		pattern = strings.TrimSuffix(pattern, "/")
+		return path == pattern || strings.HasPrefix(path, pattern+"/")
+	}
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					// **/ 匹配零个或多个目录
+					i++
+					expr.WriteString("(?:.*/)?")
+				} else {
+					expr.WriteString(".*")
+				}
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), path)
+	return err == nil && matched
+}
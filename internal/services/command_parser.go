@@ -0,0 +1,158 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+)
+
+// listMarkerPattern Markdown列表标记，如 "- "、"* "、"1. "
+var listMarkerPattern = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+`)
+
+// mentionPattern 行首的@提及，如 "@codeagent "
+var mentionPattern = regexp.MustCompile(`^@[A-Za-z0-9][A-Za-z0-9-]*(?:\[bot\])?[,:]?\s+`)
+
+// codeFence 代码块围栏
+type codeFence struct {
+	marker byte // ` 或 ~
+	length int  // 围栏长度，关闭围栏不能短于开启围栏
+}
+
+// parseCommands 按Markdown规则从文本中解析命令
+// 代码块、引用和缩进代码中的内容不会被识别为命令；命令可以带列表标记或@提及前缀；
+// 命令之后的行作为多行参数，直到遇到下一个命令
+func parseCommands(pattern *regexp.Regexp, text string) []*Command {
+	var commands []*Command
+	var current *Command
+	var body []string
+	var fence *codeFence
+
+	finish := func() {
+		// 第一个命令之前的文本不属于任何命令
+		if current == nil {
+			body = nil
+			return
+		}
+		current.Body = strings.TrimSpace(strings.Join(body, "\n"))
+		current.Args = current.RawArgs
+		if current.Body != "" {
+			current.Args = strings.TrimSpace(current.Args + "\n" + current.Body)
+		}
+		commands = append(commands, current)
+		current, body = nil, nil
+	}
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		// 代码块内的内容只能作为参数
+		if fence != nil {
+			if closesFence(line, fence) {
+				fence = nil
+			}
+			body = append(body, line)
+			continue
+		}
+		if opened := openFence(line); opened != nil {
+			fence = opened
+			body = append(body, line)
+			continue
+		}
+
+		if command := matchCommandLine(pattern, line); command != nil {
+			finish()
+			current = command
+			continue
+		}
+
+		body = append(body, line)
+	}
+	finish()
+
+	return commands
+}
+
+// matchCommandLine 检查一行是否为命令行
+func matchCommandLine(pattern *regexp.Regexp, line string) *Command {
+	// 四个空格以上的缩进是Markdown缩进代码块
+	if indentWidth(line) >= 4 {
+		return nil
+	}
+
+	line = strings.TrimSpace(line)
+
+	// 引用回复中的命令是别人说过的话，不执行
+	if strings.HasPrefix(line, ">") {
+		return nil
+	}
+
+	line = listMarkerPattern.ReplaceAllString(line, "")
+	line = mentionPattern.ReplaceAllString(line, "")
+
+	matches := pattern.FindStringSubmatch(line)
+	if len(matches) < 2 {
+		return nil
+	}
+
+	return &Command{
+		Command: matches[1],
+		RawArgs: strings.TrimSpace(matches[2]),
+	}
+}
+
+// openFence 检查一行是否开启代码块
+func openFence(line string) *codeFence {
+	if indentWidth(line) >= 4 {
+		return nil
+	}
+
+	line = strings.TrimSpace(line)
+	if len(line) < 3 || (line[0] != '`' && line[0] != '~') {
+		return nil
+	}
+
+	length := fenceLength(line, line[0])
+	if length < 3 {
+		return nil
+	}
+
+	// 反引号围栏的信息字符串中不能再出现反引号
+	if line[0] == '`' && strings.Contains(line[length:], "`") {
+		return nil
+	}
+
+	return &codeFence{marker: line[0], length: length}
+}
+
+// closesFence 检查一行是否关闭代码块
+func closesFence(line string, fence *codeFence) bool {
+	if indentWidth(line) >= 4 {
+		return false
+	}
+
+	line = strings.TrimSpace(line)
+	length := fenceLength(line, fence.marker)
+	return length >= fence.length && strings.TrimSpace(line[length:]) == ""
+}
+
+// fenceLength 行首连续围栏字符的数量
+func fenceLength(line string, marker byte) int {
+	length := 0
+	for length < len(line) && line[length] == marker {
+		length++
+	}
+	return length
+}
+
+// indentWidth 行首缩进宽度，制表符按4个空格计算
+func indentWidth(line string) int {
+	width := 0
+	for _, r := range line {
+		switch r {
+		case ' ':
+			width++
+		case '\t':
+			width += 4
+		default:
+			return width
+		}
+	}
+	return width
+}
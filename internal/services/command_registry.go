@@ -0,0 +1,335 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// CommandScope 命令允许使用的上下文
+type CommandScope int
+
+const (
+	ScopeIssue       CommandScope = 1 << iota // Issue中可用
+	ScopePullRequest                          // Pull Request中可用
+	ScopeAll         = ScopeIssue | ScopePullRequest
+)
+
+// Allows 检查命令是否可以在指定上下文中使用
+func (s CommandScope) Allows(ctx *CommandContext) bool {
+	if ctx.PullRequest != nil {
+		return s&ScopePullRequest != 0
+	}
+	return s&ScopeIssue != 0
+}
+
+// String 上下文描述
+func (s CommandScope) String() string {
+	switch s {
+	case ScopeIssue:
+		return "Issue"
+	case ScopePullRequest:
+		return "Pull Request"
+	default:
+		return "Issue / Pull Request"
+	}
+}
+
+// Permission 执行命令所需的最低仓库权限
+type Permission int
+
+const (
+	PermissionRead   Permission = iota // 读权限
+	PermissionTriage                   // 分类权限
+	PermissionWrite                    // 写权限
+	PermissionAdmin                    // 管理员权限
+)
+
+// PermissionNone 对仓库没有任何权限
+const PermissionNone Permission = -1
+
+// ParsePermission 将GitHub仓库角色名称转换为权限，maintain视为写权限
+func ParsePermission(role string) Permission {
+	switch role {
+	case "admin":
+		return PermissionAdmin
+	case "maintain", "write":
+		return PermissionWrite
+	case "triage":
+		return PermissionTriage
+	case "read":
+		return PermissionRead
+	default:
+		return PermissionNone
+	}
+}
+
+// String 权限名称，与GitHub仓库角色名称一致
+func (p Permission) String() string {
+	switch p {
+	case PermissionNone:
+		return "none"
+	case PermissionRead:
+		return "read"
+	case PermissionTriage:
+		return "triage"
+	case PermissionWrite:
+		return "write"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return "unknown"
+	}
+}
+
+// CommandHandler 命令处理器，实现该接口并注册到CommandRegistry即可添加新命令
+type CommandHandler interface {
+	Name() string                                                            // 命令名称，不含斜杠
+	Aliases() []string                                                       // 命令别名
+	Usage() string                                                           // 用法，如 "/code <需求描述>"
+	Help() string                                                            // 帮助说明
+	Scope() CommandScope                                                     // 允许使用的上下文
+	Permission() Permission                                                  // 所需的最低权限
+	Flags() []FlagSpec                                                       // 支持的参数
+	Immediate() bool                                                         // 是否跳过任务队列立即执行
+	Execute(ep *EventProcessor, command *Command, ctx *CommandContext) error // 执行命令
+}
+
+// CommandSpec 基于函数的CommandHandler实现，适合注册简单命令
+type CommandSpec struct {
+	CommandName    string
+	CommandAliases []string
+	UsageText      string
+	HelpText       string
+	Scopes         CommandScope
+	MinPermission  Permission
+	CommandFlags   []FlagSpec
+	RunImmediately bool // 跳过任务队列立即执行，适合 /cancel、/status 等不能排在长任务之后的命令
+	Handler        func(ep *EventProcessor, command *Command, ctx *CommandContext) error
+}
+
+// Name 命令名称
+func (s *CommandSpec) Name() string { return s.CommandName }
+
+// Aliases 命令别名
+func (s *CommandSpec) Aliases() []string { return s.CommandAliases }
+
+// Usage 命令用法
+func (s *CommandSpec) Usage() string {
+	if s.UsageText == "" {
+		return "/" + s.CommandName
+	}
+	return s.UsageText
+}
+
+// Help 帮助说明
+func (s *CommandSpec) Help() string { return s.HelpText }
+
+// Scope 允许使用的上下文，未设置时Issue和PR均可使用
+func (s *CommandSpec) Scope() CommandScope {
+	if s.Scopes == 0 {
+		return ScopeAll
+	}
+	return s.Scopes
+}
+
+// Permission 所需的最低权限
+func (s *CommandSpec) Permission() Permission { return s.MinPermission }
+
+// Flags 支持的参数
+func (s *CommandSpec) Flags() []FlagSpec { return s.CommandFlags }
+
+// Immediate 是否跳过任务队列立即执行
+func (s *CommandSpec) Immediate() bool { return s.RunImmediately }
+
+// Execute 执行命令
+func (s *CommandSpec) Execute(ep *EventProcessor, command *Command, ctx *CommandContext) error {
+	return s.Handler(ep, command, ctx)
+}
+
+// CommandRegistry 命令注册表，维护命令名称、别名到处理器的映射
+type CommandRegistry struct {
+	handlers map[string]CommandHandler
+	ordered  []CommandHandler
+	pattern  *regexp.Regexp
+	mutex    sync.RWMutex
+}
+
+// NewCommandRegistry 创建空的命令注册表
+func NewCommandRegistry() *CommandRegistry {
+	registry := &CommandRegistry{
+		handlers: make(map[string]CommandHandler),
+	}
+	registry.rebuildPattern()
+	return registry
+}
+
+// Register 注册命令处理器，名称或别名冲突时返回错误
+func (r *CommandRegistry) Register(handler CommandHandler) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := append([]string{handler.Name()}, handler.Aliases()...)
+	for _, name := range names {
+		if !commandNamePattern.MatchString(name) {
+			return fmt.Errorf("无效的命令名称: %s", name)
+		}
+		if existing, exists := r.handlers[name]; exists {
+			return fmt.Errorf("命令名称冲突: /%s 已被 /%s 使用", name, existing.Name())
+		}
+	}
+
+	for _, name := range names {
+		r.handlers[name] = handler
+	}
+	r.ordered = append(r.ordered, handler)
+	r.rebuildPattern()
+
+	return nil
+}
+
+// Lookup 根据名称或别名查找命令处理器
+func (r *CommandRegistry) Lookup(name string) (CommandHandler, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	handler, exists := r.handlers[name]
+	return handler, exists
+}
+
+// Handlers 按注册顺序返回所有命令处理器
+func (r *CommandRegistry) Handlers() []CommandHandler {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	handlers := make([]CommandHandler, len(r.ordered))
+	copy(handlers, r.ordered)
+	return handlers
+}
+
+// Pattern 返回匹配已注册命令的正则表达式
+func (r *CommandRegistry) Pattern() *regexp.Regexp {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.pattern
+}
+
+// rebuildPattern 根据已注册命令重建正则表达式，调用方需持有锁
+func (r *CommandRegistry) rebuildPattern() {
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, regexp.QuoteMeta(name))
+	}
+
+	// 长名称优先，避免 /fix 抢先匹配 /fixup 之类的命令
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+
+	if len(names) == 0 {
+		r.pattern = regexp.MustCompile(`a^`)
+		return
+	}
+
+	r.pattern = regexp.MustCompile(`^/(` + strings.Join(names, "|") + `)(?:\s+(.*))?$`)
+}
+
+// reviewSeverities 审查问题的严重程度，从低到高
+var reviewSeverities = []string{"low", "medium", "high", "critical"}
+
+// commandNamePattern 合法的命令名称
+var commandNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// registerBuiltinCommands 注册内置命令
+func registerBuiltinCommands(registry *CommandRegistry) {
+	builtins := []*CommandSpec{
+		{
+			// 适合用于：功能开发、逻辑变更、结构调整 （/code 是基于Issue描述进行修改）
+			CommandName:   "code",
+			UsageText:     "/code [参数] <需求描述>",
+			HelpText:      "自动分析并实现到代码库",
+			Scopes:        ScopeIssue,
+			MinPermission: PermissionWrite,
+			CommandFlags: []FlagSpec{
+				{Name: "base", Type: FlagString, Usage: "基础分支，新分支从该分支创建并作为PR目标分支"},
+				{Name: "draft", Type: FlagBool, Usage: "创建草稿PR"},
+				{Name: "files", Type: FlagList, Usage: "限制修改范围的路径模式，支持 ** 通配，多个用逗号分隔"},
+			},
+			Handler: (*EventProcessor).handleCodeCommand,
+		},
+		{
+			// 适合用于：继续开发、功能扩展、逻辑优化（需要先/code，在功能实现上和code不同的点在于：/continue 是基于/code的代码进行修改，而/code是基于Issue描述进行修改）
+			CommandName:   "continue",
+			UsageText:     "/continue [说明]",
+			HelpText:      "继续当前的开发任务",
+			MinPermission: PermissionWrite,
+			Handler:       (*EventProcessor).handleContinueCommand,
+		},
+		{
+			// 适合用于：代码修复、错误修复、性能优化
+			CommandName:   "fix",
+			UsageText:     "/fix [参数] <问题描述>",
+			HelpText:      "修复代码问题，PR中推送到PR分支，Issue中创建修复PR",
+			MinPermission: PermissionWrite,
+			CommandFlags: []FlagSpec{
+				{Name: "files", Type: FlagList, Usage: "重点关注的路径模式，多个用逗号分隔"},
+			},
+			Handler: (*EventProcessor).handleFixCommand,
+		},
+		{
+			// 适合用于：代码审查、代码优化、代码重构
+			CommandName:   "review",
+			UsageText:     "/review [参数] [范围]",
+			HelpText:      "对代码进行专业审查",
+			MinPermission: PermissionTriage, // 调用AI消耗额度，公开仓库中不允许任何人触发
+			CommandFlags: []FlagSpec{
+				{Name: "severity", Type: FlagString, Usage: "只报告不低于该严重程度的问题", Values: reviewSeverities},
+				{Name: "focus", Type: FlagList, Usage: "审查重点，如 security,performance"},
+			},
+			Handler: (*EventProcessor).handleReviewCommand,
+		},
+		{
+			// 适合用于：总结代码、总结问题、总结需求
+			CommandName:   "summary",
+			UsageText:     "/summary [内容]",
+			HelpText:      "生成项目或内容总结",
+			MinPermission: PermissionTriage,
+			Handler:       (*EventProcessor).handleSummaryCommand,
+		},
+		{
+			CommandName:    "cancel",
+			UsageText:      "/cancel",
+			HelpText:       "取消当前Issue/PR中排队或执行中的任务",
+			MinPermission:  PermissionWrite,
+			RunImmediately: true,
+			Handler:        (*EventProcessor).handleCancelCommand,
+		},
+		{
+			CommandName:    "status",
+			UsageText:      "/status",
+			HelpText:       "查看当前Issue/PR中排队和执行中的任务",
+			MinPermission:  PermissionRead,
+			RunImmediately: true,
+			Handler:        (*EventProcessor).handleStatusCommand,
+		},
+		{
+			CommandName:   "help",
+			UsageText:     "/help",
+			HelpText:      "显示此帮助信息",
+			MinPermission: PermissionRead,
+			Handler:       (*EventProcessor).handleHelpCommand,
+		},
+	}
+
+	for _, spec := range builtins {
+		if err := registry.Register(spec); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -36,13 +36,20 @@ type CommitMessage struct {
 }
 
 // CommitBuilder commit消息构建器
-type CommitBuilder struct{}
+type CommitBuilder struct {
+	scopeRules []CommitScopeRule // 仓库配置的scope映射，优先于内置规则
+}
 
 // NewCommitBuilder 创建新的commit构建器
 func NewCommitBuilder() *CommitBuilder {
 	return &CommitBuilder{}
 }
 
+// NewCommitBuilderWithScopes 创建使用自定义scope映射的commit构建器
+func NewCommitBuilderWithScopes(rules []CommitScopeRule) *CommitBuilder {
+	return &CommitBuilder{scopeRules: rules}
+}
+
 // BuildAutoFixCommit 构建自动修复Issue的commit消息
 func (cb *CommitBuilder) BuildAutoFixCommit(event *models.GitHubEvent, modifiedFiles []string) string {
 	// 检测修改的文件类型来确定scope
@@ -71,6 +78,48 @@ func (cb *CommitBuilder) BuildAutoFixCommit(event *models.GitHubEvent, modifiedF
 	return cb.format(commit)
 }
 
+// BuildContinueCommit 构建在已有分支上继续修改的commit消息，instruction为空时使用Issue标题
+func (cb *CommitBuilder) BuildContinueCommit(event *models.GitHubEvent, instruction string, modifiedFiles []string) string {
+	subject := instruction
+	if strings.TrimSpace(subject) == "" {
+		subject = event.Issue.Title
+	}
+
+	commit := CommitMessage{
+		Type:        cb.detectCommitType(subject, ""),
+		Scope:       cb.detectScope(modifiedFiles),
+		Description: cb.buildDescription(subject),
+		Body:        fmt.Sprintf("由AI助手根据继续开发指令生成的代码修改\n\n修改文件:\n%s", strings.Join(modifiedFiles, "\n")),
+		Footer:      fmt.Sprintf("Refs #%d", event.Issue.Number),
+	}
+
+	return cb.format(commit)
+}
+
+// BuildFixCommit 构建修复问题的commit消息，类型固定为fix
+// Issue上的修复会关闭该Issue，PR上的修复只引用该PR
+func (cb *CommitBuilder) BuildFixCommit(event *models.GitHubEvent, problem string, modifiedFiles []string) string {
+	subject := problem
+	if strings.TrimSpace(subject) == "" {
+		subject = event.Issue.Title
+	}
+
+	footer := fmt.Sprintf("Closes #%d", event.Issue.Number)
+	if event.Type == "pull_request" {
+		footer = fmt.Sprintf("Refs #%d", event.Issue.Number)
+	}
+
+	commit := CommitMessage{
+		Type:        CommitTypeFix,
+		Scope:       cb.detectScope(modifiedFiles),
+		Description: cb.buildDescription(subject),
+		Body:        fmt.Sprintf("由AI助手根据修复指令生成的代码修改\n\n修改文件:\n%s", strings.Join(modifiedFiles, "\n")),
+		Footer:      footer,
+	}
+
+	return cb.format(commit)
+}
+
 // BuildPRCommit 构建PR相关的commit消息
 func (cb *CommitBuilder) BuildPRCommit(prTitle, prDescription string, prNumber int) string {
 	// 根据PR标题检测类型
@@ -111,6 +160,13 @@ func (cb *CommitBuilder) detectScope(files []string) string {
 
 // getFileScope 根据文件路径确定scope
 func (cb *CommitBuilder) getFileScope(filePath string) string {
+	// 仓库配置的映射按顺序匹配
+	for _, rule := range cb.scopeRules {
+		if matchPathPattern(rule.Path, filePath) {
+			return rule.Scope
+		}
+	}
+
 	dir := filepath.Dir(filePath)
 	filename := filepath.Base(filePath)
 
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DeliveryLedger 记录已处理的X-GitHub-Delivery，防止重复投递被处理两次
+type DeliveryLedger struct {
+	path  string
+	ttl   time.Duration
+	seen  map[string]time.Time
+	mutex sync.Mutex
+}
+
+// NewDeliveryLedger 创建新的投递记录，并从文件加载未过期的记录
+func NewDeliveryLedger(path string, ttl time.Duration) *DeliveryLedger {
+	ledger := &DeliveryLedger{
+		path: path,
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+	}
+
+	if err := ledger.load(); err != nil {
+		log.Printf("加载投递记录失败: %v", err)
+	}
+
+	return ledger
+}
+
+// MarkSeen 记录投递ID，如果该ID在有效期内已经记录过则返回false
+func (l *DeliveryLedger) MarkSeen(deliveryID string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if seenAt, exists := l.seen[deliveryID]; exists && time.Since(seenAt) < l.ttl {
+		return false
+	}
+
+	l.seen[deliveryID] = time.Now()
+	l.persist()
+	return true
+}
+
+// Forget 删除投递记录，使该投递可以被再次处理
+func (l *DeliveryLedger) Forget(deliveryID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, exists := l.seen[deliveryID]; !exists {
+		return
+	}
+
+	delete(l.seen, deliveryID)
+	l.persist()
+}
+
+// load 从文件加载投递记录
+func (l *DeliveryLedger) load() error {
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取投递记录失败: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &l.seen); err != nil {
+		return fmt.Errorf("解析投递记录失败: %v", err)
+	}
+
+	l.prune()
+	return nil
+}
+
+// persist 清理过期记录后写入文件，调用方需持有锁
+func (l *DeliveryLedger) persist() {
+	l.prune()
+
+	data, err := json.Marshal(l.seen)
+	if err != nil {
+		log.Printf("序列化投递记录失败: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		log.Printf("创建投递记录目录失败: %v", err)
+		return
+	}
+
+	tmpPath := l.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		log.Printf("写入投递记录失败: %v", err)
+		return
+	}
+
+	if err := os.Rename(tmpPath, l.path); err != nil {
+		log.Printf("保存投递记录失败: %v", err)
+	}
+}
+
+// prune 删除过期记录，调用方需持有锁
+func (l *DeliveryLedger) prune() {
+	for deliveryID, seenAt := range l.seen {
+		if time.Since(seenAt) >= l.ttl {
+			delete(l.seen, deliveryID)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webhook-demo/internal/models"
+)
+
+// sensitiveHeaders 不记录到磁盘的请求头
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+}
+
+// deliveryIDSanitizer 投递ID中只保留可用于文件名的字符
+var deliveryIDSanitizer = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// RecordedDelivery 记录的webhook原始投递
+type RecordedDelivery struct {
+	ID         string            `json:"id"`
+	EventType  string            `json:"event_type"`
+	Headers    map[string]string `json:"headers"`
+	Payload    []byte            `json:"payload"`
+	ReceivedAt time.Time         `json:"received_at"`
+}
+
+// DeliverySummary 投递记录摘要
+type DeliverySummary struct {
+	ID         string    `json:"id"`
+	EventType  string    `json:"event_type"`
+	Size       int       `json:"size"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// ToEvent 将记录的投递还原为GitHub事件
+func (d *RecordedDelivery) ToEvent() *models.GitHubEvent {
+	return &models.GitHubEvent{
+		Type:       d.EventType,
+		DeliveryID: d.ID,
+		Payload:    d.Payload,
+	}
+}
+
+// DeliveryRecorder 将webhook原始投递（请求头和payload）保存到磁盘，用于调试和重放
+type DeliveryRecorder struct {
+	dir      string
+	maxAge   time.Duration
+	maxCount int
+	mutex    sync.Mutex
+}
+
+// NewDeliveryRecorder 创建新的投递记录器，maxAge和maxCount为保留策略，0表示不限制
+func NewDeliveryRecorder(dir string, maxAge time.Duration, maxCount int) (*DeliveryRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建投递记录目录失败: %v", err)
+	}
+
+	return &DeliveryRecorder{
+		dir:      dir,
+		maxAge:   maxAge,
+		maxCount: maxCount,
+	}, nil
+}
+
+// Record 保存一次投递，并按保留策略清理旧记录
+func (r *DeliveryRecorder) Record(deliveryID, eventType string, header http.Header, payload []byte) error {
+	if deliveryID == "" {
+		deliveryID = fmt.Sprintf("local-%d", time.Now().UnixNano())
+	}
+
+	headers := make(map[string]string)
+	for key, values := range header {
+		if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		headers[key] = strings.Join(values, ", ")
+	}
+
+	delivery := &RecordedDelivery{
+		ID:         deliveryID,
+		EventType:  eventType,
+		Headers:    headers,
+		Payload:    payload,
+		ReceivedAt: time.Now(),
+	}
+
+	data, err := json.MarshalIndent(delivery, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化投递记录失败: %v", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	path := r.path(deliveryID)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("写入投递记录失败: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("保存投递记录失败: %v", err)
+	}
+
+	r.prune()
+	return nil
+}
+
+// Get 获取投递记录
+func (r *DeliveryRecorder) Get(deliveryID string) (*RecordedDelivery, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data, err := os.ReadFile(r.path(deliveryID))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("投递记录不存在: %s", deliveryID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取投递记录失败: %v", err)
+	}
+
+	var delivery RecordedDelivery
+	if err := json.Unmarshal(data, &delivery); err != nil {
+		return nil, fmt.Errorf("解析投递记录失败: %v", err)
+	}
+
+	return &delivery, nil
+}
+
+// List 列出投递记录摘要，最新的在前
+func (r *DeliveryRecorder) List() ([]DeliverySummary, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entries, err := r.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]DeliverySummary, 0, len(entries))
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(r.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		var delivery RecordedDelivery
+		if err := json.Unmarshal(data, &delivery); err != nil {
+			continue
+		}
+
+		summaries = append(summaries, DeliverySummary{
+			ID:         delivery.ID,
+			EventType:  delivery.EventType,
+			Size:       len(delivery.Payload),
+			ReceivedAt: delivery.ReceivedAt,
+		})
+	}
+
+	return summaries, nil
+}
+
+// entries 列出记录文件，按修改时间倒序，调用方需持有锁
+func (r *DeliveryRecorder) entries() ([]os.DirEntry, error) {
+	all, err := os.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取投递记录目录失败: %v", err)
+	}
+
+	var entries []os.DirEntry
+	modTimes := make(map[string]time.Time)
+	for _, entry := range all {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		modTimes[entry.Name()] = info.ModTime()
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return modTimes[entries[i].Name()].After(modTimes[entries[j].Name()])
+	})
+
+	return entries, nil
+}
+
+// prune 按保留策略删除旧记录，调用方需持有锁
+func (r *DeliveryRecorder) prune() {
+	entries, err := r.entries()
+	if err != nil {
+		log.Printf("清理投递记录失败: %v", err)
+		return
+	}
+
+	for i, entry := range entries {
+		expired := false
+		if r.maxCount > 0 && i >= r.maxCount {
+			expired = true
+		}
+		if r.maxAge > 0 {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > r.maxAge {
+				expired = true
+			}
+		}
+
+		if expired {
+			if err := os.Remove(filepath.Join(r.dir, entry.Name())); err != nil {
+				log.Printf("删除投递记录失败 %s: %v", entry.Name(), err)
+			}
+		}
+	}
+}
+
+// path 投递记录文件路径
+func (r *DeliveryRecorder) path(deliveryID string) string {
+	return filepath.Join(r.dir, deliveryIDSanitizer.ReplaceAllString(deliveryID, "_")+".json")
+}
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 大型PR的diff按文件拆分，过滤锁文件、生成文件和第三方代码后按token预算分块，每块单独审查
+const (
+	reviewChunkTokens = 12000 // 每次审查的diff token预算
+	maxReviewChunks   = 8     // 单次审查最多的分块数，超出预算的低优先级文件不审查
+)
+
+// fileDiff 一个文件的diff
+type fileDiff struct {
+	Path   string   // 文件路径，删除的文件为原路径
+	Header string   // diff --git 到第一个 @@ 之前的文件头
+	Hunks  []string // 每个hunk，以 @@ 行开头
+	Binary bool     // 二进制文件没有hunk
+}
+
+// text 文件完整的diff
+func (f fileDiff) text() string {
+	return f.Header + strings.Join(f.Hunks, "")
+}
+
+// SkippedFile 未审查的文件及原因
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+// reviewChunk 一次审查的diff分块
+type reviewChunk struct {
+	Diff   string   // 分块的diff
+	Files  []string // 分块包含的文件
+	tokens int
+}
+
+// reviewPlan 分块审查计划
+type reviewPlan struct {
+	Chunks  []reviewChunk
+	Skipped []SkippedFile
+}
+
+// splitFileDiffs 将unified diff拆分为每个文件的diff，不是git diff格式的内容返回空
+func splitFileDiffs(diff string) []fileDiff {
+	var files []fileDiff
+	var current *fileDiff
+	var header, hunk strings.Builder
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if hunk.Len() > 0 {
+			current.Hunks = append(current.Hunks, hunk.String())
+			hunk.Reset()
+		}
+		current.Header = header.String()
+		header.Reset()
+		files = append(files, *current)
+	}
+
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			flush()
+			current = &fileDiff{}
+			if index := strings.LastIndex(line, " b/"); index >= 0 {
+				current.Path = strings.TrimRight(line[index+len(" b/"):], "\n")
+			}
+			header.WriteString(line)
+		case current == nil:
+			continue
+		case strings.HasPrefix(line, "@@"):
+			if hunk.Len() > 0 {
+				current.Hunks = append(current.Hunks, hunk.String())
+				hunk.Reset()
+			}
+			hunk.WriteString(line)
+		case hunk.Len() > 0:
+			hunk.WriteString(line)
+		default:
+			// 文件头中的 +++ 行给出新文件路径，删除的文件没有该行，保留原路径
+			if strings.HasPrefix(line, "+++ b/") {
+				current.Path = strings.TrimRight(strings.TrimPrefix(line, "+++ b/"), "\n")
+			}
+			if strings.HasPrefix(line, "Binary files ") || strings.HasPrefix(line, "GIT binary patch") {
+				current.Binary = true
+			}
+			header.WriteString(line)
+		}
+	}
+	flush()
+
+	return files
+}
+
+var (
+	// lockFileNames 依赖锁文件
+	lockFileNames = []string{
+		"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "npm-shrinkwrap.json",
+		"Cargo.lock", "Gemfile.lock", "composer.lock", "poetry.lock", "Pipfile.lock", "uv.lock",
+		"packages.lock.json", "mix.lock", "pubspec.lock", "Podfile.lock",
+	}
+	// vendoredDirs 第三方代码目录
+	vendoredDirs = []string{"vendor", "node_modules", "third_party", "bower_components"}
+	// generatedFilePattern 按文件名识别的生成文件
+	generatedFilePattern = regexp.MustCompile(`(\.pb\.go|\.pb\.gw\.go|_generated\.go|\.gen\.go|\.min\.js|\.min\.css|\.map|\.snap)$|(^|/)dist/`)
+	// generatedMarkerPattern 生成代码的标准标记，必须独占一行，见 https://go.dev/s/generatedcode
+	generatedMarkerPattern = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+	// topHunkPattern 从新文件第1行开始的hunk头，新文件和修改了文件开头的diff才能看到生成标记
+	topHunkPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+1(?:,\d+)? @@`)
+)
+
+// skipReason 不需要审查的文件返回原因
+func skipReason(file fileDiff) string {
+	if file.Binary {
+		return "二进制文件"
+	}
+	if containsString(lockFileNames, path.Base(file.Path)) {
+		return "依赖锁文件"
+	}
+	for _, dir := range vendoredDirs {
+		if strings.HasPrefix(file.Path, dir+"/") || strings.Contains(file.Path, "/"+dir+"/") {
+			return "第三方代码"
+		}
+	}
+	if generatedFilePattern.MatchString(file.Path) {
+		return "生成文件"
+	}
+	if hasGeneratedMarker(file) {
+		return "生成文件"
+	}
+	if len(file.Hunks) == 0 {
+		return "没有内容变更"
+	}
+	return ""
+}
+
+// hasGeneratedMarker 检查文件开头是否带有生成代码的标记
+// 只查看从第1行开始的hunk中 package 子句之前的行，文件其他位置出现的标记（如文档、字符串中）不算
+func hasGeneratedMarker(file fileDiff) bool {
+	if len(file.Hunks) == 0 {
+		return false
+	}
+	lines := strings.Split(file.Hunks[0], "\n")
+	if !topHunkPattern.MatchString(lines[0]) {
+		return false
+	}
+	for _, line := range lines[1:] {
+		// 删除的行和 "\ No newline at end of file" 不属于新文件
+		if line == "" || (line[0] != '+' && line[0] != ' ') {
+			continue
+		}
+		text := strings.TrimSuffix(line[1:], "\r")
+		if strings.HasPrefix(text, "package ") {
+			return false
+		}
+		if generatedMarkerPattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// filePriority 审查优先级，数值越小越优先：源码、测试、配置、文档
+func filePriority(filePath string) int {
+	base := strings.ToLower(path.Base(filePath))
+	ext := path.Ext(base)
+	switch {
+	case strings.Contains(base, "_test.") || strings.Contains(base, ".test.") || strings.Contains(base, ".spec.") ||
+		strings.HasPrefix(filePath, "test/") || strings.HasPrefix(filePath, "tests/") || strings.Contains(filePath, "/test/"):
+		return 1
+	case ext == ".md" || ext == ".txt" || ext == ".rst" || strings.HasPrefix(filePath, "docs/"):
+		return 3
+	case ext == ".json" || ext == ".yml" || ext == ".yaml" || ext == ".toml" || ext == ".ini" || ext == ".env" || ext == "":
+		return 2
+	default:
+		return 0
+	}
+}
+
+// estimateTokens 粗略估计文本的token数：ASCII约4个字符一个token，其他字符按一个token计
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 0x80 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return ascii/4 + other + 1
+}
+
+// planReview 拆分diff并制定分块审查计划
+// 过滤不需要审查的文件，其余文件按优先级排序后装入不超过budget的分块；
+// 超过maxChunks时低优先级的文件不审查，并记录在Skipped中
+func planReview(diff string, budget, maxChunks int) *reviewPlan {
+	plan := &reviewPlan{}
+
+	var files []fileDiff
+	for _, file := range splitFileDiffs(diff) {
+		if reason := skipReason(file); reason != "" {
+			plan.Skipped = append(plan.Skipped, SkippedFile{Path: file.Path, Reason: reason})
+			continue
+		}
+		files = append(files, file)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return filePriority(files[i].Path) < filePriority(files[j].Path)
+	})
+
+	for _, file := range files {
+		pieces := splitFileDiff(file, budget)
+		placed := 0
+		for _, piece := range pieces {
+			tokens := estimateTokens(piece)
+			last := len(plan.Chunks) - 1
+			switch {
+			case last >= 0 && plan.Chunks[last].tokens+tokens <= budget:
+				plan.Chunks[last].add(file.Path, piece, tokens)
+			case len(plan.Chunks) < maxChunks:
+				plan.Chunks = append(plan.Chunks, reviewChunk{})
+				plan.Chunks[last+1].add(file.Path, piece, tokens)
+			default:
+				continue
+			}
+			placed++
+		}
+
+		switch {
+		case placed == 0:
+			plan.Skipped = append(plan.Skipped, SkippedFile{Path: file.Path, Reason: "超出审查预算"})
+		case placed < len(pieces):
+			plan.Skipped = append(plan.Skipped, SkippedFile{Path: file.Path,
+				Reason: fmt.Sprintf("超出审查预算，只审查了 %d/%d 部分", placed, len(pieces))})
+		}
+	}
+
+	return plan
+}
+
+// add 将一个文件的diff片段加入分块
+func (c *reviewChunk) add(file, piece string, tokens int) {
+	c.Diff += piece
+	c.tokens += tokens
+	if len(c.Files) == 0 || c.Files[len(c.Files)-1] != file {
+		c.Files = append(c.Files, file)
+	}
+}
+
+// splitFileDiff 将超出budget的文件diff按hunk拆分，每个片段都带有文件头
+func splitFileDiff(file fileDiff, budget int) []string {
+	if estimateTokens(file.text()) <= budget {
+		return []string{file.text()}
+	}
+
+	var pieces []string
+	var current strings.Builder
+	headerTokens := estimateTokens(file.Header)
+	for _, hunk := range file.Hunks {
+		for _, part := range splitHunk(hunk, budget-headerTokens) {
+			if current.Len() > 0 && estimateTokens(current.String())+estimateTokens(part) > budget {
+				pieces = append(pieces, current.String())
+				current.Reset()
+			}
+			if current.Len() == 0 {
+				current.WriteString(file.Header)
+			}
+			current.WriteString(part)
+		}
+	}
+	if current.Len() > 0 {
+		pieces = append(pieces, current.String())
+	}
+	return pieces
+}
+
+// hunkHeaderPattern hunk头，捕获原文件和新文件的起始行号
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// splitHunk 在行边界拆分超出budget的hunk，续接的片段使用新的hunk头保持行号正确
+func splitHunk(hunk string, budget int) []string {
+	if estimateTokens(hunk) <= budget {
+		return []string{hunk}
+	}
+
+	lines := strings.SplitAfter(hunk, "\n")
+	match := hunkHeaderPattern.FindStringSubmatch(lines[0])
+	if match == nil {
+		return []string{hunk}
+	}
+	oldLine, _ := strconv.Atoi(match[1])
+	newLine, _ := strconv.Atoi(match[2])
+
+	var pieces []string
+	current, hasBody := lines[0], false
+	for _, line := range lines[1:] {
+		if hasBody && estimateTokens(current)+estimateTokens(line) > budget {
+			pieces = append(pieces, current)
+			current, hasBody = fmt.Sprintf("@@ -%d +%d @@ (续)\n", oldLine, newLine), false
+		}
+		current += line
+		hasBody = true
+
+		if line == "" {
+			continue
+		}
+		switch line[0] {
+		case ' ':
+			oldLine++
+			newLine++
+		case '-':
+			oldLine++
+		case '+':
+			newLine++
+		}
+	}
+	return append(pieces, current)
+}
+
+// skippedFilesMarkdown 未审查文件的列表
+func skippedFilesMarkdown(skipped []SkippedFile) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+
+	var content strings.Builder
+	content.WriteString("### 未审查的文件\n")
+	for _, file := range skipped {
+		fmt.Fprintf(&content, "\n- `%s` %s", file.Path, file.Reason)
+	}
+	return content.String()
+}
@@ -1,36 +1,117 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"regexp"
+	"os"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/webhook-demo/internal/models"
 )
 
 // EventProcessor 事件处理器
 type EventProcessor struct {
-	githubService     *GitHubService
-	claudeCodeService *ClaudeCodeCLIService
-	gitService        *GitService
-	commandRegex      *regexp.Regexp
+	githubService *GitHubService
+	agents        *AgentRegistry
+	gitService    *GitService
+	commands      *CommandRegistry
+	jobs          *JobTracker
 }
 
 // NewEventProcessor 创建新的事件处理器
-func NewEventProcessor(githubService *GitHubService, claudeCodeService *ClaudeCodeCLIService, gitService *GitService) *EventProcessor {
+// agent为默认的AI服务提供方，其他提供方通过Agents()注册
+func NewEventProcessor(githubService *GitHubService, agent AgentProvider, gitService *GitService) *EventProcessor {
+	commands := NewCommandRegistry()
+	registerBuiltinCommands(commands)
+
 	return &EventProcessor{
-		githubService:     githubService,
-		claudeCodeService: claudeCodeService,
-		gitService:        gitService,
-		commandRegex:      regexp.MustCompile(`^/(code|continue|fix|help|review|summary)\s*(.*)$`),
+		githubService: githubService,
+		agents:        NewAgentRegistry(agent),
+		gitService:    gitService,
+		commands:      commands,
+		jobs:          NewJobTracker(),
+	}
+}
+
+// Agents 返回AI服务提供方注册表，用于注册提供方和按命令选择提供方
+func (ep *EventProcessor) Agents() *AgentRegistry {
+	return ep.agents
+}
+
+// Jobs 返回任务跟踪器，任务队列通过它登记任务，/cancel 和 /status 通过它查询和取消任务
+func (ep *EventProcessor) Jobs() *JobTracker {
+	return ep.jobs
+}
+
+// IsImmediate 检查命令是否都应跳过任务队列立即执行
+func (ep *EventProcessor) IsImmediate(commands []*Command) bool {
+	if len(commands) == 0 {
+		return false
 	}
+
+	for _, command := range commands {
+		handler, exists := ep.commands.Lookup(command.Command)
+		if !exists || !handler.Immediate() {
+			return false
+		}
+	}
+	return true
+}
+
+// RegisterCommand 注册自定义命令，无需修改事件处理逻辑即可扩展命令
+func (ep *EventProcessor) RegisterCommand(handler CommandHandler) error {
+	return ep.commands.Register(handler)
+}
+
+// Reply 在命令所在的Issue或PR中回复，供自定义命令使用
+func (ep *EventProcessor) Reply(ctx *CommandContext, body string) error {
+	return ep.createResponse(ctx, body)
 }
 
-// ProcessEvent 处理GitHub事件
-func (ep *EventProcessor) ProcessEvent(event *models.GitHubEvent) error {
+// RememberRepository 记录webhook payload中仓库所在的GitHub实例和GitHub App安装，应在签名验证通过之后、事件入队之前调用
+// 仓库不在配置的实例上（如GitHub Enterprise Server）时，之后对该仓库的API调用和git操作使用由 repository.html_url 推导出的地址
+func (ep *EventProcessor) RememberRepository(event *models.GitHubEvent) {
+	var payload struct {
+		Installation models.Installation `json:"installation"`
+		Repository   models.Repository   `json:"repository"`
+	}
+	if err := event.ParsePayload(&payload); err != nil {
+		return
+	}
+
+	if payload.Repository.HTMLURL != "" {
+		endpoints := ep.githubService.RememberRepository(payload.Repository.Owner.Login, payload.Repository.Name, payload.Repository.HTMLURL)
+		ep.gitService.AddCredentialHost(endpoints.GitHost)
+	}
+
+	if app := ep.githubService.App(); app != nil {
+		owner := payload.Installation.Account.Login
+		if owner == "" {
+			owner = payload.Repository.Owner.Login
+		}
+		app.RememberInstallation(owner, payload.Installation.ID)
+	}
+}
+
+// commitAuthor 提交使用的作者名称和邮箱，使用GitHub App时为App的机器人身份
+func (ep *EventProcessor) commitAuthor() (string, string) {
+	if app := ep.githubService.App(); app != nil {
+		name, email, err := app.Identity()
+		if err == nil {
+			return name, email
+		}
+		log.Printf("获取GitHub App机器人身份失败，使用默认的提交身份: %v", err)
+	}
+	return "CodeAgent", "codeagent@example.com"
+}
+
+// ProcessEvent 处理GitHub事件，jobCtx取消时终止正在执行的命令
+func (ep *EventProcessor) ProcessEvent(jobCtx context.Context, event *models.GitHubEvent) error {
 	log.Printf("开始处理事件: Type=%s, DeliveryID=%s", event.Type, event.DeliveryID)
 
 	// 设置时间戳
@@ -38,15 +119,15 @@ func (ep *EventProcessor) ProcessEvent(event *models.GitHubEvent) error {
 
 	switch event.Type {
 	case "issues": // 处理Issue事件
-		return ep.handleIssuesEvent(event)
+		return ep.handleIssuesEvent(jobCtx, event)
 	case "issue_comment": // 处理Issue评论事件
-		return ep.handleIssueCommentEvent(event)
+		return ep.handleIssueCommentEvent(jobCtx, event)
 	case "pull_request": // 处理Pull Request事件
-		return ep.handlePullRequestEvent(event)
+		return ep.handlePullRequestEvent(jobCtx, event)
 	case "pull_request_review_comment": // 处理PR Review评论事件
-		return ep.handlePullRequestReviewCommentEvent(event)
+		return ep.handlePullRequestReviewCommentEvent(jobCtx, event)
 	case "pull_request_review": // 处理PR Review事件
-		return ep.handlePullRequestReviewEvent(event)
+		return ep.handlePullRequestReviewEvent(jobCtx, event)
 	case "ping": // 处理Ping事件
 		return ep.handlePingEvent(event)
 	default:
@@ -56,7 +137,7 @@ func (ep *EventProcessor) ProcessEvent(event *models.GitHubEvent) error {
 }
 
 // handleIssuesEvent 处理Issue事件
-func (ep *EventProcessor) handleIssuesEvent(event *models.GitHubEvent) error {
+func (ep *EventProcessor) handleIssuesEvent(jobCtx context.Context, event *models.GitHubEvent) error {
 	var issueEvent models.IssuesEvent
 	if err := event.ParsePayload(&issueEvent); err != nil {
 		return fmt.Errorf("解析Issue事件失败: %v", err)
@@ -67,7 +148,7 @@ func (ep *EventProcessor) handleIssuesEvent(event *models.GitHubEvent) error {
 
 	switch issueEvent.Action {
 	case "opened": // 处理Issue打开事件
-		return ep.handleIssueOpened(&issueEvent)
+		return ep.handleIssueOpened(jobCtx, &issueEvent)
 	case "edited": // 处理Issue编辑事件
 		return ep.handleIssueEdited(&issueEvent)
 	case "closed": // 处理Issue关闭事件
@@ -79,7 +160,7 @@ func (ep *EventProcessor) handleIssuesEvent(event *models.GitHubEvent) error {
 }
 
 // handleIssueCommentEvent 处理Issue评论事件
-func (ep *EventProcessor) handleIssueCommentEvent(event *models.GitHubEvent) error {
+func (ep *EventProcessor) handleIssueCommentEvent(jobCtx context.Context, event *models.GitHubEvent) error {
 	var commentEvent models.IssueCommentEvent
 	if err := event.ParsePayload(&commentEvent); err != nil {
 		return fmt.Errorf("解析Issue评论事件失败: %v", err)
@@ -90,14 +171,14 @@ func (ep *EventProcessor) handleIssueCommentEvent(event *models.GitHubEvent) err
 		ep.truncateString(commentEvent.Comment.Body, 50))
 
 	if commentEvent.Action == "created" {
-		return ep.handleCommentCreated(&commentEvent)
+		return ep.handleCommentCreated(jobCtx, &commentEvent)
 	}
 
 	return nil
 }
 
 // handlePullRequestEvent 处理Pull Request事件
-func (ep *EventProcessor) handlePullRequestEvent(event *models.GitHubEvent) error {
+func (ep *EventProcessor) handlePullRequestEvent(jobCtx context.Context, event *models.GitHubEvent) error {
 	var prEvent models.PullRequestEvent
 	if err := event.ParsePayload(&prEvent); err != nil {
 		return fmt.Errorf("解析Pull Request事件失败: %v", err)
@@ -107,10 +188,10 @@ func (ep *EventProcessor) handlePullRequestEvent(event *models.GitHubEvent) erro
 		prEvent.Action, prEvent.PullRequest.Number, prEvent.PullRequest.Title)
 
 	switch prEvent.Action {
-	case "opened":
-		return ep.handlePullRequestOpened(&prEvent)
+	case "opened", "ready_for_review":
+		return ep.handlePullRequestOpened(jobCtx, &prEvent)
 	case "synchronize":
-		return ep.handlePullRequestSynchronized(&prEvent)
+		return ep.handlePullRequestSynchronized(jobCtx, &prEvent)
 	case "closed":
 		return ep.handlePullRequestClosed(&prEvent)
 	default:
@@ -120,7 +201,7 @@ func (ep *EventProcessor) handlePullRequestEvent(event *models.GitHubEvent) erro
 }
 
 // handlePullRequestReviewCommentEvent 处理PR Review评论事件
-func (ep *EventProcessor) handlePullRequestReviewCommentEvent(event *models.GitHubEvent) error {
+func (ep *EventProcessor) handlePullRequestReviewCommentEvent(jobCtx context.Context, event *models.GitHubEvent) error {
 	var reviewCommentEvent models.PullRequestReviewCommentEvent
 	if err := event.ParsePayload(&reviewCommentEvent); err != nil {
 		return fmt.Errorf("解析PR Review评论事件失败: %v", err)
@@ -130,14 +211,14 @@ func (ep *EventProcessor) handlePullRequestReviewCommentEvent(event *models.GitH
 		reviewCommentEvent.Action, reviewCommentEvent.PullRequest.Number)
 
 	if reviewCommentEvent.Action == "created" {
-		return ep.handleReviewCommentCreated(&reviewCommentEvent)
+		return ep.handleReviewCommentCreated(jobCtx, &reviewCommentEvent)
 	}
 
 	return nil
 }
 
 // handlePullRequestReviewEvent 处理PR Review事件
-func (ep *EventProcessor) handlePullRequestReviewEvent(event *models.GitHubEvent) error {
+func (ep *EventProcessor) handlePullRequestReviewEvent(jobCtx context.Context, event *models.GitHubEvent) error {
 	var reviewEvent models.PullRequestReviewEvent
 	if err := event.ParsePayload(&reviewEvent); err != nil {
 		return fmt.Errorf("解析PR Review事件失败: %v", err)
@@ -147,7 +228,7 @@ func (ep *EventProcessor) handlePullRequestReviewEvent(event *models.GitHubEvent
 		reviewEvent.Action, reviewEvent.PullRequest.Number, reviewEvent.Review.State)
 
 	if reviewEvent.Action == "submitted" {
-		return ep.handleReviewSubmitted(&reviewEvent)
+		return ep.handleReviewSubmitted(jobCtx, &reviewEvent)
 	}
 
 	return nil
@@ -173,17 +254,18 @@ func (ep *EventProcessor) handlePingEvent(event *models.GitHubEvent) error {
 }
 
 // handleIssueOpened 处理Issue打开事件
-func (ep *EventProcessor) handleIssueOpened(event *models.IssuesEvent) error {
+func (ep *EventProcessor) handleIssueOpened(jobCtx context.Context, event *models.IssuesEvent) error {
 	log.Printf("新Issue创建: #%d - %s", event.Issue.Number, event.Issue.Title)
 
 	// 检查Issue描述中是否包含命令
-	if command := ep.extractCommand(event.Issue.Body); command != nil {
-		log.Printf("在Issue中检测到命令: %s", command.Command)
+	if commands := ep.extractCommands(event.Issue.Body); len(commands) > 0 {
+		log.Printf("在Issue中检测到命令: %s", commandNames(commands))
 
-		return ep.executeCommand(command, &CommandContext{
+		return ep.executeCommands(commands, &CommandContext{
 			Repository: event.Repository,
 			Issue:      &event.Issue,
 			User:       event.Sender,
+			Context:    jobCtx,
 		})
 	}
 
@@ -205,19 +287,19 @@ func (ep *EventProcessor) handleIssueClosed(event *models.IssuesEvent) error {
 }
 
 // handleCommentCreated 处理评论创建事件
-func (ep *EventProcessor) handleCommentCreated(event *models.IssueCommentEvent) error {
-	// 检查是否是PR评论
-	if event.PullRequest != nil {
+func (ep *EventProcessor) handleCommentCreated(jobCtx context.Context, event *models.IssueCommentEvent) error {
+	// 检查是否是PR评论：issue_comment的payload只在issue.pull_request中标记所属PR
+	if event.Issue.PullRequest != nil {
 		log.Printf("新PR评论创建: PR #%d, User: %s",
-			event.PullRequest.Number, event.Comment.User.Login)
+			event.Issue.Number, event.Comment.User.Login)
 	} else {
 		log.Printf("新Issue评论创建: Issue #%d, User: %s",
 			event.Issue.Number, event.Comment.User.Login)
 	}
 
 	// 检查评论中是否包含命令
-	if command := ep.extractCommand(event.Comment.Body); command != nil {
-		log.Printf("在评论中检测到命令: %s", command.Command)
+	if commands := ep.extractCommands(event.Comment.Body); len(commands) > 0 {
+		log.Printf("在评论中检测到命令: %s", commandNames(commands))
 
 		// 构建CommandContext，如果是PR评论则包含PR信息
 		ctx := &CommandContext{
@@ -225,30 +307,36 @@ func (ep *EventProcessor) handleCommentCreated(event *models.IssueCommentEvent)
 			Issue:      &event.Issue,
 			Comment:    &event.Comment,
 			User:       event.Sender,
+			Context:    jobCtx,
 		}
 
-		// 如果是PR评论，添加PR信息到上下文
-		if event.PullRequest != nil {
-			ctx.PullRequest = event.PullRequest
+		// 如果是PR评论，payload中没有PR的分支信息，需要通过API加载后添加到上下文
+		if event.Issue.PullRequest != nil {
+			owner, repo := event.Repository.Owner.Login, event.Repository.Name
+			pr, err := ep.githubService.GetPullRequest(owner, repo, event.Issue.Number)
+			if err != nil {
+				return fmt.Errorf("获取PR #%d 信息失败: %v", event.Issue.Number, err)
+			}
+			ctx.PullRequest = pr
 		}
 
-		return ep.executeCommand(command, ctx)
+		return ep.executeCommands(commands, ctx)
 	}
 
 	return nil
 }
 
-// handlePullRequestOpened 处理Pull Request打开事件
-func (ep *EventProcessor) handlePullRequestOpened(event *models.PullRequestEvent) error {
+// handlePullRequestOpened 处理Pull Request打开和标记为可审查事件，启用自动审查时审查整个PR
+func (ep *EventProcessor) handlePullRequestOpened(jobCtx context.Context, event *models.PullRequestEvent) error {
 	log.Printf("新Pull Request创建: #%d - %s",
 		event.PullRequest.Number, event.PullRequest.Title)
-	return nil
+	return ep.handlePullRequestAutoReview(jobCtx, event, "")
 }
 
-// handlePullRequestSynchronized 处理Pull Request同步事件
-func (ep *EventProcessor) handlePullRequestSynchronized(event *models.PullRequestEvent) error {
-	log.Printf("Pull Request已同步: #%d", event.PullRequest.Number)
-	return nil
+// handlePullRequestSynchronized 处理Pull Request同步事件，启用自动审查时只审查新推送的提交
+func (ep *EventProcessor) handlePullRequestSynchronized(jobCtx context.Context, event *models.PullRequestEvent) error {
+	log.Printf("Pull Request已同步: #%d (%s -> %s)", event.PullRequest.Number, shortSHA(event.Before), shortSHA(event.After))
+	return ep.handlePullRequestAutoReview(jobCtx, event, event.Before)
 }
 
 // handlePullRequestClosed 处理Pull Request关闭事件
@@ -259,19 +347,20 @@ func (ep *EventProcessor) handlePullRequestClosed(event *models.PullRequestEvent
 }
 
 // handleReviewCommentCreated 处理Review评论创建事件
-func (ep *EventProcessor) handleReviewCommentCreated(event *models.PullRequestReviewCommentEvent) error {
+func (ep *EventProcessor) handleReviewCommentCreated(jobCtx context.Context, event *models.PullRequestReviewCommentEvent) error {
 	log.Printf("新Review评论创建: PR #%d, User: %s",
 		event.PullRequest.Number, event.Comment.User.Login)
 
 	// 检查评论中是否包含命令
-	if command := ep.extractCommand(event.Comment.Body); command != nil {
-		log.Printf("在Review评论中检测到命令: %s", command.Command)
+	if commands := ep.extractCommands(event.Comment.Body); len(commands) > 0 {
+		log.Printf("在Review评论中检测到命令: %s", commandNames(commands))
 
-		return ep.executeCommand(command, &CommandContext{
+		return ep.executeCommands(commands, &CommandContext{
 			Repository:  event.Repository,
 			PullRequest: &event.PullRequest,
 			Comment:     &event.Comment,
 			User:        event.Sender,
+			Context:     jobCtx,
 		})
 	}
 
@@ -279,13 +368,13 @@ func (ep *EventProcessor) handleReviewCommentCreated(event *models.PullRequestRe
 }
 
 // handleReviewSubmitted 处理Review提交事件
-func (ep *EventProcessor) handleReviewSubmitted(event *models.PullRequestReviewEvent) error {
+func (ep *EventProcessor) handleReviewSubmitted(jobCtx context.Context, event *models.PullRequestReviewEvent) error {
 	log.Printf("PR Review提交: PR #%d, State: %s, User: %s",
 		event.PullRequest.Number, event.Review.State, event.Review.User.Login)
 
 	// 检查Review内容中是否包含命令
-	if command := ep.extractCommand(event.Review.Body); command != nil {
-		log.Printf("在Review中检测到命令: %s", command.Command)
+	if commands := ep.extractCommands(event.Review.Body); len(commands) > 0 {
+		log.Printf("在Review中检测到命令: %s", commandNames(commands))
 
 		// 构建模拟的Comment用于命令执行
 		reviewComment := &models.Comment{
@@ -297,11 +386,12 @@ func (ep *EventProcessor) handleReviewSubmitted(event *models.PullRequestReviewE
 			UpdatedAt: event.Review.SubmittedAt,
 		}
 
-		return ep.executeCommand(command, &CommandContext{
+		return ep.executeCommands(commands, &CommandContext{
 			Repository:  event.Repository,
 			PullRequest: &event.PullRequest,
 			Comment:     reviewComment,
 			User:        event.Sender,
+			Context:     jobCtx,
 		})
 	}
 
@@ -325,23 +415,18 @@ func (ep *EventProcessor) handleReviewSubmitted(event *models.PullRequestReviewE
 			UpdatedAt: event.Review.SubmittedAt,
 		}
 
-		return ep.executeCommand(reviewCommand, &CommandContext{
+		return ep.executeCommands([]*Command{reviewCommand}, &CommandContext{
 			Repository:  event.Repository,
 			PullRequest: &event.PullRequest,
 			Comment:     reviewComment,
 			User:        event.Sender,
+			Context:     jobCtx,
 		})
 	}
 
 	return nil
 }
 
-// Command 命令结构
-type Command struct {
-	Command string
-	Args    string
-}
-
 // CommandContext 命令执行上下文
 type CommandContext struct {
 	Repository  models.Repository
@@ -349,52 +434,311 @@ type CommandContext struct {
 	PullRequest *models.PullRequest
 	Comment     *models.Comment
 	User        models.User
+	Config      *RepoConfig     // 仓库配置，执行命令前从默认分支加载
+	Context     context.Context // 任务的context，任务被 /cancel 取消时结束，为nil时不可取消
+}
+
+// jobContext 返回任务的context
+func (c *CommandContext) jobContext() context.Context {
+	if c.Context == nil {
+		return context.Background()
+	}
+	return c.Context
+}
+
+// extractCommands 从文本中按顺序提取所有命令，忽略代码块和引用中的内容
+func (ep *EventProcessor) extractCommands(text string) []*Command {
+	return parseCommands(ep.commands.Pattern(), text)
+}
+
+// PeekCommands 解析事件中包含的命令及其执行上下文，不执行命令
+// 事件不包含命令时返回的命令列表为空，事件无法关联到Issue/PR时返回的CommandContext为nil
+func (ep *EventProcessor) PeekCommands(event *models.GitHubEvent) ([]*Command, *CommandContext) {
+	switch event.Type {
+	case "issues":
+		var issueEvent models.IssuesEvent
+		if err := event.ParsePayload(&issueEvent); err != nil {
+			return nil, nil
+		}
+		ctx := &CommandContext{
+			Repository: issueEvent.Repository,
+			Issue:      &issueEvent.Issue,
+			User:       issueEvent.Sender,
+		}
+		if issueEvent.Action != "opened" {
+			return nil, ctx
+		}
+		return ep.extractCommands(issueEvent.Issue.Body), ctx
+	case "issue_comment":
+		var commentEvent models.IssueCommentEvent
+		if err := event.ParsePayload(&commentEvent); err != nil {
+			return nil, nil
+		}
+		// PR评论的分支信息需要调用API获取，这里只有Issue信息，执行时由handleCommentCreated补全
+		ctx := &CommandContext{
+			Repository: commentEvent.Repository,
+			Issue:      &commentEvent.Issue,
+			Comment:    &commentEvent.Comment,
+			User:       commentEvent.Sender,
+		}
+		if commentEvent.Action != "created" {
+			return nil, ctx
+		}
+		return ep.extractCommands(commentEvent.Comment.Body), ctx
+	case "pull_request_review_comment":
+		var reviewCommentEvent models.PullRequestReviewCommentEvent
+		if err := event.ParsePayload(&reviewCommentEvent); err != nil {
+			return nil, nil
+		}
+		ctx := &CommandContext{
+			Repository:  reviewCommentEvent.Repository,
+			PullRequest: &reviewCommentEvent.PullRequest,
+			Comment:     &reviewCommentEvent.Comment,
+			User:        reviewCommentEvent.Sender,
+		}
+		if reviewCommentEvent.Action != "created" {
+			return nil, ctx
+		}
+		return ep.extractCommands(reviewCommentEvent.Comment.Body), ctx
+	case "pull_request_review":
+		var reviewEvent models.PullRequestReviewEvent
+		if err := event.ParsePayload(&reviewEvent); err != nil {
+			return nil, nil
+		}
+		ctx := &CommandContext{
+			Repository:  reviewEvent.Repository,
+			PullRequest: &reviewEvent.PullRequest,
+			User:        reviewEvent.Sender,
+		}
+		if reviewEvent.Action != "submitted" {
+			return nil, ctx
+		}
+		return ep.extractCommands(reviewEvent.Review.Body), ctx
+	case "pull_request":
+		var prEvent models.PullRequestEvent
+		if err := event.ParsePayload(&prEvent); err != nil {
+			return nil, nil
+		}
+		return nil, &CommandContext{
+			Repository:  prEvent.Repository,
+			PullRequest: &prEvent.PullRequest,
+			User:        prEvent.Sender,
+		}
+	default:
+		return nil, nil
+	}
 }
 
-// extractCommand 从文本中提取命令
-func (ep *EventProcessor) extractCommand(text string) *Command {
-	lines := strings.Split(strings.TrimSpace(text), "\n")
+// RunCommand 从文本中解析命令并在给定上下文中执行，用于离线运行命令
+func (ep *EventProcessor) RunCommand(text string, ctx *CommandContext) error {
+	commands := ep.extractCommands(text)
+	if len(commands) == 0 {
+		return fmt.Errorf("未识别的命令: %s", text)
+	}
+
+	return ep.executeCommands(commands, ctx)
+}
 
-	// TODO 这里有个小bug
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		matches := ep.commandRegex.FindStringSubmatch(line)
-		if len(matches) >= 2 {
-			return &Command{
-				Command: matches[1],
-				Args:    strings.TrimSpace(matches[2]),
+// errRepoConfigRejected 仓库配置无效或无法读取，已回复说明，后续命令不再执行
+var errRepoConfigRejected = errors.New("仓库配置无效或无法读取")
+
+// executeCommands 按顺序执行多个命令，某个命令出错时停止执行后续命令
+func (ep *EventProcessor) executeCommands(commands []*Command, ctx *CommandContext) error {
+	for i, command := range commands {
+		if err := ep.executeCommand(command, ctx); err != nil {
+			// 配置文件错误只回复一次，后续命令都不执行
+			if errors.Is(err, errRepoConfigRejected) {
+				return nil
+			}
+			if remaining := len(commands) - i - 1; remaining > 0 {
+				log.Printf("命令 /%s 执行失败，跳过后续 %d 个命令", command.Command, remaining)
 			}
+			return fmt.Errorf("命令 /%s 执行失败: %v", command.Command, err)
 		}
 	}
 
 	return nil
 }
 
+// commandNames 命令名称列表，用于日志和展示
+func commandNames(commands []*Command) string {
+	if len(commands) == 0 {
+		return "unknown"
+	}
+
+	names := make([]string, 0, len(commands))
+	for _, command := range commands {
+		names = append(names, "/"+command.Command)
+	}
+	return strings.Join(names, ", ")
+}
+
 // executeCommand 执行命令
 func (ep *EventProcessor) executeCommand(command *Command, ctx *CommandContext) error {
 	log.Printf("执行命令: %s, 参数: %s", command.Command, command.Args)
 
-	switch command.Command {
-	case "code": // 适合用于：功能开发、逻辑变更、结构调整 （/code 是基于Issue描述进行修改）
-		return ep.handleCodeCommand(command, ctx)
-	case "continue": // 适合用于：继续开发、功能扩展、逻辑优化（需要先/code，在功能实现上和code不同的点在于：/continue 是基于/code的代码进行修改，而/code是基于Issue描述进行修改）
-		return ep.handleContinueCommand(command, ctx)
-	case "fix": // 适合用于：代码修复、错误修复、性能优化
-		return ep.handleFixCommand(command, ctx)
-	case "help":
-		return ep.handleHelpCommand(command, ctx)
-	case "summary": // 适合用于：总结代码、总结问题、总结需求
-		return ep.handleSummaryCommand(command, ctx)
-	case "review": // 适合用于：代码审查、代码优化、代码重构
-		return ep.handleReviewCommand(command, ctx)
-	default:
+	handler, exists := ep.commands.Lookup(command.Command)
+	if !exists {
 		return fmt.Errorf("未知命令: %s", command.Command)
 	}
+
+	// 别名统一为命令名称
+	command.Command = handler.Name()
+
+	if !handler.Scope().Allows(ctx) {
+		log.Printf("命令 /%s 不支持在当前上下文中使用", handler.Name())
+		return ep.createResponse(ctx, fmt.Sprintf("⚠️ 命令 `/%s` 只能在 %s 中使用，输入 `/help` 查看所有命令。",
+			handler.Name(), handler.Scope()))
+	}
+
+	// 检查触发者的仓库权限，避免任何人都能让机器人推送代码、消耗API额度
+	if allowed, message := ep.authorize(handler, ctx); !allowed {
+		return ep.createResponse(ctx, message)
+	}
+
+	// 通过权限检查后才读取仓库配置，避免无权限的用户触发网络请求和配置错误回复
+	if err := ep.loadRepoConfig(ctx); err != nil {
+		if errors.Is(err, ErrJobCanceled) {
+			return err
+		}
+		log.Printf("加载仓库配置失败: %v", err)
+		if replyErr := ep.createResponse(ctx, buildRepoConfigErrorMessage(err)); replyErr != nil {
+			return replyErr
+		}
+		return errRepoConfigRejected
+	}
+
+	if handler.Name() != "help" && !ctx.Config.CommandEnabled(handler.Name()) {
+		log.Printf("命令 /%s 在仓库 %s 中未启用", handler.Name(), ctx.Repository.FullName)
+		return ep.createResponse(ctx, fmt.Sprintf("⚠️ 命令 `/%s` 未在本仓库的 `%s` 中启用，输入 `/help` 查看可用命令。",
+			handler.Name(), RepoConfigFile))
+	}
+
+	// 解析命令参数，参数错误时回复用法说明，避免错误参数混入提示词
+	if err := command.ParseFlags(handler.Flags()); err != nil {
+		log.Printf("命令参数解析失败: %v", err)
+		return ep.createResponse(ctx, ep.buildUsageMessage(handler, err))
+	}
+
+	return handler.Execute(ep, command, ctx)
+}
+
+// authorize 检查触发者是否拥有命令所需的仓库权限，无权限时返回拒绝说明
+func (ep *EventProcessor) authorize(handler CommandHandler, ctx *CommandContext) (bool, string) {
+	required := handler.Permission()
+	// 能看到Issue/PR的用户都有读权限，无需查询
+	if required <= PermissionRead {
+		return true, ""
+	}
+
+	username := ctx.User.Login
+	role, err := ep.githubService.GetCollaboratorPermission(ctx.Repository.Owner.Login, ctx.Repository.Name, username)
+	if err != nil {
+		log.Printf("获取用户权限失败: user=%s, repo=%s, %v", username, ctx.Repository.FullName, err)
+		return false, fmt.Sprintf("⚠️ 抱歉 @%s，暂时无法确认你在本仓库的权限，命令 `/%s` 未执行，请稍后重试。",
+			username, handler.Name())
+	}
+
+	if granted := ParsePermission(role); granted < required {
+		log.Printf("用户权限不足: user=%s, role=%s, command=/%s, required=%s", username, role, handler.Name(), required)
+		return false, fmt.Sprintf("🙏 抱歉 @%s，命令 `/%s` 需要仓库 **%s** 或更高权限，你当前的权限为 **%s**。\n\n如需使用该命令，请联系仓库维护者。",
+			username, handler.Name(), required, ParsePermission(role))
+	}
+
+	return true, ""
+}
+
+// loadRepoConfig 从仓库默认分支读取配置文件，结果保存在ctx.Config中，文件不存在时使用默认配置
+// 配置无效时返回 RepoConfigError，读取失败时返回 RepoConfigReadError
+func (ep *EventProcessor) loadRepoConfig(ctx *CommandContext) error {
+	if ctx.Config != nil {
+		return nil
+	}
+
+	branch := ctx.Repository.DefaultBranch
+	if branch == "" {
+		branch = "main"
+	}
+
+	data, err := ep.gitService.ReadFileAtBranch(ctx.jobContext(), ctx.Repository.CloneURL, branch, RepoConfigFile)
+	if errors.Is(err, os.ErrNotExist) {
+		ctx.Config = &RepoConfig{}
+		return nil
+	}
+	if err != nil {
+		// 读取失败时无法确认配置中的命令列表和禁止修改的路径，不能按无限制的默认配置执行
+		return &RepoConfigReadError{Err: err}
+	}
+
+	cfg, err := ParseRepoConfig(data, ep.commands, ep.agents)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("已加载仓库配置: %s@%s", ctx.Repository.FullName, branch)
+	ctx.Config = cfg
+	return nil
+}
+
+// buildRepoConfigErrorMessage 构建配置文件错误说明
+func buildRepoConfigErrorMessage(err error) string {
+	var readErr *RepoConfigReadError
+	if errors.As(err, &readErr) {
+		return fmt.Sprintf("⚠️ **无法读取仓库配置文件 `%s`，命令未执行**\n\n无法确认本仓库启用的命令和禁止修改的路径，请稍后重新发送命令。", RepoConfigFile)
+	}
+
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("❌ **仓库配置文件 `%s` 无效，命令未执行**\n\n", RepoConfigFile))
+
+	var configErr *RepoConfigError
+	if errors.As(err, &configErr) {
+		for _, problem := range configErr.Problems {
+			message.WriteString(fmt.Sprintf("- %s\n", problem))
+		}
+	} else {
+		message.WriteString(fmt.Sprintf("- %v\n", err))
+	}
+
+	message.WriteString("\n请修复默认分支上的配置文件后重新发送命令。")
+	return message.String()
+}
+
+// agent 选择命令使用的AI服务提供方，并应用仓库配置
+func (ep *EventProcessor) agent(ctx *CommandContext, command string) AgentProvider {
+	provider := ep.agents.Select(command, ctx.Config)
+	log.Printf("命令 /%s 使用AI提供方: %s", command, provider.Name())
+	return provider
+}
+
+// repoAgent 选择在仓库目录中执行命令的AI服务提供方，跳过无法读写仓库文件的提供方
+func (ep *EventProcessor) repoAgent(ctx *CommandContext, command string) AgentProvider {
+	provider := ep.agents.SelectInRepo(command, ctx.Config)
+	log.Printf("命令 /%s 使用AI提供方: %s", command, provider.Name())
+	return provider
+}
+
+// buildUsageMessage 构建参数错误时的用法说明
+func (ep *EventProcessor) buildUsageMessage(handler CommandHandler, err error) string {
+	var message strings.Builder
+	message.WriteString(fmt.Sprintf("❌ **命令参数错误**: %v\n\n", err))
+	message.WriteString(fmt.Sprintf("**用法:** `%s`\n", handler.Usage()))
+
+	if len(handler.Flags()) > 0 {
+		message.WriteString("\n**支持的参数:**\n")
+		for _, flag := range handler.Flags() {
+			message.WriteString(fmt.Sprintf("- `%s` %s\n", flag, flag.Usage))
+		}
+	} else {
+		message.WriteString("\n该命令不支持 `--` 参数，如需在文本中使用 `--` 开头的内容，请用引号包裹或放在 `--` 之后。\n")
+	}
+
+	return message.String()
 }
 
 // handleSummaryCommand 处理总结命令
 func (ep *EventProcessor) handleSummaryCommand(command *Command, ctx *CommandContext) error {
 	log.Printf("处理总结命令: %s", command.Args)
+	progress := ep.startProgress(ctx, "项目总结", StageClone, StageAgent)
 
 	// 获取分支名
 	sourceBranch := "main"
@@ -403,12 +747,13 @@ func (ep *EventProcessor) handleSummaryCommand(command *Command, ctx *CommandCon
 	}
 
 	// 克隆仓库
-	repoPath, err := ep.gitService.CloneRepository(ctx.Repository.CloneURL, sourceBranch)
+	progress.Begin(StageClone)
+	repoPath, err := ep.gitService.CloneRepository(ctx.jobContext(), ctx.Repository.CloneURL, sourceBranch)
 	if err != nil {
 		log.Printf("克隆仓库失败: %v", err)
-		response := fmt.Sprintf("❌ 总结失败: 克隆仓库失败 - %v", err.Error())
-		return ep.createResponse(ctx, response)
+		return progress.Abort(fmt.Errorf("克隆仓库失败: %v", err))
 	}
+	progress.Done(fmt.Sprintf("分支 %s", sourceBranch))
 
 	// 清理工作目录
 	defer func() {
@@ -452,16 +797,16 @@ func (ep *EventProcessor) handleSummaryCommand(command *Command, ctx *CommandCon
 请分析项目的核心功能、技术栈、主要文件结构，并提供简洁明了的总结。`, projectContext, fileTree, command.Args)
 
 	// 在目标仓库目录中调用Claude Code CLI进行总结
-	summary, err := ep.claudeCodeService.SummarizeInRepo(summaryPrompt, repoPath)
+	progress.Begin(StageAgent)
+	summary, err := ep.agent(ctx, command.Command).Summarize(ctx.jobContext(), summaryPrompt, repoPath)
 	if err != nil {
 		log.Printf("Claude Code CLI总结失败: %v", err)
-		response := fmt.Sprintf("❌ 总结失败: %v", err)
-		return ep.createResponse(ctx, response)
+		return progress.Abort(err)
 	}
+	progress.Done(summary.Summary())
 
 	// 回复总结内容
-	response := fmt.Sprintf("📝 **总结结果：**\n\n%s", summary)
-	return ep.createResponse(ctx, response)
+	return progress.Finish(summary.Output)
 }
 
 // handleReviewCommand 处理代码审查命令
@@ -480,18 +825,76 @@ func (ep *EventProcessor) handleReviewCommand(command *Command, ctx *CommandCont
 	}
 }
 
+// buildReviewFilters 根据 --severity 和 --focus 参数构建审查要求
+func buildReviewFilters(command *Command) string {
+	var filters strings.Builder
+
+	if focus := command.ListFlag("focus"); len(focus) > 0 {
+		filters.WriteString(fmt.Sprintf("\n**审查重点:** %s（请优先并重点分析这些方面）", strings.Join(focus, ", ")))
+	}
+
+	if severity := command.Flag("severity"); severity != "" {
+		var levels []string
+		for i, level := range reviewSeverities {
+			if level == severity {
+				levels = reviewSeverities[i:]
+				break
+			}
+		}
+		filters.WriteString(fmt.Sprintf("\n**严重程度过滤:** 只报告严重程度为 %s 的问题，忽略更低级别的问题", strings.Join(levels, "/")))
+	}
+
+	return filters.String()
+}
+
 // handlePullRequestReview 处理PR代码审查
 func (ep *EventProcessor) handlePullRequestReview(command *Command, ctx *CommandContext) error {
 	log.Printf("处理PR代码审查: PR #%d", ctx.PullRequest.Number)
+	progress := ep.startProgress(ctx, "PR代码审查", StageClone, StageAgent, StageReview)
+
+	reviewScope := "PR代码变更"
+	if command.Args != "" {
+		reviewScope = command.Args
+	}
+	reviewScope += buildReviewFilters(command)
+
+	return ep.reviewPullRequest(ctx, progress, prReviewOptions{
+		command:     command.Command,
+		scope:       reviewScope,
+		minSeverity: command.Flag("severity"),
+	})
+}
+
+// prReviewOptions PR审查的参数，/review 命令和自动审查共用同一审查流程
+type prReviewOptions struct {
+	command     string // 用于选择AI提供方的命令名
+	scope       string // 审查范围说明
+	minSeverity string // 只保留不低于该严重程度的问题，为空时不过滤
+	sinceSHA    string // 增量审查的起点提交，为空时审查整个PR
+	auto        bool   // 自动审查：没有进度评论，审查正文带有标记以便之后被取代
+}
+
+// reviewPullRequest 审查PR并提交审查结果，progress为nil时不发布进度评论
+func (ep *EventProcessor) reviewPullRequest(ctx *CommandContext, progress *ProgressReporter, options prReviewOptions) error {
+	pr := ctx.PullRequest
+
+	// 没有进度评论时直接返回错误，由任务记录失败原因
+	abort := func(err error) error {
+		if progress == nil {
+			return err
+		}
+		return progress.Abort(err)
+	}
 
 	// 克隆仓库（使用基础分支）
-	baseBranch := ctx.PullRequest.Base.Ref
-	repoPath, err := ep.gitService.CloneRepository(ctx.Repository.CloneURL, baseBranch)
+	progress.Begin(StageClone)
+	baseBranch := pr.Base.Ref
+	repoPath, err := ep.gitService.CloneRepository(ctx.jobContext(), ctx.Repository.CloneURL, baseBranch)
 	if err != nil {
 		log.Printf("克隆仓库失败: %v", err)
-		response := fmt.Sprintf("❌ PR代码审查失败: 克隆仓库失败 - %v", err.Error())
-		return ep.createResponse(ctx, response)
+		return abort(fmt.Errorf("克隆仓库失败: %v", err))
 	}
+	progress.Done(fmt.Sprintf("分支 %s", baseBranch))
 
 	// 清理工作目录
 	defer func() {
@@ -500,20 +903,113 @@ func (ep *EventProcessor) handlePullRequestReview(command *Command, ctx *Command
 		}
 	}()
 
-	// 获取PR的diff信息
-	prDiff, err := ep.gitService.GetPullRequestDiff(repoPath, ctx.PullRequest.Head.SHA, ctx.PullRequest.Base.SHA)
+	// 获取PR的diff信息，行内评论需要根据整个PR的diff定位
+	prDiff, err := ep.gitService.GetPullRequestDiff(ctx.jobContext(), repoPath, pr)
 	if err != nil {
 		log.Printf("获取PR diff失败: %v", err)
-		prDiff = "无法获取PR差异信息"
+		return abort(err)
+	}
+	if strings.TrimSpace(prDiff) == "" {
+		log.Printf("PR #%d 没有代码变更", pr.Number)
+		if options.auto {
+			return nil
+		}
+		return progress.Finish(fmt.Sprintf("**PR信息:** #%d - %s\n\nPR没有代码变更，无需审查。", pr.Number, pr.Title))
 	}
+	positions := parseDiffPositions(prDiff)
 
-	// 构建PR审查提示词
-	reviewScope := "PR代码变更"
-	if command.Args != "" {
-		reviewScope = command.Args
+	// 增量审查只把新推送的提交交给AI，强制推送后旧提交不存在时退回审查整个PR
+	reviewDiff := prDiff
+	reviewScope := options.scope
+	if options.sinceSHA != "" {
+		incremental, err := ep.gitService.GetCommitRangeDiff(ctx.jobContext(), repoPath, options.sinceSHA, pr.Head.SHA)
+		if err != nil {
+			log.Printf("获取增量diff失败，审查整个PR: %v", err)
+			options.sinceSHA = ""
+		} else {
+			reviewDiff = incremental
+			reviewScope = fmt.Sprintf("%s（仅 %s..%s 之间新推送的提交）", reviewScope, shortSHA(options.sinceSHA), shortSHA(pr.Head.SHA))
+		}
+	}
+
+	if options.auto {
+		files := diffFiles(reviewDiff)
+		if len(files) == 0 {
+			log.Printf("PR #%d 没有需要审查的文件变更，跳过自动审查", pr.Number)
+			return nil
+		}
+		if ctx.Config.AutoReviewSkipsFiles(files) {
+			log.Printf("PR #%d 变更的文件全部匹配 auto_review.skip_paths，跳过自动审查", pr.Number)
+			return nil
+		}
+	}
+
+	// 按文件拆分diff，过滤锁文件、生成文件等不需要审查的文件，其余按token预算分块审查
+	plan := planReview(reviewDiff, reviewChunkTokens, maxReviewChunks)
+	chunks := plan.Chunks
+	if len(chunks) == 0 {
+		log.Printf("PR #%d 没有需要审查的代码变更，跳过 %d 个文件", pr.Number, len(plan.Skipped))
+		if options.auto {
+			return nil
+		}
+		return progress.Finish(strings.TrimSpace(fmt.Sprintf("**PR信息:** #%d - %s\n\n没有需要审查的代码变更。\n\n%s",
+			pr.Number, pr.Title, skippedFilesMarkdown(plan.Skipped))))
+	}
+	if len(chunks) > 1 || len(plan.Skipped) > 0 {
+		log.Printf("PR #%d 分 %d 部分审查，跳过 %d 个文件", pr.Number, len(chunks), len(plan.Skipped))
+	}
+
+	// 在目标仓库目录中调用AI逐个部分审查
+	progress.Begin(StageAgent)
+	agent := ep.agent(ctx, options.command)
+	reports := make([]*ReviewReport, 0, len(chunks))
+	var reviewResult *AgentResult
+	for i, chunk := range chunks {
+		chunkScope := reviewScope
+		if len(chunks) > 1 {
+			chunkScope += fmt.Sprintf("\n**本次审查:** 第 %d/%d 部分，只包含 %s，其余文件在其他部分审查", i+1, len(chunks), formatFileList(chunk.Files))
+		}
+
+		reviewResult, err = agent.ReviewInRepo(ctx.jobContext(), buildPullRequestReviewPrompt(pr, chunkScope, chunk.Diff), repoPath)
+		if err != nil {
+			log.Printf("Claude Code CLI代码审查失败（第 %d/%d 部分）: %v", i+1, len(chunks), err)
+			return abort(err)
+		}
+
+		report, err := parseReviewReport(reviewResult.Output)
+		if err != nil {
+			log.Printf("第 %d/%d 部分的审查结果不是结构化格式，按文本发布: %v", i+1, len(chunks), err)
+			if len(chunks) == 1 && !options.auto {
+				// 无法解析时按原文发布，审查结果不会丢失
+				progress.Done(reviewResult.Summary())
+				response := fmt.Sprintf(`**PR信息:** #%d - %s
+
+%s`, pr.Number, pr.Title, reviewResult.Output)
+				if skipped := skippedFilesMarkdown(plan.Skipped); skipped != "" {
+					response += "\n\n" + skipped
+				}
+				return progress.Finish(response)
+			}
+			report = &ReviewReport{Summary: reviewResult.Output}
+		}
+		reports = append(reports, report)
 	}
+	if len(chunks) > 1 {
+		progress.Done(fmt.Sprintf("分 %d 部分审查，%s", len(chunks), reviewResult.Summary()))
+	} else {
+		progress.Done(reviewResult.Summary())
+	}
+
+	report := mergeReviewReports(reports, chunks)
+	report.Skipped = plan.Skipped
+	report.FilterBySeverity(options.minSeverity)
+
+	return ep.submitPullRequestReview(ctx, progress, report, positions, options)
+}
 
-	reviewPrompt := fmt.Sprintf(`请对以下Pull Request的代码变更进行专业审查：
+// buildPullRequestReviewPrompt 构建PR审查提示词，diff为本次审查的部分
+func buildPullRequestReviewPrompt(pr *models.PullRequest, reviewScope, diff string) string {
+	return fmt.Sprintf(`请对以下Pull Request的代码变更进行专业审查：
 
 **Pull Request信息:**
 - PR #%d: %s
@@ -523,7 +1019,7 @@ func (ep *EventProcessor) handlePullRequestReview(command *Command, ctx *Command
 
 **审查范围:** %s
 
-**代码变更内容:**
+**代码变更内容:**（每行前的数字为新文件中的行号）
 %s
 
 **审查要点:**
@@ -536,61 +1032,96 @@ func (ep *EventProcessor) handlePullRequestReview(command *Command, ctx *Command
 7. **测试覆盖** - 是否需要添加测试
 
 **输出格式:**
-请提供结构化的PR审查报告：
-- **总体评价** - 对这次PR的整体评估
-- **主要变更分析** - 列出关键的代码修改点
-- **发现的问题** - 按严重程度分类（严重/中等/轻微）
-- **改进建议** - 具体的修改建议
-- **合并建议** - 是否建议合并及原因
-
-请用markdown格式输出。`,
-		ctx.PullRequest.Number, ctx.PullRequest.Title,
-		ctx.PullRequest.Head.Ref, ctx.PullRequest.Base.Ref,
-		ctx.PullRequest.State, ctx.PullRequest.User.Login,
-		reviewScope, prDiff)
-
-	// 在目标仓库目录中调用Claude Code CLI进行PR审查
-	reviewResult, err := ep.claudeCodeService.ReviewCodeInRepo(reviewPrompt, repoPath)
-	if err != nil {
-		log.Printf("Claude Code CLI代码审查失败: %v", err)
-		response := fmt.Sprintf(`❌ **PR代码审查失败**
+只输出一个JSON对象，不要输出其他内容：
+{
+  "summary": "总体评价、主要变更分析和合并建议（markdown）",
+  "findings": [
+    {
+      "file": "相对仓库根目录的文件路径",
+      "line": 问题所在的新文件行号（使用代码变更中标注的行号）,
+      "severity": "low、medium、high 或 critical",
+      "message": "问题说明和改进建议",
+      "suggestion": "可选，用于替换该行的完整代码，没有具体修改时留空"
+    }
+  ]
+}
 
-错误信息: %s
+没有发现问题时 findings 为空数组。`,
+		pr.Number, pr.Title,
+		pr.Head.Ref, pr.Base.Ref,
+		pr.State, pr.User.Login,
+		reviewScope, annotateDiff(diff))
+}
 
-请检查:
-1. Claude API密钥是否正确配置
-2. 网络连接是否正常
-3. API配额是否充足
+// submitPullRequestReview 将审查结果提交为PR审查，能定位到diff行的问题作为行内评论，其余问题放在审查正文中
+func (ep *EventProcessor) submitPullRequestReview(ctx *CommandContext, progress *ProgressReporter, report *ReviewReport, positions diffPositions, options prReviewOptions) error {
+	pr := ctx.PullRequest
+	progress.Begin(StageReview)
 
----
-*处理时间: %s*`, err.Error(), time.Now().Format("2006-01-02 15:04:05"))
-		return ep.createResponse(ctx, response)
+	var comments []ReviewComment
+	var unanchored []ReviewFinding
+	for _, finding := range report.Findings {
+		if position, ok := positions.Position(finding.File, finding.Line); ok {
+			comments = append(comments, ReviewComment{Path: finding.File, Position: position, Body: finding.commentBody()})
+		} else {
+			unanchored = append(unanchored, finding)
+		}
 	}
 
-	// 生成审查报告
-	response := fmt.Sprintf(`🔍 **PR代码审查报告**
-
-**PR信息:** #%d - %s
+	body := report.Summary
+	if strings.TrimSpace(body) == "" {
+		body = "审查完成。"
+	}
+	if len(unanchored) > 0 {
+		body += "\n\n### 其他发现\n以下问题不在本次diff的行中，无法添加行内评论：\n"
+		for _, finding := range unanchored {
+			body += "\n" + finding.markdown()
+		}
+	}
+	if skipped := skippedFilesMarkdown(report.Skipped); skipped != "" {
+		body += "\n\n" + skipped
+	}
+	if options.auto {
+		body = autoReviewBody(body, options.sinceSHA, pr.Head.SHA)
+	}
 
-%s
+	event := report.Event()
+	review, err := ep.githubService.CreatePullRequestReview(ctx.Repository.Owner.Login, ctx.Repository.Name,
+		pr.Number, pr.Head.SHA, body, event, comments)
+	if err != nil && event == ReviewEventRequestChanges {
+		// GitHub不允许对自己创建的PR要求修改，改为评论
+		log.Printf("以 %s 提交审查失败，改为 %s: %v", event, ReviewEventComment, err)
+		event = ReviewEventComment
+		review, err = ep.githubService.CreatePullRequestReview(ctx.Repository.Owner.Login, ctx.Repository.Name,
+			pr.Number, pr.Head.SHA, body, event, comments)
+	}
+	if err != nil {
+		log.Printf("提交PR审查失败: %v", err)
+		if progress == nil {
+			return fmt.Errorf("提交PR审查失败: %v", err)
+		}
+		progress.Fail(err)
+		return progress.Finish(report.Markdown())
+	}
 
-**审查流程:**
-1. ✅ 克隆目标仓库
-2. ✅ 获取PR代码差异
-3. ✅ 分析代码变更
-4. ✅ 评估安全性和质量
-5. ✅ 生成审查报告
+	if options.auto {
+		ep.supersedeAutoReviews(ctx, review, options.sinceSHA == "")
+	}
 
----
-*审查时间: %s*
-*由AI代码审查助手生成*`, ctx.PullRequest.Number, ctx.PullRequest.Title, reviewResult, time.Now().Format("2006-01-02 15:04:05"))
+	detail := fmt.Sprintf("%d 条行内评论，结论 %s", len(comments), event)
+	progress.Done(detail)
 
-	return ep.createResponse(ctx, response)
+	result := fmt.Sprintf("**PR信息:** #%d - %s\n\n已提交PR审查：%d 个问题，其中 %d 条行内评论。", pr.Number, pr.Title, len(report.Findings), len(comments))
+	if review.HTMLURL != "" {
+		result += fmt.Sprintf("\n\n🔗 [查看审查](%s)", review.HTMLURL)
+	}
+	return progress.Finish(result)
 }
 
 // handleGeneralReview 处理一般代码审查（Issue上下文）
 func (ep *EventProcessor) handleGeneralReview(command *Command, ctx *CommandContext) error {
 	log.Printf("处理一般代码审查")
+	progress := ep.startProgress(ctx, "代码审查", StageClone, StageAgent)
 
 	// 获取分支名
 	sourceBranch := "main"
@@ -599,12 +1130,13 @@ func (ep *EventProcessor) handleGeneralReview(command *Command, ctx *CommandCont
 	}
 
 	// 克隆仓库
-	repoPath, err := ep.gitService.CloneRepository(ctx.Repository.CloneURL, sourceBranch)
+	progress.Begin(StageClone)
+	repoPath, err := ep.gitService.CloneRepository(ctx.jobContext(), ctx.Repository.CloneURL, sourceBranch)
 	if err != nil {
 		log.Printf("克隆仓库失败: %v", err)
-		response := fmt.Sprintf("❌ 代码审查失败: 克隆仓库失败 - %v", err.Error())
-		return ep.createResponse(ctx, response)
+		return progress.Abort(fmt.Errorf("克隆仓库失败: %v", err))
 	}
+	progress.Done(fmt.Sprintf("分支 %s", sourceBranch))
 
 	// 清理工作目录
 	defer func() {
@@ -628,6 +1160,7 @@ func (ep *EventProcessor) handleGeneralReview(command *Command, ctx *CommandCont
 	if command.Args != "" {
 		reviewScope = command.Args
 	}
+	reviewScope += buildReviewFilters(command)
 
 	// 构建代码审查提示词
 	reviewPrompt := fmt.Sprintf(`请对以下代码进行专业的代码审查：
@@ -660,42 +1193,20 @@ func (ep *EventProcessor) handleGeneralReview(command *Command, ctx *CommandCont
 请用markdown格式输出。`, reviewScope, context, fileTree)
 
 	// 在目标仓库目录中调用Claude Code CLI进行代码审查
-	reviewResult, err := ep.claudeCodeService.ReviewCodeInRepo(reviewPrompt, repoPath)
+	progress.Begin(StageAgent)
+	reviewResult, err := ep.repoAgent(ctx, command.Command).ReviewInRepo(ctx.jobContext(), reviewPrompt, repoPath)
 	if err != nil {
 		log.Printf("Claude Code CLI代码审查失败: %v", err)
-		response := fmt.Sprintf(`❌ **代码审查失败**
-
-错误信息: %s
-
-请检查:
-1. Claude API密钥是否正确配置
-2. 网络连接是否正常
-3. API配额是否充足
-
----
-*处理时间: %s*`, err.Error(), time.Now().Format("2006-01-02 15:04:05"))
-		return ep.createResponse(ctx, response)
+		return progress.Abort(err)
 	}
+	progress.Done(reviewResult.Summary())
 
 	// 生成审查报告
-	response := fmt.Sprintf(`🔍 **代码审查报告**
-
-**审查范围:** %s
-
-%s
+	response := fmt.Sprintf(`**审查范围:** %s
 
-**审查流程:**
-1. ✅ 克隆目标仓库
-2. ✅ 分析项目结构
-3. ✅ 检查代码质量
-4. ✅ 评估安全性
-5. ✅ 生成审查报告
+%s`, reviewScope, reviewResult.Output)
 
----
-*审查时间: %s*
-*由AI代码审查助手生成*`, reviewScope, reviewResult, time.Now().Format("2006-01-02 15:04:05"))
-
-	return ep.createResponse(ctx, response)
+	return progress.Finish(response)
 }
 
 // handleCodeCommand 处理代码生成命令
@@ -724,114 +1235,470 @@ func (ep *EventProcessor) handleCodeCommand(command *Command, ctx *CommandContex
 		Sender:     ctx.User,
 	}
 
+	options := &ModifyOptions{
+		BaseBranch: command.Flag("base"),
+		Draft:      command.BoolFlag("draft"),
+		Files:      command.ListFlag("files"),
+		Config:     ctx.Config,
+	}
+
 	// 直接调用自动分析和修改功能
-	return ep.autoAnalyzeAndModify(issuesEvent)
+	return ep.autoAnalyzeAndModify(ctx.jobContext(), issuesEvent, options)
 }
 
-// handleContinueCommand 处理继续命令
+// handleContinueCommand 处理继续命令，在Issue/PR已有的机器人分支上继续修改并推送到同一分支
 func (ep *EventProcessor) handleContinueCommand(command *Command, ctx *CommandContext) error {
 	log.Printf("处理继续命令: %s", command.Args)
+	jobCtx := ctx.jobContext()
 
-	// 构建项目上下文
-	context := ep.buildProjectContext(ctx)
+	progress := ep.startProgress(ctx, "继续开发", StageClone, StageAgent, StageCommit, StagePush, StagePR)
 
-	// 调用Claude Code CLI继续开发
-	continuedCode, err := ep.claudeCodeService.ContinueCode(command.Args, context)
+	branch, err := ep.findBotBranch(jobCtx, ctx)
+	if err != nil {
+		log.Printf("查找开发分支失败: %v", err)
+		return progress.Abort(err)
+	}
+	log.Printf("继续开发分支: %s, 目标分支: %s", branch.Name, branch.BaseBranch)
+
+	// 检出机器人分支
+	progress.Begin(StageClone)
+	repoPath, err := ep.gitService.CloneRepository(jobCtx, ctx.Repository.CloneURL, branch.Name)
+	if err != nil {
+		log.Printf("克隆仓库失败: %v", err)
+		return progress.Abort(fmt.Errorf("克隆仓库失败: %v", err))
+	}
+	defer func() {
+		if err := ep.gitService.Cleanup(repoPath); err != nil {
+			log.Printf("清理工作目录失败: %v", err)
+		}
+	}()
+	progress.Done(fmt.Sprintf("分支 %s", branch.Name))
+
+	authorName, authorEmail := ep.commitAuthor()
+	if err := ep.gitService.ConfigureGit(repoPath, authorName, authorEmail); err != nil {
+		log.Printf("配置Git失败: %v", err)
+	}
+
+	// 分支上已有的修改和讨论记录作为AI的上下文
+	previousDiff, err := ep.gitService.GetBranchDiff(jobCtx, repoPath, branch.BaseBranch)
+	if err != nil {
+		if errors.Is(err, ErrJobCanceled) {
+			return progress.Abort(err)
+		}
+		log.Printf("获取分支修改失败: %v", err)
+		previousDiff = "无法获取分支上已有的修改"
+	}
+	previousDiff = ep.truncateString(previousDiff, 20000)
+
+	prompt := buildContinuePrompt(command.Args, ep.buildEnhancedProjectContext(ctx, repoPath),
+		branch.Name, previousDiff, ep.buildConversationContext(ctx))
+
+	progress.Begin(StageAgent)
+	continueResult, err := ep.agent(ctx, command.Command).GenerateInRepo(jobCtx, prompt, repoPath)
 	if err != nil {
 		log.Printf("Claude Code CLI调用失败: %v", err)
-		response := fmt.Sprintf(`❌ **继续开发失败**
+		return progress.Abort(fmt.Errorf("AI修改代码失败: %v", err))
+	}
+	progress.Done(continueResult.Summary())
 
-错误信息: %s
+	if status, err := ep.gitService.GetStatus(repoPath); err == nil && strings.TrimSpace(status) == "" {
+		log.Printf("没有检测到代码修改，分支保持不变: %s", branch.Name)
+		return progress.Finish(fmt.Sprintf(`**继续指令:** %s
 
-请检查:
-1. Claude API密钥是否正确配置
-2. 网络连接是否正常
-3. API配额是否充足
+ℹ️ 没有检测到代码修改，分支 %s 保持不变。
 
----
-*处理时间: %s*`, err.Error(), time.Now().Format("2006-01-02 15:04:05"))
-		return ep.createResponse(ctx, response)
+%s`, command.Args, branch.Name, continueResult.Output))
+	}
+
+	// 推送到同一分支，已有PR时不再创建
+	options := &ModifyOptions{
+		Config:         ctx.Config,
+		progress:       progress,
+		pullRequestURL: branch.PullRequestURL,
+		commitMessage: func(builder *CommitBuilder, files []string) string {
+			return builder.BuildContinueCommit(ep.botBranchEvent(ctx), command.Args, files)
+		},
+	}
+	commitResult, err := ep.commitAndPushChanges(jobCtx, repoPath, ep.botBranchEvent(ctx), branch.Name, branch.BaseBranch, options)
+	if err != nil {
+		log.Printf("提交代码失败: %v", err)
+		return progress.Abort(err)
 	}
 
-	response := fmt.Sprintf(`🔄 **继续开发**
+	response := fmt.Sprintf(`**继续指令:** %s
+**分支:** %s
 
+## 修改结果
 %s
 
-**处理流程:**
-1. ✅ 获取当前进度
-2. ✅ 分析历史上下文
-3. ✅ 继续代码生成完成
+## 提交信息
+%s`, command.Args, branch.Name, continueResult.Output, commitResult)
 
-**继续开发的代码:**
+	return progress.Finish(response)
+}
 
-%s
+// botBranch 机器人之前为Issue/PR创建的工作分支
+type botBranch struct {
+	Name           string // 分支名称
+	BaseBranch     string // PR目标分支
+	PullRequestURL string // 已打开的PR，没有时为空
+}
 
----
-*处理时间: %s*`, command.Args, continuedCode, time.Now().Format("2006-01-02 15:04:05"))
+// findBotBranch 查找可以继续修改的分支：PR上下文使用PR的head分支，Issue上下文使用该Issue最新的机器人分支
+func (ep *EventProcessor) findBotBranch(jobCtx context.Context, ctx *CommandContext) (*botBranch, error) {
+	if ctx.PullRequest != nil {
+		pr := ctx.PullRequest
+		if pr.Head.Repo.FullName != "" && pr.Head.Repo.FullName != ctx.Repository.FullName {
+			return nil, fmt.Errorf("PR #%d 来自fork仓库 %s，无法推送到其分支", pr.Number, pr.Head.Repo.FullName)
+		}
+		return &botBranch{Name: pr.Head.Ref, BaseBranch: pr.Base.Ref, PullRequestURL: pr.HTMLURL}, nil
+	}
 
-	return ep.createResponse(ctx, response)
+	if ctx.Issue == nil {
+		return nil, fmt.Errorf("无法确定要继续的Issue或PR")
+	}
+
+	number := ctx.Issue.Number
+	branches, err := ep.gitService.ListRemoteBranches(jobCtx, ctx.Repository.CloneURL, ctx.Config.IssueBranchGlob(number))
+	if err != nil {
+		return nil, err
+	}
+	name := ctx.Config.LatestIssueBranch(number, branches)
+	if name == "" {
+		return nil, fmt.Errorf("没有找到Issue #%d 的开发分支，请先使用 /code 生成代码", number)
+	}
+
+	branch := &botBranch{Name: name, BaseBranch: ctx.Repository.DefaultBranch}
+	if branch.BaseBranch == "" {
+		branch.BaseBranch = "main"
+	}
+
+	// 查询分支对应的PR，失败时按没有PR处理，推送后会尝试创建
+	pr, err := ep.githubService.FindPullRequestByHead(ctx.Repository.Owner.Login, ctx.Repository.Name, name)
+	if err != nil {
+		log.Printf("查询分支 %s 的PR失败: %v", name, err)
+	} else if pr != nil {
+		branch.PullRequestURL = pr.HTMLURL
+		if pr.Base.Ref != "" {
+			branch.BaseBranch = pr.Base.Ref
+		}
+	}
+
+	return branch, nil
+}
+
+// botBranchEvent 构造用于生成commit消息和PR的事件，PR上下文中以PR代替Issue
+func (ep *EventProcessor) botBranchEvent(ctx *CommandContext) *models.GitHubEvent {
+	event := &models.GitHubEvent{
+		Type:       "issues",
+		Repository: ctx.Repository,
+		Sender:     ctx.User,
+	}
+
+	if ctx.PullRequest != nil {
+		event.Type = "pull_request"
+	}
+	if ctx.Issue != nil {
+		event.Issue = *ctx.Issue
+	} else if ctx.PullRequest != nil {
+		event.Issue = models.Issue{
+			Number:  ctx.PullRequest.Number,
+			Title:   ctx.PullRequest.Title,
+			Body:    ctx.PullRequest.Body,
+			HTMLURL: ctx.PullRequest.HTMLURL,
+		}
+	}
+	return event
 }
 
-// handleFixCommand 处理修复命令
+// buildConversationContext 构建Issue/PR中最近的讨论记录，不包含触发命令的评论
+func (ep *EventProcessor) buildConversationContext(ctx *CommandContext) string {
+	number := 0
+	if ctx.Issue != nil {
+		number = ctx.Issue.Number
+	} else if ctx.PullRequest != nil {
+		number = ctx.PullRequest.Number
+	}
+
+	comments, err := ep.githubService.ListIssueComments(ctx.Repository.Owner.Login, ctx.Repository.Name, number)
+	if err != nil {
+		log.Printf("获取讨论记录失败: %v", err)
+		return "无法获取讨论记录"
+	}
+
+	var recent []CommentResponse
+	for _, comment := range comments {
+		if ctx.Comment == nil || comment.ID != ctx.Comment.ID {
+			recent = append(recent, comment)
+		}
+	}
+	if len(recent) > 10 {
+		recent = recent[len(recent)-10:]
+	}
+	if len(recent) == 0 {
+		return "无"
+	}
+
+	var conversation strings.Builder
+	for _, comment := range recent {
+		fmt.Fprintf(&conversation, "- @%s: %s\n", comment.User.Login, ep.truncateString(comment.Body, 500))
+	}
+	return conversation.String()
+}
+
+// handleFixCommand 处理修复命令：在PR上修改PR分支，在Issue上创建修复PR
 func (ep *EventProcessor) handleFixCommand(command *Command, ctx *CommandContext) error {
 	log.Printf("处理修复命令: %s", command.Args)
 
-	// 构建项目上下文
-	context := ep.buildProjectContext(ctx)
+	if ctx.PullRequest != nil {
+		return ep.fixPullRequest(command, ctx)
+	}
+	return ep.fixIssue(command, ctx)
+}
+
+// fixContext 构建修复命令的上下文，--files 指定的文件作为重点
+func (ep *EventProcessor) fixContext(command *Command, ctx *CommandContext, repoPath string) string {
+	context := ep.buildEnhancedProjectContext(ctx, repoPath)
+	if files := command.ListFlag("files"); len(files) > 0 {
+		context += fmt.Sprintf("\n**重点关注的文件:**\n- %s\n", strings.Join(files, "\n- "))
+	}
+	return context
+}
+
+// fixIssue 在Issue上修复问题，流程与 /code 相同，生成fix类型的提交并创建修复PR
+func (ep *EventProcessor) fixIssue(command *Command, ctx *CommandContext) error {
+	problem := command.Args
+	if strings.TrimSpace(problem) == "" {
+		problem = ctx.Issue.Title
+	}
 
-	// 调用Claude Code CLI修复代码
-	fixedCode, err := ep.claudeCodeService.FixCode(command.Args, context)
+	fixIssue := *ctx.Issue
+	fixIssue.Title = fmt.Sprintf("问题修复: %s", problem)
+	fixIssue.Body = fmt.Sprintf(`**原Issue内容:**
+%s
+
+**需要修复的问题:**
+%s`, ctx.Issue.Body, problem)
+
+	issuesEvent := &models.IssuesEvent{
+		Action:     "opened",
+		Issue:      fixIssue,
+		Repository: ctx.Repository,
+		Sender:     ctx.User,
+	}
+
+	options := &ModifyOptions{
+		Config: ctx.Config,
+		prompt: buildFixPrompt(problem, ep.fixContext(command, ctx, "")),
+		commitMessage: func(builder *CommitBuilder, files []string) string {
+			return builder.BuildFixCommit(ep.botBranchEvent(ctx), problem, files)
+		},
+	}
+
+	return ep.autoAnalyzeAndModify(ctx.jobContext(), issuesEvent, options)
+}
+
+// fixPullRequest 在PR分支上修复问题并推送到该分支，没有推送权限时推送到新分支并创建后续PR
+// fork仓库的PR不处理：fork中的提交未经审查，推送到本仓库会以本仓库的密钥运行其中（包括 .github/workflows）的代码
+func (ep *EventProcessor) fixPullRequest(command *Command, ctx *CommandContext) error {
+	jobCtx := ctx.jobContext()
+	pr := ctx.PullRequest
+
+	if pr.Head.Repo.FullName != "" && !strings.EqualFold(pr.Head.Repo.FullName, ctx.Repository.FullName) {
+		log.Printf("PR #%d 来自fork仓库 %s，拒绝修复", pr.Number, pr.Head.Repo.FullName)
+		return ep.createResponse(ctx, fmt.Sprintf("⚠️ PR #%d 来自fork仓库 %s，`/fix` 不处理fork仓库的PR。\n\n"+
+			"fork中的提交未经审查，推送到本仓库会以本仓库的密钥运行其中的代码（包括对 `.github/workflows` 的修改）。"+
+			"请在fork中修复，或由维护者审查后将分支推送到本仓库再使用 `/fix`。",
+			pr.Number, pr.Head.Repo.FullName))
+	}
+
+	problem := command.Args
+	if strings.TrimSpace(problem) == "" {
+		problem = pr.Title
+	}
+
+	progress := ep.startProgress(ctx, "代码修复", StageClone, StageAgent, StageCommit, StagePush, StagePR)
+	followUpBranch := fmt.Sprintf("auto-fix-pr-%d-%s", pr.Number, time.Now().Format("20060102-150405"))
+
+	progress.Begin(StageClone)
+	repoPath, err := ep.gitService.CloneRepository(jobCtx, ctx.Repository.CloneURL, pr.Head.Ref)
+	if err != nil {
+		log.Printf("克隆仓库失败: %v", err)
+		return progress.Abort(fmt.Errorf("克隆仓库失败: %v", err))
+	}
+	defer func() {
+		if err := ep.gitService.Cleanup(repoPath); err != nil {
+			log.Printf("清理工作目录失败: %v", err)
+		}
+	}()
+	progress.Done(fmt.Sprintf("PR #%d (%s)", pr.Number, pr.Head.Ref))
+
+	authorName, authorEmail := ep.commitAuthor()
+	if err := ep.gitService.ConfigureGit(repoPath, authorName, authorEmail); err != nil {
+		log.Printf("配置Git失败: %v", err)
+	}
+
+	context := ep.fixContext(command, ctx, repoPath)
+	if prDiff, err := ep.gitService.GetBranchDiff(jobCtx, repoPath, pr.Base.Ref); err != nil {
+		if errors.Is(err, ErrJobCanceled) {
+			return progress.Abort(err)
+		}
+		log.Printf("获取PR修改失败: %v", err)
+	} else {
+		context += fmt.Sprintf("\n**PR的代码修改:**\n```diff\n%s\n```\n", ep.truncateString(prDiff, 20000))
+	}
+
+	progress.Begin(StageAgent)
+	fixResult, err := ep.agent(ctx, command.Command).GenerateInRepo(jobCtx, buildFixPrompt(problem, context), repoPath)
 	if err != nil {
 		log.Printf("Claude Code CLI调用失败: %v", err)
-		response := fmt.Sprintf(`❌ **代码修复失败**
+		return progress.Abort(fmt.Errorf("AI修复代码失败: %v", err))
+	}
+	progress.Done(fixResult.Summary())
 
-错误信息: %s
+	if status, err := ep.gitService.GetStatus(repoPath); err == nil && strings.TrimSpace(status) == "" {
+		log.Printf("没有检测到代码修改: PR #%d", pr.Number)
+		return progress.Finish(fmt.Sprintf(`**问题描述:** %s
 
-请检查:
-1. Claude API密钥是否正确配置
-2. 网络连接是否正常
-3. API配额是否充足
+ℹ️ 没有检测到代码修改，PR分支保持不变。
 
----
-*处理时间: %s*`, err.Error(), time.Now().Format("2006-01-02 15:04:05"))
-		return ep.createResponse(ctx, response)
+%s`, problem, fixResult.Output))
 	}
 
-	response := fmt.Sprintf(`🔧 **代码修复**
+	options := &ModifyOptions{
+		Config:   ctx.Config,
+		progress: progress,
+		commitMessage: func(builder *CommitBuilder, files []string) string {
+			return builder.BuildFixCommit(ep.botBranchEvent(ctx), problem, files)
+		},
+		prTitle: fmt.Sprintf("fix: 修复PR #%d 中的问题", pr.Number),
+	}
 
-问题描述: %s
+	options.pullRequestURL = pr.HTMLURL
+	options.fallbackBranch = followUpBranch
+	options.prBody = buildFollowUpPRBody(pr.Number, problem,
+		fmt.Sprintf("无法推送到原PR分支 %s，本PR以该分支为目标，合并后修复会进入原PR。", pr.Head.Ref))
 
-**修复流程:**
-1. ✅ 分析问题
-2. ✅ 定位错误代码
-3. ✅ 生成修复方案
-4. ✅ 应用修复完成
+	commitResult, err := ep.commitAndPushChanges(jobCtx, repoPath, ep.botBranchEvent(ctx), pr.Head.Ref, pr.Base.Ref, options)
+	if err != nil {
+		log.Printf("提交代码失败: %v", err)
+		return progress.Abort(err)
+	}
 
-**修复后的代码:**
+	response := fmt.Sprintf(`**问题描述:** %s
 
+## 修复结果
+%s
+
+## 提交信息
+%s`, problem, fixResult.Output, commitResult)
+
+	return progress.Finish(response)
+}
+
+// buildFollowUpPRBody 构建修复PR的描述
+func buildFollowUpPRBody(number int, problem, note string) string {
+	return fmt.Sprintf(`## 修复 #%d 中的问题
+
+此PR由AI助手根据 `+"`/fix`"+` 指令自动生成。
+
+### 问题描述
+%s
+
+### 说明
 %s
 
 ---
-*处理时间: %s*`, command.Args, fixedCode, time.Now().Format("2006-01-02 15:04:05"))
+*此PR由GitHub Webhook AI助手自动创建*`, number, problem, note)
+}
 
-	return ep.createResponse(ctx, response)
+// handleCancelCommand 处理取消命令，终止当前Issue/PR中排队和执行中的任务
+func (ep *EventProcessor) handleCancelCommand(command *Command, ctx *CommandContext) error {
+	key := jobKey(ctx)
+	canceled := ep.jobs.Cancel(key)
+	log.Printf("取消任务: %s, 数量: %d", key, len(canceled))
+
+	if len(canceled) == 0 {
+		return ep.createResponse(ctx, "ℹ️ 当前没有排队或执行中的任务。")
+	}
+
+	var response strings.Builder
+	fmt.Fprintf(&response, "🛑 **已取消 %d 个任务**\n\n", len(canceled))
+	for _, job := range canceled {
+		response.WriteString(describeTrackedJob(job))
+	}
+	response.WriteString("\n执行中的任务会立即终止并清理工作目录，已推送的分支和已创建的PR不会回滚。")
+
+	return ep.createResponse(ctx, response.String())
+}
+
+// handleStatusCommand 处理状态命令，列出当前Issue/PR中排队和执行中的任务
+func (ep *EventProcessor) handleStatusCommand(command *Command, ctx *CommandContext) error {
+	jobs := ep.jobs.List(jobKey(ctx))
+	if len(jobs) == 0 {
+		return ep.createResponse(ctx, "ℹ️ 当前没有排队或执行中的任务。")
+	}
+
+	var response strings.Builder
+	fmt.Fprintf(&response, "📋 **任务状态**（共 %d 个）\n\n", len(jobs))
+	for _, job := range jobs {
+		response.WriteString(describeTrackedJob(job))
+	}
+	response.WriteString("\n使用 `/cancel` 取消这些任务。")
+
+	return ep.createResponse(ctx, response.String())
+}
+
+// describeTrackedJob 任务的单行描述
+func describeTrackedJob(job TrackedJob) string {
+	state := fmt.Sprintf("⬜ 排队中，已等待 %s", formatElapsed(time.Since(job.EnqueuedAt)))
+	if job.Status == JobStatusRunning {
+		state = fmt.Sprintf("⏳ 执行中，已运行 %s", formatElapsed(time.Since(job.StartedAt)))
+	}
+	return fmt.Sprintf("- `%s` %s（@%s，任务ID: `%s`）\n", job.Commands, state, job.User, job.ID)
 }
 
 // handleHelpCommand 处理帮助命令
 func (ep *EventProcessor) handleHelpCommand(command *Command, ctx *CommandContext) error {
 	log.Printf("处理帮助命令")
 
+	var commandList strings.Builder
+	for _, handler := range ep.commands.Handlers() {
+		// 只列出仓库配置中启用的命令
+		if handler.Name() != "help" && !ctx.Config.CommandEnabled(handler.Name()) {
+			continue
+		}
+		commandList.WriteString(fmt.Sprintf("🔹 `%s` - %s", handler.Usage(), handler.Help()))
+
+		var notes []string
+		if len(handler.Aliases()) > 0 {
+			aliases := make([]string, 0, len(handler.Aliases()))
+			for _, alias := range handler.Aliases() {
+				aliases = append(aliases, "`/"+alias+"`")
+			}
+			notes = append(notes, "别名: "+strings.Join(aliases, ", "))
+		}
+		if handler.Scope() != ScopeAll {
+			notes = append(notes, "仅限"+handler.Scope().String())
+		}
+		if handler.Permission() > PermissionRead {
+			notes = append(notes, "需要"+handler.Permission().String()+"权限")
+		}
+		if len(notes) > 0 {
+			commandList.WriteString("（" + strings.Join(notes, "；") + "）")
+		}
+		commandList.WriteString("\n")
+
+		for _, flag := range handler.Flags() {
+			commandList.WriteString(fmt.Sprintf("　　`%s` %s\n", flag, flag.Usage))
+		}
+	}
+
 	response := `📖 **CodeAgent 帮助**
 
 **支持的命令:**
 
-🔹 ` + "`" + `/code <需求描述>` + "`" + ` - 自动分析并实现到代码库
-🔹 ` + "`" + `/continue [说明]` + "`" + ` - 继续当前的开发任务
-🔹 ` + "`" + `/fix <问题描述>` + "`" + ` - 修复指定的代码问题
-🔹 ` + "`" + `/review [范围]` + "`" + ` - 对代码进行专业审查
-🔹 ` + "`" + `/summary [内容]` + "`" + ` - 生成项目或内容总结
-🔹 ` + "`" + `/help` + "`" + ` - 显示此帮助信息
-
+` + commandList.String() + `
 **使用示例:**
 - ` + "`" + `/code 创建一个用户登录API` + "`" + ` - 自动分析并实现到项目中
 - ` + "`" + `/code 添加JWT认证功能` + "`" + ` - 自动分析并修改代码
@@ -988,8 +1855,36 @@ func (ep *EventProcessor) buildEnhancedProjectContext(ctx *CommandContext, repoP
 	return context.String()
 }
 
+// ModifyOptions 代码修改选项，来自 /code 命令参数
+type ModifyOptions struct {
+	BaseBranch string   // 基础分支，为空时使用仓库默认分支
+	Draft      bool     // 是否创建草稿PR
+	Files      []string // 允许修改的路径模式，为空时不限制
+	Config     *RepoConfig
+
+	progress       *ProgressReporter                                   // 进度评论
+	prompt         string                                              // 覆盖默认的代码修改提示词
+	commitMessage  func(builder *CommitBuilder, files []string) string // 生成commit消息，为空时生成自动修复Issue的commit消息
+	pullRequestURL string                                              // 分支已有的PR，推送即更新该PR，不再创建
+	fallbackBranch string                                              // 无法推送到原分支时改为推送到该分支，并创建以原分支为目标的PR
+	prTitle        string                                              // 覆盖默认的PR标题
+	prBody         string                                              // 覆盖默认的PR描述
+}
+
+// allowsPath 检查文件是否在 --files 和仓库配置允许的修改范围内
+func (o *ModifyOptions) allowsPath(path string) bool {
+	if len(o.Files) > 0 && !matchAnyPathPattern(o.Files, path) {
+		return false
+	}
+	return o.Config.AllowsPath(path)
+}
+
 // autoAnalyzeAndModify 自动分析Issue并修改代码
-func (ep *EventProcessor) autoAnalyzeAndModify(event *models.IssuesEvent) error {
+func (ep *EventProcessor) autoAnalyzeAndModify(jobCtx context.Context, event *models.IssuesEvent, options *ModifyOptions) error {
+	if options == nil {
+		options = &ModifyOptions{}
+	}
+
 	log.Printf("开始自动分析Issue: #%d", event.Issue.Number)
 
 	// 检查是否已经有相同的分支存在，避免重复处理
@@ -1004,8 +1899,14 @@ func (ep *EventProcessor) autoAnalyzeAndModify(event *models.IssuesEvent) error
 		Repository: event.Repository,
 		Issue:      &event.Issue,
 		User:       event.Sender,
+		Config:     options.Config,
+		Context:    jobCtx,
 	}
 
+	// 立即发布进度评论，之后随各阶段的实际结果更新
+	progress := ep.startProgress(ctx, "自动修复", StageClone, StageAgent, StageCommit, StagePush, StagePR)
+	options.progress = progress
+
 	// 创建GitHub事件结构用于分支名获取
 	gitHubEvent := &models.GitHubEvent{
 		Repository: event.Repository,
@@ -1013,21 +1914,21 @@ func (ep *EventProcessor) autoAnalyzeAndModify(event *models.IssuesEvent) error
 		Sender:     event.Sender,
 	}
 
-	// 动态获取分支名
+	// 动态获取分支名，--base 参数优先
 	sourceBranch := ep.getBranchName(gitHubEvent, ctx)
+	if options.BaseBranch != "" {
+		sourceBranch = options.BaseBranch
+	}
 	log.Printf("使用分支: %s", sourceBranch)
 
 	// 克隆仓库
-	repoPath, err := ep.gitService.CloneRepository(event.Repository.CloneURL, sourceBranch)
+	progress.Begin(StageClone)
+	repoPath, err := ep.gitService.CloneRepository(jobCtx, event.Repository.CloneURL, sourceBranch)
 	if err != nil {
 		log.Printf("克隆仓库失败: %v", err)
-		errorMsg := fmt.Sprintf("自动分析失败: 克隆仓库失败 - %v", err.Error())
-		return ep.createResponse(&CommandContext{
-			Repository: event.Repository,
-			Issue:      &event.Issue,
-			User:       event.Sender,
-		}, errorMsg)
+		return progress.Abort(fmt.Errorf("克隆仓库失败: %v", err))
 	}
+	progress.Done(fmt.Sprintf("分支 %s", sourceBranch))
 
 	// 清理工作目录
 	defer func() {
@@ -1039,15 +1940,15 @@ func (ep *EventProcessor) autoAnalyzeAndModify(event *models.IssuesEvent) error
 	log.Printf("仓库路径: %s", repoPath)
 
 	// 配置Git用户
-	if err := ep.gitService.ConfigureGit(repoPath, "CodeAgent", "codeagent@example.com"); err != nil {
+	authorName, authorEmail := ep.commitAuthor()
+	if err := ep.gitService.ConfigureGit(repoPath, authorName, authorEmail); err != nil {
 		log.Printf("配置Git失败: %v", err)
 	}
 
 	log.Printf("准备在仓库目录中直接进行代码修改")
 
-	// 创建新分支，使用带时间戳的分支名避免冲突
-	timestamp := time.Now().Format("20060102-150405")
-	branchName = fmt.Sprintf("auto-fix-issue-%d-%s", event.Issue.Number, timestamp)
+	// 创建新分支，按仓库配置的命名规则生成分支名，默认带时间戳避免冲突
+	branchName = options.Config.BranchName(event.Issue.Number, event.Sender.Login)
 	log.Printf("创建分支: %s", branchName)
 	if err := ep.gitService.CreateBranch(repoPath, branchName); err != nil {
 		log.Printf("创建分支失败: %v", err)
@@ -1067,17 +1968,30 @@ func (ep *EventProcessor) autoAnalyzeAndModify(event *models.IssuesEvent) error
 %s
 
 请创建必要的文件来实现这个功能。使用适当的编程语言（HTML/CSS/JavaScript、Python、Go等），确保代码完整可运行。`, event.Issue.Title, event.Issue.Body)
+	if options.prompt != "" {
+		modificationPrompt = options.prompt
+	}
+
+	if len(options.Files) > 0 {
+		modificationPrompt += fmt.Sprintf("\n\n**修改范围限制:** 只允许修改或创建匹配以下路径模式的文件，其他文件的修改不会被提交：\n- %s",
+			strings.Join(options.Files, "\n- "))
+	}
+	if options.Config != nil && len(options.Config.Paths.Allow) > 0 {
+		modificationPrompt += fmt.Sprintf("\n\n**仓库允许修改的路径:**\n- %s", strings.Join(options.Config.Paths.Allow, "\n- "))
+	}
+	if options.Config != nil && len(options.Config.Paths.Deny) > 0 {
+		modificationPrompt += fmt.Sprintf("\n\n**禁止修改的路径:**\n- %s", strings.Join(options.Config.Paths.Deny, "\n- "))
+	}
 
-	modificationResult, err := ep.claudeCodeService.GenerateCodeInRepo(modificationPrompt, repoPath)
+	progress.Begin(StageAgent)
+	modificationResult, err := ep.agent(ctx, "code").GenerateInRepo(ctx.jobContext(), modificationPrompt, repoPath)
 	if err != nil {
 		log.Printf("Claude Code CLI代码修改失败: %v", err)
-		errorMsg := fmt.Sprintf("自动修改失败: %v", err.Error())
-		return ep.createResponse(&CommandContext{
-			Repository: event.Repository,
-			Issue:      &event.Issue,
-			User:       event.Sender,
-		}, errorMsg)
+		return progress.Abort(fmt.Errorf("AI修改代码失败: %v", err))
 	}
+	progress.Done(modificationResult.Summary())
+
+	log.Printf("AI修改的文件: %v", modificationResult.ChangedFiles())
 
 	// 调试：检查工作目录的文件变化
 	log.Printf("Claude CLI执行后检查文件状态...")
@@ -1091,60 +2005,41 @@ func (ep *EventProcessor) autoAnalyzeAndModify(event *models.IssuesEvent) error
 	}
 
 	// 提交修改到仓库
-	commitResult, err := ep.commitAndPushChanges(repoPath, gitHubEventForModification, branchName, sourceBranch)
+	commitResult, err := ep.commitAndPushChanges(jobCtx, repoPath, gitHubEventForModification, branchName, sourceBranch, options)
 	if err != nil {
 		log.Printf("提交代码失败: %v", err)
-		errorMsg := fmt.Sprintf("代码提交失败: %v", err.Error())
-		return ep.createResponse(&CommandContext{
-			Repository: event.Repository,
-			Issue:      &event.Issue,
-			User:       event.Sender,
-		}, errorMsg)
+		return progress.Abort(err)
 	}
 
-	// 在Issue中回复
-	response := fmt.Sprintf(`🤖 **自动修复已完成**
-
-## Issue信息
+	// 在Issue中回复最终结果
+	response := fmt.Sprintf(`## Issue信息
 - **标题**: %s
 - **编号**: #%d
-
-## 处理流程
-1. ✅ 克隆仓库
-2. ✅ AI分析Issue需求  
-3. ✅ 创建修复分支: %s
-4. ✅ 应用代码修改
-5. ✅ 提交更改到仓库
-6. ✅ 推送到远程分支
-7. ✅ 创建Pull Request
+- **分支**: %s
 
 ## 修改结果
 %s
 
-## 提交信息  
+## 提交信息
 %s
 
 ## 下一步
-请在以下Pull Request中review代码修改，确认无误后进行合并。
-
----
-*此回复由AI助手自动生成*`,
+请在以下Pull Request中review代码修改，确认无误后进行合并。`,
 		event.Issue.Title, event.Issue.Number,
-		fmt.Sprintf("auto-fix-issue-%d", event.Issue.Number),
-		modificationResult, commitResult)
+		branchName,
+		modificationResult.Output, commitResult)
 
-	return ep.createResponse(&CommandContext{
-		Repository: event.Repository,
-		Issue:      &event.Issue,
-		User:       event.Sender,
-	}, response)
+	return progress.Finish(response)
 }
 
-// truncateString 截断字符串
+// truncateString 截断字符串，不会截断多字节字符
 func (ep *EventProcessor) truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
 		return s
 	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
 	return s[:maxLen] + "..."
 }
 
@@ -1195,15 +2090,15 @@ func (ep *EventProcessor) applyCodeModifications(repoPath, analysisResult string
 		event.Issue.Title, event.Issue.Body, event.Issue.Number, analysisResult)
 
 	// 调用AI获取具体的修改方案
-	modificationResult, err := ep.claudeCodeService.GenerateCode(modificationPrompt, "")
+	modificationResult, err := ep.agents.Select("code", nil).GenerateInRepo(context.Background(), buildCodeGenerationPrompt(modificationPrompt, ""), "")
 	if err != nil {
 		return "", fmt.Errorf("获取代码修改方案失败: %v", err)
 	}
 
-	log.Printf("收到AI修改方案: %s", modificationResult)
+	log.Printf("收到AI修改方案: %s", modificationResult.Output)
 
 	// 解析AI返回的JSON修改方案
-	modifications, err := ep.parseModificationResult(modificationResult)
+	modifications, err := ep.parseModificationResult(modificationResult.Output)
 	if err != nil {
 		return "", fmt.Errorf("解析修改方案失败: %v", err)
 	}
@@ -1276,14 +2171,42 @@ func (ep *EventProcessor) applyFileModification(repoPath string, mod FileModific
 }
 
 // commitAndPushChanges 提交并推送代码修改
-func (ep *EventProcessor) commitAndPushChanges(repoPath string, event *models.GitHubEvent, branchName, sourceBranch string) (string, error) {
+func (ep *EventProcessor) commitAndPushChanges(jobCtx context.Context, repoPath string, event *models.GitHubEvent, branchName, sourceBranch string, options *ModifyOptions) (string, error) {
 	log.Printf("开始提交代码修改")
+	progress := options.progress
+	progress.Begin(StageCommit)
 
 	// 添加所有修改的文件到暂存区
 	if err := ep.gitService.AddFiles(repoPath, []string{"."}); err != nil {
 		return "", fmt.Errorf("添加文件到暂存区失败: %v", err)
 	}
 
+	// 按 --files 和仓库配置限制提交范围，范围外的修改移出暂存区
+	var skippedFiles []string
+	if len(options.Files) > 0 || options.Config != nil {
+		stagedFiles, err := ep.gitService.GetModifiedFiles(repoPath)
+		if err != nil {
+			return "", fmt.Errorf("获取修改文件列表失败: %v", err)
+		}
+
+		for _, file := range stagedFiles {
+			if !options.allowsPath(file) {
+				skippedFiles = append(skippedFiles, file)
+			}
+		}
+
+		if len(skippedFiles) > 0 {
+			log.Printf("以下文件不在修改范围内，不会提交: %v", skippedFiles)
+			if err := ep.gitService.UnstageFiles(repoPath, skippedFiles); err != nil {
+				return "", fmt.Errorf("移出暂存区失败: %v", err)
+			}
+		}
+
+		if len(skippedFiles) > 0 && len(skippedFiles) == len(stagedFiles) {
+			return "", fmt.Errorf("所有文件修改都不在允许的修改范围内")
+		}
+	}
+
 	// 检查是否有修改
 	// hasChanges, err := ep.gitService.HasChanges(repoPath)
 
@@ -1305,38 +2228,77 @@ func (ep *EventProcessor) commitAndPushChanges(repoPath string, event *models.Gi
 
 	// 使用CommitBuilder构建规范化的commit消息
 	commitBuilder := NewCommitBuilder()
+	if options.Config != nil {
+		commitBuilder = NewCommitBuilderWithScopes(options.Config.CommitScopes)
+	}
 	commitMessage := commitBuilder.BuildAutoFixCommit(event, modifiedFiles)
+	if options.commitMessage != nil {
+		commitMessage = options.commitMessage(commitBuilder, modifiedFiles)
+	}
 
 	if err := ep.gitService.Commit(repoPath, commitMessage); err != nil {
 		return "", fmt.Errorf("提交代码失败: %v", err)
 	}
+	progress.Done(fmt.Sprintf("%d 个文件", len(modifiedFiles)))
 
 	// 推送到远程仓库
 	log.Printf("推送分支: %s", branchName)
-	if err := ep.gitService.Push(repoPath, branchName); err != nil {
+	progress.Begin(StagePush)
+	pullRequestURL := options.pullRequestURL
+	if err := ep.gitService.Push(jobCtx, repoPath, branchName); err != nil {
 		log.Printf("推送失败，错误信息: %v", err)
-		return "", fmt.Errorf("推送代码失败: %v", err)
+		if options.fallbackBranch == "" || errors.Is(err, ErrJobCanceled) {
+			return "", fmt.Errorf("推送代码失败: %v", err)
+		}
+
+		// 没有原分支的推送权限（如受保护分支），推送到新分支并创建以原分支为目标的PR
+		log.Printf("无法推送到 %s，改为推送到 %s", branchName, options.fallbackBranch)
+		if err := ep.gitService.CreateBranch(repoPath, options.fallbackBranch); err != nil {
+			return "", fmt.Errorf("创建分支失败: %v", err)
+		}
+		if err := ep.gitService.Push(jobCtx, repoPath, options.fallbackBranch); err != nil {
+			return "", fmt.Errorf("推送代码失败: %v", err)
+		}
+		branchName, sourceBranch = options.fallbackBranch, branchName
+		pullRequestURL = ""
 	}
+	progress.Done(branchName)
 
 	log.Printf("推送成功: %s", branchName)
 
-	// 创建Pull Request
-	prResult, err := ep.createPullRequest(event, branchName, sourceBranch)
+	// 创建Pull Request，分支已有PR时推送即更新PR
+	progress.Begin(StagePR)
+	if pullRequestURL != "" {
+		progress.Done("已更新")
+		result := fmt.Sprintf("✅ 代码修改已成功提交并推送到分支: %s\n🔗 已更新Pull Request: %s", branchName, pullRequestURL)
+		if len(skippedFiles) > 0 {
+			result += fmt.Sprintf("\n⚠️ 以下文件不在修改范围内，未提交: %s", strings.Join(skippedFiles, ", "))
+		}
+		return result, nil
+	}
+
+	prResult, err := ep.createPullRequest(event, branchName, sourceBranch, options)
 	if err != nil {
 		log.Printf("创建PR失败: %v", err)
 		// PR创建失败不应该影响整个流程
+		progress.Fail(err)
+	} else {
+		progress.Done("")
 	}
 
 	result := fmt.Sprintf("✅ 代码修改已成功提交并推送到分支: %s", branchName)
 	if prResult != "" {
 		result += "\n" + prResult
 	}
+	if len(skippedFiles) > 0 {
+		result += fmt.Sprintf("\n⚠️ 以下文件不在修改范围内，未提交: %s", strings.Join(skippedFiles, ", "))
+	}
 
 	return result, nil
 }
 
 // createPullRequest 创建Pull Request
-func (ep *EventProcessor) createPullRequest(event *models.GitHubEvent, branchName, targetBranch string) (string, error) {
+func (ep *EventProcessor) createPullRequest(event *models.GitHubEvent, branchName, targetBranch string, options *ModifyOptions) (string, error) {
 	// 使用CommitBuilder构建规范化的PR标题
 	commitBuilder := NewCommitBuilder()
 	title := commitBuilder.BuildPRCommit(event.Issue.Title, event.Issue.Body, event.Issue.Number)
@@ -1358,6 +2320,17 @@ func (ep *EventProcessor) createPullRequest(event *models.GitHubEvent, branchNam
 ---
 *此PR由GitHub Webhook AI助手自动创建*`, event.Issue.Number, event.Issue.Number)
 
+	// 仓库配置了PR模板时使用模板
+	if template := options.Config.PRBody(event.Issue.Number, event.Issue.Title, branchName, event.Sender.Login); template != "" {
+		body = template
+	}
+	if options.prTitle != "" {
+		title = options.prTitle
+	}
+	if options.prBody != "" {
+		body = options.prBody
+	}
+
 	pr, err := ep.githubService.CreatePullRequest(
 		event.Repository.Owner.Login,
 		event.Repository.Name,
@@ -1365,6 +2338,7 @@ func (ep *EventProcessor) createPullRequest(event *models.GitHubEvent, branchNam
 		body,
 		branchName,
 		targetBranch, // 动态目标分支
+		options.Draft,
 	)
 
 	if err != nil {
@@ -1381,5 +2355,17 @@ func (ep *EventProcessor) createPullRequest(event *models.GitHubEvent, branchNam
 		return "", err
 	}
 
-	return fmt.Sprintf("🔗 已创建Pull Request: %s", pr.HTMLURL), nil
+	result := fmt.Sprintf("🔗 已创建Pull Request: %s", pr.HTMLURL)
+
+	// 按仓库配置请求审查者，失败不影响PR创建结果
+	if users, teams := options.Config.SplitReviewers(); len(users) > 0 || len(teams) > 0 {
+		if err := ep.githubService.RequestReviewers(event.Repository.Owner.Login, event.Repository.Name, pr.Number, users, teams); err != nil {
+			log.Printf("请求审查者失败: %v", err)
+			result += "\n⚠️ 请求审查者失败，请手动指定"
+		} else {
+			result += fmt.Sprintf("\n👀 已请求审查: %s", strings.Join(options.Config.Reviewers, ", "))
+		}
+	}
+
+	return result, nil
 }
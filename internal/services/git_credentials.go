@@ -0,0 +1,167 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// git需要认证时通过 GIT_ASKPASS 调用本程序获取用户名和token。token只通过git子进程的环境变量传递，
+// 不会写入 .git/config、命令行参数或日志；每次执行git命令时重新获取token，支持短期有效的token
+const (
+	askpassEnv      = "CODEAGENT_GIT_ASKPASS" // 设置时程序作为askpass运行
+	askpassTokenEnv = "CODEAGENT_GIT_TOKEN"   // 提供给git的token
+	askpassHostEnv  = "CODEAGENT_GIT_HOST"    // 只向该主机提供token
+	askpassUsername = "x-access-token"        // 个人token和GitHub App安装token都接受该用户名
+)
+
+// RunAskpass 程序被git作为 GIT_ASKPASS 调用时输出凭据并返回true，否则返回false
+// 应在main的最开始调用，此时不加载配置也不输出日志
+func RunAskpass(args []string) bool {
+	if os.Getenv(askpassEnv) != "1" {
+		return false
+	}
+
+	prompt := ""
+	if len(args) > 1 {
+		prompt = args[1]
+	}
+	fmt.Println(askpassAnswer(prompt, os.Getenv(askpassHostEnv), os.Getenv(askpassTokenEnv)))
+	return true
+}
+
+// askpassPromptPattern git的凭据提示，如 "Password for 'https://x-access-token@github.com': "
+var askpassPromptPattern = regexp.MustCompile(`^(Username|Password) for '[a-z]+://(?:[^@/']*@)?([^/']+)`)
+
+// askpassAnswer 根据git的提示返回用户名或token，提示中的主机不是host时返回空，避免token发送到其他主机
+func askpassAnswer(prompt, host, token string) string {
+	match := askpassPromptPattern.FindStringSubmatch(prompt)
+	if match == nil || token == "" || !strings.EqualFold(match[2], host) {
+		return ""
+	}
+	if match[1] == "Username" {
+		return askpassUsername
+	}
+	return token
+}
+
+var (
+	askpassOnce sync.Once
+	askpassExe  string
+)
+
+// askpassPath 当前程序的路径，用作 GIT_ASKPASS；获取失败时返回空
+func askpassPath() string {
+	askpassOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			log.Printf("获取程序路径失败，git操作将不使用token认证: %v", err)
+			return
+		}
+		askpassExe = exe
+	})
+	return askpassExe
+}
+
+// SetTokenSource 设置按仓库URL获取token的函数，每次执行git命令时调用，用于GitHub App等短期有效的token
+func (gs *GitService) SetTokenSource(source func(repoURL string) (string, error)) {
+	gs.tokenSource = source
+}
+
+// SetCredentialHost 设置接受token的git主机，替换之前的所有主机，默认为 github.com
+func (gs *GitService) SetCredentialHost(host string) {
+	gs.credentialMutex.Lock()
+	defer gs.credentialMutex.Unlock()
+	gs.credentialHosts = map[string]bool{strings.ToLower(host): true}
+}
+
+// AddCredentialHost 增加接受token的git主机，如webhook中GitHub Enterprise Server仓库的主机
+func (gs *GitService) AddCredentialHost(host string) {
+	if host == "" {
+		return
+	}
+
+	gs.credentialMutex.Lock()
+	defer gs.credentialMutex.Unlock()
+	gs.credentialHosts[strings.ToLower(host)] = true
+}
+
+// credentialHost repoURL的主机接受token时返回该主机，否则返回空
+func (gs *GitService) credentialHost(repoURL string) string {
+	host := strings.ToLower(urlHost(repoURL))
+	if host == "" {
+		return ""
+	}
+
+	gs.credentialMutex.Lock()
+	defer gs.credentialMutex.Unlock()
+	if !gs.credentialHosts[host] {
+		return ""
+	}
+	return host
+}
+
+// token 获取访问仓库的token，没有配置token时返回空
+func (gs *GitService) token(repoURL string) string {
+	if gs.tokenSource == nil {
+		return gs.githubToken
+	}
+	if repoURL == "" {
+		return ""
+	}
+
+	token, err := gs.tokenSource(repoURL)
+	if err != nil {
+		log.Printf("获取GitHub token失败: %v", err)
+		return ""
+	}
+	return token
+}
+
+// remoteURL 本地仓库对应的远程仓库URL，用于确定使用哪个token；未知的仓库返回空
+func (gs *GitService) remoteURL(repoPath string) string {
+	gs.mirrorsMutex.Lock()
+	defer gs.mirrorsMutex.Unlock()
+
+	if mirror, exists := gs.worktrees[repoPath]; exists {
+		return mirror.url
+	}
+	for _, mirror := range gs.mirrors {
+		if mirror.path == repoPath {
+			return mirror.url
+		}
+	}
+	return ""
+}
+
+// gitEnv 访问repoURL的git子进程的环境变量：禁止交互式提示，repoURL的主机接受token时通过askpass按需提供token，
+// 并停用全局配置的凭据助手，避免token被保存到磁盘；extra为额外的环境变量
+func (gs *GitService) gitEnv(repoURL string, extra ...string) []string {
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=credential.helper",
+		"GIT_CONFIG_VALUE_0=")
+
+	if host := gs.credentialHost(repoURL); host != "" && askpassPath() != "" {
+		if token := gs.token(repoURL); token != "" {
+			env = append(env,
+				"GIT_ASKPASS="+askpassPath(),
+				askpassEnv+"=1",
+				askpassTokenEnv+"="+token,
+				askpassHostEnv+"="+host)
+		}
+	}
+	return append(env, extra...)
+}
+
+// credentialsPattern URL中的认证信息
+var credentialsPattern = regexp.MustCompile(`(://)[^/@\s]+@`)
+
+// maskURL 遮盖URL中的认证信息用于日志显示，同样适用于包含URL的git输出
+func maskURL(url string) string {
+	return credentialsPattern.ReplaceAllString(url, "${1}***@")
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// mirrorCreateTimeout 首次创建镜像（完整克隆）的超时
+	mirrorCreateTimeout = 10 * time.Minute
+	// mirrorFetchTimeout 增量更新镜像的超时
+	mirrorFetchTimeout = 2 * time.Minute
+	// staleWorktreeAge 超过该时间未变化的工作树视为异常退出后遗留的，创建新工作树时清理
+	staleWorktreeAge = 24 * time.Hour
+)
+
+// repoMirror 仓库的本地bare镜像，长期保留并增量更新；每个任务在镜像上创建独立的工作树
+// 远程分支保存在 refs/remotes/origin/*，refs/heads/* 只用于任务创建的本地分支
+type repoMirror struct {
+	mu        sync.Mutex // 串行化同一镜像的fetch和工作树操作
+	url       string
+	path      string
+	lastFetch time.Time
+}
+
+// mirrorNamePattern 镜像目录名中不允许的字符
+var mirrorNamePattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// mirrorFor 返回仓库对应的镜像，每个仓库URL只有一个镜像
+func (gs *GitService) mirrorFor(repoURL string) *repoMirror {
+	gs.mirrorsMutex.Lock()
+	defer gs.mirrorsMutex.Unlock()
+
+	if mirror, exists := gs.mirrors[repoURL]; exists {
+		return mirror
+	}
+
+	// 目录名使用仓库名加URL哈希，不同URL的同名仓库不会冲突
+	name := strings.TrimSuffix(path.Base(strings.TrimRight(repoURL, "/")), ".git")
+	hash := md5.Sum([]byte(repoURL))
+	mirror := &repoMirror{
+		url:  repoURL,
+		path: filepath.Join(gs.workDir, "mirrors", fmt.Sprintf("%s-%x.git", mirrorNamePattern.ReplaceAllString(name, "_"), hash[:4])),
+	}
+	gs.mirrors[repoURL] = mirror
+	return mirror
+}
+
+// updateMirror 创建或增量更新镜像，调用方需持有mirror.mu
+func (gs *GitService) updateMirror(parent context.Context, mirror *repoMirror) error {
+	created := false
+	if out, err := gs.runGit(parent, mirror.path, "rev-parse", "--is-bare-repository"); err != nil || strings.TrimSpace(out) != "true" {
+		log.Printf("创建仓库镜像: %s -> %s", maskURL(mirror.url), mirror.path)
+		os.RemoveAll(mirror.path)
+		if err := os.MkdirAll(mirror.path, 0755); err != nil {
+			return fmt.Errorf("创建镜像目录失败: %v", err)
+		}
+
+		steps := [][]string{
+			{"init", "--bare", "-q"},
+			{"remote", "add", "origin", mirror.url},
+			{"config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"},
+		}
+		for _, args := range steps {
+			if _, err := gs.runGit(parent, mirror.path, args...); err != nil {
+				os.RemoveAll(mirror.path)
+				return fmt.Errorf("初始化镜像失败: %v", err)
+			}
+		}
+		created = true
+	}
+
+	// 每次更新时重新设置远程URL，旧版本写入配置的带token的URL也会被替换；认证由askpass提供
+	if _, err := gs.runGit(parent, mirror.path, "remote", "set-url", "origin", mirror.url); err != nil {
+		return fmt.Errorf("设置镜像远程URL失败: %v", err)
+	}
+
+	timeout := mirrorFetchTimeout
+	if created {
+		timeout = mirrorCreateTimeout
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	startedAt := time.Now()
+	if _, err := gs.runGit(ctx, mirror.path, "fetch", "-q", "--prune", "origin"); err != nil {
+		if created {
+			os.RemoveAll(mirror.path)
+		}
+		if canceled := canceledError(parent); canceled != nil {
+			log.Printf("更新镜像已取消: %s", maskURL(mirror.url))
+			return canceled
+		}
+		return fmt.Errorf("更新仓库镜像失败: %v", err)
+	}
+
+	mirror.lastFetch = time.Now()
+	log.Printf("仓库镜像已更新: %s，耗时 %v", mirror.path, time.Since(startedAt).Round(time.Millisecond))
+	return nil
+}
+
+// addWorktree 在镜像上为任务创建分离HEAD的工作树，多个任务可以同时检出同一分支
+func (gs *GitService) addWorktree(ctx context.Context, mirror *repoMirror, branch string) (string, error) {
+	ref := "refs/remotes/origin/" + branch
+	if !gs.hasCommit(ctx, mirror.path, ref) {
+		return "", fmt.Errorf("分支 %s 不存在", branch)
+	}
+
+	worktreesDir := filepath.Join(gs.workDir, "worktrees")
+	if err := os.MkdirAll(worktreesDir, 0755); err != nil {
+		return "", fmt.Errorf("创建工作树目录失败: %v", err)
+	}
+	repoPath, err := os.MkdirTemp(worktreesDir, fmt.Sprintf("repo_%s_", time.Now().Format("20060102_150405")))
+	if err != nil {
+		return "", fmt.Errorf("创建工作树目录失败: %v", err)
+	}
+
+	gs.pruneWorktrees(ctx, mirror)
+	if _, err := gs.runGit(ctx, mirror.path, "worktree", "add", "-q", "--detach", repoPath, ref); err != nil {
+		os.RemoveAll(repoPath)
+		if canceled := canceledError(ctx); canceled != nil {
+			return "", canceled
+		}
+		return "", fmt.Errorf("创建工作树失败: %v", err)
+	}
+
+	gs.mirrorsMutex.Lock()
+	gs.worktrees[repoPath] = mirror
+	gs.mirrorsMutex.Unlock()
+	return repoPath, nil
+}
+
+// removeWorktree 移除任务的工作树，并删除不再被任何工作树检出的本地分支
+func (gs *GitService) removeWorktree(mirror *repoMirror, repoPath string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	mirror.mu.Lock()
+	defer mirror.mu.Unlock()
+
+	if _, err := gs.runGit(ctx, mirror.path, "worktree", "remove", "--force", repoPath); err != nil {
+		log.Printf("移除工作树失败，直接删除目录: %v", err)
+		os.RemoveAll(repoPath)
+		gs.runGit(ctx, mirror.path, "worktree", "prune")
+	}
+
+	// 任务创建的分支都已推送或不再需要；其他工作树正在检出的分支git会拒绝删除
+	output, err := gs.runGit(ctx, mirror.path, "for-each-ref", "--format=%(refname:short)", "refs/heads/")
+	if err != nil {
+		log.Printf("列出本地分支失败: %v", err)
+		return
+	}
+	for _, branch := range strings.Fields(output) {
+		gs.runGit(ctx, mirror.path, "branch", "-q", "-D", branch)
+	}
+}
+
+// pruneWorktrees 清理目录已不存在的工作树记录，以及服务异常退出后遗留的工作树，调用方需持有mirror.mu
+func (gs *GitService) pruneWorktrees(ctx context.Context, mirror *repoMirror) {
+	gs.runGit(ctx, mirror.path, "worktree", "prune")
+
+	output, err := gs.runGit(ctx, mirror.path, "worktree", "list", "--porcelain")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(output, "\n") {
+		worktree := strings.TrimPrefix(line, "worktree ")
+		if worktree == line || worktree == mirror.path {
+			continue
+		}
+
+		gs.mirrorsMutex.Lock()
+		_, active := gs.worktrees[worktree]
+		gs.mirrorsMutex.Unlock()
+		if active {
+			continue
+		}
+
+		if info, err := os.Stat(worktree); err == nil && time.Since(info.ModTime()) > staleWorktreeAge {
+			log.Printf("清理遗留的工作树: %s", worktree)
+			gs.runGit(ctx, mirror.path, "worktree", "remove", "--force", worktree)
+		}
+	}
+}
+
+// isShallow 检查仓库是否为浅克隆，镜像的工作树包含完整历史
+func (gs *GitService) isShallow(ctx context.Context, repoPath string) bool {
+	output, err := gs.runGit(ctx, repoPath, "rev-parse", "--is-shallow-repository")
+	return err == nil && strings.TrimSpace(output) == "true"
+}
+
+// depthArgs 浅克隆的仓库fetch时限制深度；完整历史的仓库不能加 --depth，否则会变成浅克隆
+func (gs *GitService) depthArgs(ctx context.Context, repoPath string, depth int) []string {
+	if !gs.isShallow(ctx, repoPath) {
+		return nil
+	}
+	return []string{"--depth", fmt.Sprintf("%d", depth)}
+}
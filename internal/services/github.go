@@ -7,14 +7,40 @@ import (
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/webhook-demo/internal/models"
 )
 
+// defaultPermissionCacheTTL 协作者权限缓存的默认有效期
+const defaultPermissionCacheTTL = 5 * time.Minute
+
 // GitHubService GitHub API服务
 type GitHubService struct {
 	token   string
+	app     *GitHubApp // 使用GitHub App认证时按仓库所属的安装获取token，为nil时使用token
 	client  *http.Client
-	baseURL string
+	offline io.Writer // 离线模式下写操作输出到这里，不调用GitHub API
+
+	endpoints       GitHubEndpoints            // 配置的GitHub实例
+	enterpriseHosts map[string]bool            // 允许webhook中的仓库使用的其他GitHub实例主机（小写）
+	repoEndpoints   map[string]GitHubEndpoints // key: owner/repo（小写），来自webhook的其他GitHub实例的仓库
+	endpointsMutex  sync.Mutex
+
+	permissionTTL   time.Duration
+	permissions     map[string]cachedPermission // key: owner/repo/username
+	permissionMutex sync.Mutex
+}
+
+// cachedPermission 缓存的协作者权限
+type cachedPermission struct {
+	role      string
+	expiresAt time.Time
 }
 
 // NewGitHubService 创建新的GitHub服务
@@ -24,13 +50,100 @@ func NewGitHubService(token string) *GitHubService {
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		baseURL: "https://api.github.com",
+		endpoints:       DefaultGitHubEndpoints(),
+		enterpriseHosts: make(map[string]bool),
+		repoEndpoints:   make(map[string]GitHubEndpoints),
+		permissionTTL:   defaultPermissionCacheTTL,
+		permissions:     make(map[string]cachedPermission),
+	}
+}
+
+// SetPermissionCacheTTL 设置协作者权限缓存的有效期，0表示不缓存
+func (s *GitHubService) SetPermissionCacheTTL(ttl time.Duration) {
+	s.permissionMutex.Lock()
+	defer s.permissionMutex.Unlock()
+
+	s.permissionTTL = ttl
+	s.permissions = make(map[string]cachedPermission)
+}
+
+// SetEndpoints 设置配置的GitHub实例的访问地址，默认为 github.com
+func (s *GitHubService) SetEndpoints(endpoints GitHubEndpoints) {
+	s.endpointsMutex.Lock()
+	defer s.endpointsMutex.Unlock()
+	s.endpoints = endpoints
+}
+
+// SetEnterpriseHosts 设置允许webhook中的仓库使用的其他GitHub实例主机（可带端口），替换之前的设置
+// 推导出的地址会收到token，不在其中的主机一律使用配置的地址，避免伪造的payload把token发送到任意主机
+func (s *GitHubService) SetEnterpriseHosts(hosts []string) {
+	s.endpointsMutex.Lock()
+	defer s.endpointsMutex.Unlock()
+
+	s.enterpriseHosts = make(map[string]bool)
+	for _, host := range hosts {
+		s.enterpriseHosts[strings.ToLower(host)] = true
+	}
+}
+
+// RememberRepository 根据webhook payload中的 repository.html_url 记录仓库所在GitHub实例的访问地址，返回仓库使用的访问地址
+// 仓库在配置的实例上时使用配置的地址，在 SetEnterpriseHosts 允许的主机上（如GHES）时使用推导出的地址；
+// htmlURL无法解析或主机不被允许时使用配置的地址
+func (s *GitHubService) RememberRepository(owner, repo, htmlURL string) GitHubEndpoints {
+	s.endpointsMutex.Lock()
+	defer s.endpointsMutex.Unlock()
+
+	key := strings.ToLower(owner + "/" + repo)
+	derived, ok := EndpointsFromHTMLURL(htmlURL)
+	if !ok || strings.EqualFold(derived.GitHost, s.endpoints.GitHost) {
+		delete(s.repoEndpoints, key)
+		return s.endpoints
+	}
+	if !s.enterpriseHosts[strings.ToLower(derived.GitHost)] {
+		log.Printf("仓库 %s/%s 所在的主机 %s 不在 GITHUB_ENTERPRISE_HOSTS 中，使用配置的GitHub地址", owner, repo, derived.GitHost)
+		delete(s.repoEndpoints, key)
+		return s.endpoints
 	}
+	s.repoEndpoints[key] = derived
+	return derived
+}
+
+// Endpoints 仓库所在GitHub实例的访问地址
+func (s *GitHubService) Endpoints(owner, repo string) GitHubEndpoints {
+	s.endpointsMutex.Lock()
+	defer s.endpointsMutex.Unlock()
+
+	if endpoints, exists := s.repoEndpoints[strings.ToLower(owner+"/"+repo)]; exists {
+		return endpoints
+	}
+	return s.endpoints
+}
+
+// apiURL 仓库所在GitHub实例的API地址
+func (s *GitHubService) apiURL(owner, repo string) string {
+	return s.Endpoints(owner, repo).APIURL
+}
+
+// UseApp 使用GitHub App认证，之后的API调用使用仓库所属安装的访问token
+func (s *GitHubService) UseApp(app *GitHubApp) {
+	s.app = app
+}
+
+// App 返回使用的GitHub App，使用个人token时返回nil
+func (s *GitHubService) App() *GitHubApp {
+	return s.app
+}
+
+// NewOfflineGitHubService 创建离线模式的GitHub服务，写操作只输出到w，读操作返回错误
+func NewOfflineGitHubService(w io.Writer) *GitHubService {
+	service := NewGitHubService("")
+	service.offline = w
+	return service
 }
 
 // CreateComment 在Issue或PR上创建评论
 func (s *GitHubService) CreateComment(owner, repo string, issueNumber int, body string) error {
-	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", s.baseURL, owner, repo, issueNumber)
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", s.apiURL(owner, repo), owner, repo, issueNumber)
 
 	payload := map[string]string{
 		"body": body,
@@ -39,9 +152,24 @@ func (s *GitHubService) CreateComment(owner, repo string, issueNumber int, body
 	return s.makeRequest("POST", url, payload, nil)
 }
 
+// CreateCommentWithResponse 在Issue或PR上创建评论并返回评论信息，用于之后更新该评论
+func (s *GitHubService) CreateCommentWithResponse(owner, repo string, issueNumber int, body string) (*CommentResponse, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", s.apiURL(owner, repo), owner, repo, issueNumber)
+
+	payload := map[string]string{
+		"body": body,
+	}
+
+	var comment CommentResponse
+	if err := s.makeRequest("POST", url, payload, &comment); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
 // UpdateComment 更新评论
 func (s *GitHubService) UpdateComment(owner, repo string, commentID int64, body string) error {
-	url := fmt.Sprintf("%s/repos/%s/%s/issues/comments/%d", s.baseURL, owner, repo, commentID)
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/comments/%d", s.apiURL(owner, repo), owner, repo, commentID)
 
 	payload := map[string]string{
 		"body": body,
@@ -50,15 +178,16 @@ func (s *GitHubService) UpdateComment(owner, repo string, commentID int64, body
 	return s.makeRequest("PATCH", url, payload, nil)
 }
 
-// CreatePullRequest 创建Pull Request
-func (s *GitHubService) CreatePullRequest(owner, repo, title, body, head, base string) (*PullRequestResponse, error) {
-	url := fmt.Sprintf("%s/repos/%s/%s/pulls", s.baseURL, owner, repo)
+// CreatePullRequest 创建Pull Request，draft为true时创建草稿PR
+func (s *GitHubService) CreatePullRequest(owner, repo, title, body, head, base string, draft bool) (*PullRequestResponse, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls", s.apiURL(owner, repo), owner, repo)
 
-	payload := map[string]string{
+	payload := map[string]interface{}{
 		"title": title,
 		"body":  body,
 		"head":  head,
 		"base":  base,
+		"draft": draft,
 	}
 
 	var response PullRequestResponse
@@ -70,9 +199,29 @@ func (s *GitHubService) CreatePullRequest(owner, repo, title, body, head, base s
 	return &response, nil
 }
 
+// RequestReviewers 为Pull Request请求审查者
+func (s *GitHubService) RequestReviewers(owner, repo string, number int, reviewers, teamReviewers []string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/requested_reviewers", s.apiURL(owner, repo), owner, repo, number)
+
+	// GitHub不接受null，空列表需要序列化为[]
+	if reviewers == nil {
+		reviewers = []string{}
+	}
+	if teamReviewers == nil {
+		teamReviewers = []string{}
+	}
+
+	payload := map[string][]string{
+		"reviewers":      reviewers,
+		"team_reviewers": teamReviewers,
+	}
+
+	return s.makeRequest("POST", url, payload, nil)
+}
+
 // UpdatePullRequest 更新Pull Request
 func (s *GitHubService) UpdatePullRequest(owner, repo string, number int, title, body string) error {
-	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", s.baseURL, owner, repo, number)
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", s.apiURL(owner, repo), owner, repo, number)
 
 	payload := map[string]string{
 		"title": title,
@@ -84,7 +233,7 @@ func (s *GitHubService) UpdatePullRequest(owner, repo string, number int, title,
 
 // GetIssue 获取Issue信息
 func (s *GitHubService) GetIssue(owner, repo string, issueNumber int) (*IssueResponse, error) {
-	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d", s.baseURL, owner, repo, issueNumber)
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d", s.apiURL(owner, repo), owner, repo, issueNumber)
 
 	var response IssueResponse
 	err := s.makeRequest("GET", url, nil, &response)
@@ -95,11 +244,11 @@ func (s *GitHubService) GetIssue(owner, repo string, issueNumber int) (*IssueRes
 	return &response, nil
 }
 
-// GetPullRequest 获取Pull Request信息
-func (s *GitHubService) GetPullRequest(owner, repo string, number int) (*PullRequestResponse, error) {
-	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", s.baseURL, owner, repo, number)
+// GetPullRequest 获取Pull Request信息，包含head/base分支及所在仓库
+func (s *GitHubService) GetPullRequest(owner, repo string, number int) (*models.PullRequest, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", s.apiURL(owner, repo), owner, repo, number)
 
-	var response PullRequestResponse
+	var response models.PullRequest
 	err := s.makeRequest("GET", url, nil, &response)
 	if err != nil {
 		return nil, err
@@ -108,9 +257,93 @@ func (s *GitHubService) GetPullRequest(owner, repo string, number int) (*PullReq
 	return &response, nil
 }
 
+// CreatePullRequestReview 提交Pull Request审查，comments为锚定到diff position的行内评论
+// event 为 COMMENT、REQUEST_CHANGES 或 APPROVE，commitID为审查针对的head提交
+func (s *GitHubService) CreatePullRequestReview(owner, repo string, number int, commitID, body, event string, comments []ReviewComment) (*ReviewResponse, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/reviews", s.apiURL(owner, repo), owner, repo, number)
+
+	// GitHub不接受null，没有行内评论时需要序列化为[]
+	if comments == nil {
+		comments = []ReviewComment{}
+	}
+
+	payload := map[string]interface{}{
+		"body":     body,
+		"event":    event,
+		"comments": comments,
+	}
+	if commitID != "" {
+		payload["commit_id"] = commitID
+	}
+
+	var response ReviewResponse
+	if err := s.makeRequest("POST", url, payload, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ListPullRequestReviews 获取Pull Request的所有审查
+func (s *GitHubService) ListPullRequestReviews(owner, repo string, number int) ([]ReviewResponse, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/reviews?per_page=100", s.apiURL(owner, repo), owner, repo, number)
+
+	var response []ReviewResponse
+	if err := s.makeRequest("GET", url, nil, &response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// UpdatePullRequestReview 更新已提交审查的正文
+func (s *GitHubService) UpdatePullRequestReview(owner, repo string, number int, reviewID int64, body string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/reviews/%d", s.apiURL(owner, repo), owner, repo, number, reviewID)
+
+	payload := map[string]string{
+		"body": body,
+	}
+	return s.makeRequest("PUT", url, payload, nil)
+}
+
+// DismissPullRequestReview 撤销要求修改的审查，使其不再阻止合并
+func (s *GitHubService) DismissPullRequestReview(owner, repo string, number int, reviewID int64, message string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d/reviews/%d/dismissals", s.apiURL(owner, repo), owner, repo, number, reviewID)
+
+	payload := map[string]string{
+		"message": message,
+		"event":   "DISMISS",
+	}
+	return s.makeRequest("PUT", url, payload, nil)
+}
+
+// FindPullRequestByHead 查找以branch为head分支的打开状态的Pull Request，不存在时返回nil
+func (s *GitHubService) FindPullRequestByHead(owner, repo, branch string) (*PullRequestResponse, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open&head=%s", s.apiURL(owner, repo), owner, repo,
+		neturl.QueryEscape(owner+":"+branch))
+
+	var response []PullRequestResponse
+	if err := s.makeRequest("GET", url, nil, &response); err != nil {
+		return nil, err
+	}
+	if len(response) == 0 {
+		return nil, nil
+	}
+	return &response[0], nil
+}
+
+// ListIssueComments 获取Issue或PR的评论，按创建时间排序，最多返回最早的100条
+func (s *GitHubService) ListIssueComments(owner, repo string, issueNumber int) ([]CommentResponse, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments?per_page=100", s.apiURL(owner, repo), owner, repo, issueNumber)
+
+	var response []CommentResponse
+	if err := s.makeRequest("GET", url, nil, &response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
 // GetRepository 获取仓库信息
 func (s *GitHubService) GetRepository(owner, repo string) (*RepositoryResponse, error) {
-	url := fmt.Sprintf("%s/repos/%s/%s", s.baseURL, owner, repo)
+	url := fmt.Sprintf("%s/repos/%s/%s", s.apiURL(owner, repo), owner, repo)
 
 	var response RepositoryResponse
 	err := s.makeRequest("GET", url, nil, &response)
@@ -121,8 +354,51 @@ func (s *GitHubService) GetRepository(owner, repo string) (*RepositoryResponse,
 	return &response, nil
 }
 
+// GetCollaboratorPermission 获取用户在仓库中的角色（admin/maintain/write/triage/read/none），结果按用户缓存
+func (s *GitHubService) GetCollaboratorPermission(owner, repo, username string) (string, error) {
+	// 离线模式下触发命令的是本地用户，视为管理员
+	if s.offline != nil {
+		return "admin", nil
+	}
+
+	key := owner + "/" + repo + "/" + username
+
+	s.permissionMutex.Lock()
+	cached, exists := s.permissions[key]
+	s.permissionMutex.Unlock()
+	if exists && time.Now().Before(cached.expiresAt) {
+		return cached.role, nil
+	}
+
+	url := fmt.Sprintf("%s/repos/%s/%s/collaborators/%s/permission", s.apiURL(owner, repo), owner, repo, username)
+
+	var response CollaboratorPermissionResponse
+	if err := s.makeRequest("GET", url, nil, &response); err != nil {
+		return "", err
+	}
+
+	// role_name 区分 maintain 和 triage，旧版本GitHub Enterprise只返回 permission；
+	// 自定义角色（如 security-reviewer）的 role_name 无法识别，使用 permission 中对应的基础权限
+	role := response.RoleName
+	if ParsePermission(role) == PermissionNone {
+		role = response.Permission
+	}
+
+	s.permissionMutex.Lock()
+	if s.permissionTTL > 0 {
+		s.permissions[key] = cachedPermission{role: role, expiresAt: time.Now().Add(s.permissionTTL)}
+	}
+	s.permissionMutex.Unlock()
+
+	return role, nil
+}
+
 // makeRequest 发起HTTP请求
 func (s *GitHubService) makeRequest(method, url string, payload interface{}, response interface{}) error {
+	if s.offline != nil {
+		return s.printRequest(method, url, payload)
+	}
+
 	var body io.Reader
 
 	if payload != nil {
@@ -146,8 +422,12 @@ func (s *GitHubService) makeRequest(method, url string, payload interface{}, res
 	}
 
 	// 设置认证
-	if s.token != "" {
-		req.Header.Set("Authorization", "token "+s.token)
+	token, err := s.requestToken(url)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "token "+token)
 	}
 
 	// 发起请求
@@ -179,6 +459,64 @@ func (s *GitHubService) makeRequest(method, url string, payload interface{}, res
 	return nil
 }
 
+// apiRepoPattern API URL中的仓库
+var apiRepoPattern = regexp.MustCompile(`/repos/([^/]+)/([^/?]+)`)
+
+// requestToken 请求使用的token，使用GitHub App时为请求所属仓库的安装token
+func (s *GitHubService) requestToken(url string) (string, error) {
+	if s.app == nil {
+		return s.token, nil
+	}
+
+	if !strings.HasPrefix(url, s.app.Endpoints().APIURL+"/") {
+		return "", fmt.Errorf("请求的地址不在GitHub App所在的实例: %s", url)
+	}
+	match := apiRepoPattern.FindStringSubmatch(url)
+	if match == nil {
+		return "", fmt.Errorf("无法确定请求所属的仓库: %s", url)
+	}
+	return s.app.Token(match[1], match[2])
+}
+
+// printRequest 离线模式下输出请求内容，字符串字段原样输出便于阅读Markdown
+func (s *GitHubService) printRequest(method, url string, payload interface{}) error {
+	if method == "GET" {
+		return fmt.Errorf("离线模式不支持查询GitHub API: %s", url)
+	}
+
+	fmt.Fprintf(s.offline, "\n===== GitHub API (离线模式) %s %s =====\n", method, url)
+
+	if payload != nil {
+		jsonPayload, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("序列化请求数据失败: %v", err)
+		}
+
+		var fields map[string]interface{}
+		if err := json.Unmarshal(jsonPayload, &fields); err != nil {
+			fmt.Fprintln(s.offline, string(jsonPayload))
+		} else {
+			keys := make([]string, 0, len(fields))
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				if value, ok := fields[key].(string); ok {
+					fmt.Fprintf(s.offline, "[%s]\n%s\n", key, value)
+					continue
+				}
+				value, _ := json.Marshal(fields[key])
+				fmt.Fprintf(s.offline, "[%s]\n%s\n", key, string(value))
+			}
+		}
+	}
+
+	fmt.Fprintln(s.offline, "=====")
+	return nil
+}
+
 // 响应结构体
 type PullRequestResponse struct {
 	ID       int64  `json:"id"`
@@ -189,6 +527,12 @@ type PullRequestResponse struct {
 	HTMLURL  string `json:"html_url"`
 	DiffURL  string `json:"diff_url"`
 	PatchURL string `json:"patch_url"`
+	Head     struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
 }
 
 type IssueResponse struct {
@@ -200,6 +544,35 @@ type IssueResponse struct {
 	HTMLURL string `json:"html_url"`
 }
 
+type CommentResponse struct {
+	ID      int64  `json:"id"`
+	Body    string `json:"body"`
+	HTMLURL string `json:"html_url"`
+	User    struct {
+		Login string `json:"login"`
+	} `json:"user"`
+}
+
+// ReviewComment PR审查的行内评论
+type ReviewComment struct {
+	Path     string `json:"path"`
+	Position int    `json:"position"`
+	Body     string `json:"body"`
+}
+
+type ReviewResponse struct {
+	ID       int64  `json:"id"`
+	State    string `json:"state"`
+	Body     string `json:"body"`
+	CommitID string `json:"commit_id"`
+	HTMLURL  string `json:"html_url"`
+}
+
+type CollaboratorPermissionResponse struct {
+	Permission string `json:"permission"`
+	RoleName   string `json:"role_name"`
+}
+
 type RepositoryResponse struct {
 	ID       int64  `json:"id"`
 	Name     string `json:"name"`
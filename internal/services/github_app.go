@@ -0,0 +1,274 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// appJWTLifetime App JWT的有效期，GitHub允许的最长时间为10分钟
+	appJWTLifetime = 9 * time.Minute
+	// installationTokenRefreshMargin 安装token在过期前多久刷新，保证正在执行的git命令和API调用不会遇到过期
+	installationTokenRefreshMargin = 5 * time.Minute
+)
+
+// GitHubApp GitHub App认证：用App私钥签发JWT，换取每个安装的访问token，缓存并在过期前刷新
+// 安装按账号（组织或用户）区分，安装ID来自webhook payload中的 installation.id，未知时通过API查询
+type GitHubApp struct {
+	appID      string
+	privateKey *rsa.PrivateKey
+	endpoints  GitHubEndpoints // App所在的GitHub实例
+	client     *http.Client
+
+	installations map[string]int64 // 账号（小写） -> 安装ID
+	tokens        map[int64]installationToken
+	identity      *appIdentity
+	mutex         sync.Mutex
+}
+
+// installationToken 缓存的安装访问token
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// appIdentity App的机器人身份，用于提交的作者信息
+type appIdentity struct {
+	name  string
+	email string
+}
+
+// NewGitHubApp 创建GitHub App认证，privateKeyPEM为App的私钥（PKCS#1或PKCS#8格式）
+func NewGitHubApp(appID string, privateKeyPEM []byte) (*GitHubApp, error) {
+	if appID == "" {
+		return nil, fmt.Errorf("GitHub App ID不能为空")
+	}
+
+	block, _ := pem.Decode(privateKeyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("解析GitHub App私钥失败: 不是PEM格式")
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		key, pkcs8Err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if pkcs8Err != nil {
+			return nil, fmt.Errorf("解析GitHub App私钥失败: %v", err)
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("解析GitHub App私钥失败: 不是RSA私钥")
+		}
+		privateKey = rsaKey
+	}
+
+	return &GitHubApp{
+		appID:      appID,
+		privateKey: privateKey,
+		endpoints:  DefaultGitHubEndpoints(),
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		installations: make(map[string]int64),
+		tokens:        make(map[int64]installationToken),
+	}, nil
+}
+
+// SetEndpoints 设置App所在GitHub实例的访问地址，默认为 github.com
+func (a *GitHubApp) SetEndpoints(endpoints GitHubEndpoints) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.endpoints = endpoints
+}
+
+// Endpoints App所在GitHub实例的访问地址
+func (a *GitHubApp) Endpoints() GitHubEndpoints {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.endpoints
+}
+
+// JWT 签发App的JWT（RS256），用于调用 /app 下的API和换取安装token
+func (a *GitHubApp) JWT() (string, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-60 * time.Second).Unix(), // 容忍与GitHub服务器的时钟偏差
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": a.appID,
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("签发GitHub App JWT失败: %v", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// RememberInstallation 记录账号对应的安装ID，webhook payload中带有 installation.id 时调用
+func (a *GitHubApp) RememberInstallation(owner string, installationID int64) {
+	if owner == "" || installationID == 0 {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.installations[strings.ToLower(owner)] = installationID
+}
+
+// Token 返回仓库所属安装的访问token，缓存的token即将过期时重新换取
+func (a *GitHubApp) Token(owner, repo string) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	installationID, err := a.installationID(owner, repo)
+	if err != nil {
+		return "", err
+	}
+
+	if cached, exists := a.tokens[installationID]; exists && time.Until(cached.expiresAt) > installationTokenRefreshMargin {
+		return cached.token, nil
+	}
+
+	jwt, err := a.JWT()
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", a.endpoints.APIURL, installationID)
+	if err := a.request("POST", url, jwt, &response); err != nil {
+		return "", fmt.Errorf("获取安装 %d 的访问token失败: %v", installationID, err)
+	}
+
+	a.tokens[installationID] = installationToken{token: response.Token, expiresAt: response.ExpiresAt}
+	log.Printf("已获取GitHub App安装 %d 的访问token，过期时间: %s", installationID, response.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	return response.Token, nil
+}
+
+// repoURLPattern 仓库URL中的账号和仓库名
+var repoURLPattern = regexp.MustCompile(`^[a-z]+://[^/]+/([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+// TokenForURL 返回仓库URL对应安装的访问token，用作git的token来源
+func (a *GitHubApp) TokenForURL(repoURL string) (string, error) {
+	match := repoURLPattern.FindStringSubmatch(repoURL)
+	if match == nil {
+		return "", fmt.Errorf("无法从URL确定仓库: %s", maskURL(repoURL))
+	}
+	// 安装token只对App所在的实例有效，不提供给其他主机
+	if host := urlHost(repoURL); !strings.EqualFold(host, a.Endpoints().GitHost) {
+		return "", fmt.Errorf("仓库主机 %s 不是GitHub App所在的实例", host)
+	}
+	return a.Token(match[1], match[2])
+}
+
+// Identity 返回App机器人用于提交的名称和邮箱，如 my-app[bot] <123+my-app[bot]@users.noreply.github.com>
+func (a *GitHubApp) Identity() (string, string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.identity != nil {
+		return a.identity.name, a.identity.email, nil
+	}
+
+	jwt, err := a.JWT()
+	if err != nil {
+		return "", "", err
+	}
+
+	var app struct {
+		Slug string `json:"slug"`
+	}
+	if err := a.request("GET", a.endpoints.APIURL+"/app", jwt, &app); err != nil {
+		return "", "", fmt.Errorf("获取GitHub App信息失败: %v", err)
+	}
+
+	login := app.Slug + "[bot]"
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := a.request("GET", fmt.Sprintf("%s/users/%s", a.endpoints.APIURL, neturl.PathEscape(login)), "", &user); err != nil {
+		return "", "", fmt.Errorf("获取GitHub App机器人用户失败: %v", err)
+	}
+
+	a.identity = &appIdentity{
+		name:  login,
+		email: fmt.Sprintf("%d+%s@users.noreply.github.com", user.ID, login),
+	}
+	log.Printf("GitHub App机器人身份: %s <%s>", a.identity.name, a.identity.email)
+	return a.identity.name, a.identity.email, nil
+}
+
+// installationID 查找账号对应的安装ID，未记录时通过仓库查询，调用方需持有mutex
+func (a *GitHubApp) installationID(owner, repo string) (int64, error) {
+	if id, exists := a.installations[strings.ToLower(owner)]; exists {
+		return id, nil
+	}
+
+	jwt, err := a.JWT()
+	if err != nil {
+		return 0, err
+	}
+
+	var installation struct {
+		ID int64 `json:"id"`
+	}
+	url := fmt.Sprintf("%s/repos/%s/%s/installation", a.endpoints.APIURL, owner, repo)
+	if err := a.request("GET", url, jwt, &installation); err != nil {
+		return 0, fmt.Errorf("GitHub App未安装在仓库 %s/%s: %v", owner, repo, err)
+	}
+
+	a.installations[strings.ToLower(owner)] = installation.ID
+	return installation.ID, nil
+}
+
+// request 使用JWT调用GitHub API，jwt为空时不认证
+func (a *GitHubApp) request(method, url, jwt string, response interface{}) error {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %v", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "Webhook-Demo/1.0")
+	if jwt != "" {
+		req.Header.Set("Authorization", "Bearer "+jwt)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("GitHub API错误: %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	if err := json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("解析响应失败: %v", err)
+	}
+	return nil
+}
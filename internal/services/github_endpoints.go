@@ -0,0 +1,67 @@
+package services
+
+import (
+	neturl "net/url"
+	"strings"
+)
+
+// GitHubEndpoints GitHub实例的访问地址，github.com 或 GitHub Enterprise Server（GHES）
+type GitHubEndpoints struct {
+	APIURL  string // REST API地址，如 https://api.github.com、https://ghe.example.com/api/v3
+	GitHost string // git克隆和推送使用的主机（可带端口），如 github.com、ghe.example.com
+}
+
+// DefaultGitHubEndpoints github.com 的访问地址
+func DefaultGitHubEndpoints() GitHubEndpoints {
+	return GitHubEndpoints{
+		APIURL:  "https://api.github.com",
+		GitHost: "github.com",
+	}
+}
+
+// NewGitHubEndpoints 按配置创建访问地址，未配置的项使用默认值
+// 只配置gitHost时按GHES的约定推导API地址；只配置apiURL时git主机取apiURL的主机
+func NewGitHubEndpoints(apiURL, gitHost string) GitHubEndpoints {
+	endpoints := DefaultGitHubEndpoints()
+	if gitHost == "" && apiURL != "" {
+		if parsed, err := neturl.Parse(apiURL); err == nil && parsed.Host != "" && !strings.EqualFold(parsed.Host, "api.github.com") {
+			gitHost = parsed.Host
+		}
+	}
+	if gitHost != "" {
+		if derived, ok := EndpointsFromHTMLURL("https://" + gitHost); ok {
+			endpoints = derived
+		}
+	}
+	if apiURL != "" {
+		endpoints.APIURL = strings.TrimRight(apiURL, "/")
+	}
+	return endpoints
+}
+
+// EndpointsFromHTMLURL 根据仓库的网页地址（webhook payload中的 repository.html_url）推导访问地址，
+// github.com 之外的主机按GHES的约定使用 /api/v3；无法解析时返回false
+func EndpointsFromHTMLURL(htmlURL string) (GitHubEndpoints, bool) {
+	parsed, err := neturl.Parse(htmlURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return GitHubEndpoints{}, false
+	}
+	if strings.EqualFold(parsed.Host, "github.com") || strings.EqualFold(parsed.Host, "www.github.com") {
+		return DefaultGitHubEndpoints(), true
+	}
+
+	base := parsed.Scheme + "://" + parsed.Host
+	return GitHubEndpoints{
+		APIURL:  base + "/api/v3",
+		GitHost: parsed.Host,
+	}, true
+}
+
+// urlHost URL中的主机（可带端口），无法解析时返回空
+func urlHost(rawURL string) string {
+	parsed, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Host
+}
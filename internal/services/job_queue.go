@@ -0,0 +1,429 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/webhook-demo/internal/config"
+	"github.com/webhook-demo/internal/models"
+)
+
+// jobRetention 已结束任务记录的保留时间
+const jobRetention = 7 * 24 * time.Hour
+
+var (
+	// ErrQueueFull 队列已满
+	ErrQueueFull = errors.New("任务队列已满")
+	// ErrQueueClosed 队列已关闭
+	ErrQueueClosed = errors.New("任务队列已关闭")
+)
+
+// Job 后台任务
+type Job struct {
+	ID         string
+	Event      *models.GitHubEvent
+	EnqueuedAt time.Time
+	Record     *JobRecord
+
+	repo   string          // 任务所属的仓库，同一仓库的任务依次执行
+	ctx    *CommandContext // 任务关联的Issue/PR，用于显示排队位置
+	ticket *repoTicket     // 排队凭证，立即执行的控制命令为nil
+	notice *queueNotice    // 排队评论，未排队时为nil
+}
+
+// JobQueue 进程内异步任务队列，按仓库调度webhook事件在后台处理
+// 同一仓库的任务依次执行，不同仓库的任务并行执行，同时执行的任务不超过worker数量
+type JobQueue struct {
+	processor   *EventProcessor
+	store       *JobStore
+	scheduler   *RepoScheduler
+	size        int
+	maxAttempts int
+	wg          sync.WaitGroup
+	mutex       sync.RWMutex
+	closed      bool
+}
+
+// NewJobQueue 创建新的任务队列
+func NewJobQueue(processor *EventProcessor, store *JobStore, cfg *config.QueueConfig) *JobQueue {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	size := cfg.Size
+	if size <= 0 {
+		size = 100
+	}
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	return &JobQueue{
+		processor:   processor,
+		store:       store,
+		scheduler:   NewRepoScheduler(workers),
+		size:        size,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// Start 开始执行任务，之前恢复的任务按入队顺序执行
+func (q *JobQueue) Start() {
+	q.scheduler.Start()
+	log.Printf("任务队列已启动，最大并发数: %d, 队列容量: %d", q.scheduler.limit, q.size)
+}
+
+// Enqueue 将事件放入队列，队列已满或已关闭时返回错误
+func (q *JobQueue) Enqueue(event *models.GitHubEvent) (*Job, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if q.closed {
+		return nil, ErrQueueClosed
+	}
+
+	commands, commandCtx := q.processor.PeekCommands(event)
+	record := &JobRecord{
+		ID:        newJobID(),
+		Event:     event,
+		Commands:  commands,
+		Status:    JobStatusQueued,
+		CreatedAt: time.Now(),
+	}
+
+	job := &Job{
+		ID:         record.ID,
+		Event:      event,
+		EnqueuedAt: record.CreatedAt,
+		Record:     record,
+		ctx:        commandCtx,
+	}
+
+	// /cancel、/status 等控制命令不能排在长任务之后，直接执行
+	if q.processor.IsImmediate(commands) {
+		if err := q.store.Save(record); err != nil {
+			return nil, err
+		}
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			q.run(job)
+		}()
+		log.Printf("控制命令立即执行: JobID=%s, Commands=%s", job.ID, commandNames(commands))
+		return job, nil
+	}
+
+	if q.scheduler.Waiting() >= q.size {
+		return nil, ErrQueueFull
+	}
+
+	// 先持久化再入队，保证进程重启后可以恢复
+	if err := q.store.Save(record); err != nil {
+		return nil, err
+	}
+
+	q.track(record, commandCtx)
+	q.submit(job)
+	log.Printf("任务已入队: JobID=%s, Type=%s, DeliveryID=%s, Repo=%s, 排队任务: %d",
+		job.ID, event.Type, event.DeliveryID, job.repo, q.scheduler.Waiting())
+	return job, nil
+}
+
+// submit 按任务所属的仓库排队，在后台等待执行
+func (q *JobQueue) submit(job *Job) {
+	if job.ctx != nil {
+		job.repo = job.ctx.Repository.FullName
+	}
+	job.ticket = q.scheduler.Enqueue(job.repo)
+
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.run(job)
+	}()
+}
+
+// track 登记任务，使其可以通过 /status 查询、通过 /cancel 取消
+func (q *JobQueue) track(record *JobRecord, ctx *CommandContext) {
+	user := ""
+	if ctx != nil {
+		user = ctx.User.Login
+	}
+	q.processor.Jobs().Track(record.ID, jobKey(ctx), commandNames(record.Commands), user)
+}
+
+// Recover 恢复上次进程退出时未完成的任务，应在Start之前调用
+// 未超过最大尝试次数的任务重新入队，否则标记为失败，并在Issue/PR中说明情况
+func (q *JobQueue) Recover() error {
+	if err := q.store.Prune(jobRetention); err != nil {
+		log.Printf("清理过期任务记录失败: %v", err)
+	}
+
+	records, err := q.store.Unfinished()
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	log.Printf("发现 %d 个未完成的任务，开始恢复", len(records))
+
+	for _, record := range records {
+		interrupted := record.Status == JobStatusRunning
+
+		if record.Attempts >= q.maxAttempts {
+			log.Printf("任务已达最大尝试次数，标记为失败: JobID=%s, Attempts=%d", record.ID, record.Attempts)
+			q.finish(record, fmt.Errorf("服务重启导致任务中断，已达最大尝试次数(%d)", q.maxAttempts))
+			q.notifyRecovery(record, fmt.Sprintf("⚠️ **任务已中断**\n\n服务重启导致命令 `%s` 执行中断，已尝试 %d 次，不再自动重试。\n\n请重新发送命令以再次执行。",
+				commandNames(record.Commands), record.Attempts))
+			continue
+		}
+
+		record.Status = JobStatusQueued
+		if err := q.store.Save(record); err != nil {
+			log.Printf("更新任务记录失败: JobID=%s, %v", record.ID, err)
+		}
+
+		q.processor.RememberRepository(record.Event)
+		_, commandCtx := q.processor.PeekCommands(record.Event)
+		job := &Job{
+			ID:         record.ID,
+			Event:      record.Event,
+			EnqueuedAt: time.Now(),
+			Record:     record,
+			ctx:        commandCtx,
+		}
+
+		q.track(record, commandCtx)
+
+		if q.scheduler.Waiting() < q.size {
+			q.submit(job)
+			log.Printf("任务已恢复: JobID=%s, Attempts=%d", record.ID, record.Attempts)
+			if interrupted {
+				q.notifyRecovery(record, fmt.Sprintf("🔄 **任务已恢复**\n\n服务重启导致命令 `%s` 执行中断，已重新排队执行（第 %d 次尝试）。",
+					commandNames(record.Commands), record.Attempts+1))
+			} else {
+				q.notifyRecovery(record, fmt.Sprintf("🔄 **任务已恢复**\n\n服务重启前命令 `%s` 尚未开始执行，已重新排队。",
+					commandNames(record.Commands)))
+			}
+		} else {
+			q.processor.Jobs().Done(record.ID)
+			q.finish(record, fmt.Errorf("%v", ErrQueueFull))
+			q.notifyRecovery(record, fmt.Sprintf("⚠️ **任务已中断**\n\n服务重启后任务队列已满，命令 `%s` 未能恢复执行。\n\n请稍后重新发送命令。",
+				commandNames(record.Commands)))
+		}
+	}
+
+	return nil
+}
+
+// Shutdown 停止接收新任务并等待已入队的任务处理完成，ctx到期后直接返回
+func (q *JobQueue) Shutdown(ctx context.Context) error {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+
+	log.Printf("等待后台任务完成，执行中: %d, 剩余排队任务: %d", q.scheduler.Running(), q.scheduler.Waiting())
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("所有后台任务已完成")
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("等待后台任务超时，执行中: %d, 剩余排队任务: %d", q.scheduler.Running(), q.scheduler.Waiting())
+	}
+}
+
+// run 等待任务获得执行机会后执行，记录结果并捕获panic
+func (q *JobQueue) run(job *Job) {
+	jobs := q.processor.Jobs()
+	defer jobs.Done(job.ID)
+
+	if job.ticket != nil {
+		defer q.scheduler.Release(job.ticket)
+		err := q.scheduler.Wait(jobs.Context(job.ID), job.ticket, func(position int) {
+			q.notifyQueued(job, position)
+		})
+		if err != nil {
+			log.Printf("任务已在排队时取消，跳过: JobID=%s", job.ID)
+			q.updateNotice(job, fmt.Sprintf("🛑 **任务已取消**\n\n命令 `%s` 在排队时被取消，未执行。", commandNames(job.Record.Commands)))
+			q.finish(job.Record, err)
+			return
+		}
+	}
+
+	jobCtx := jobs.Start(job.ID)
+	if jobCtx.Err() != nil {
+		log.Printf("任务已在排队时取消，跳过: JobID=%s", job.ID)
+		q.finish(job.Record, ErrJobCanceled)
+		return
+	}
+
+	log.Printf("开始处理任务: JobID=%s, Repo=%s, 排队耗时: %v", job.ID, job.repo, time.Since(job.EnqueuedAt))
+	startTime := time.Now()
+
+	// 排队评论改为开始执行，并交给任务的第一个进度评论继续更新
+	if job.notice != nil {
+		q.updateNotice(job, fmt.Sprintf("▶️ **任务开始执行**\n\n命令 `%s` 排队 %s 后开始执行。",
+			commandNames(job.Record.Commands), formatElapsed(time.Since(job.EnqueuedAt))))
+		jobCtx = withQueueNotice(jobCtx, job.notice)
+	}
+
+	job.Record.Status = JobStatusRunning
+	job.Record.Attempts++
+	if err := q.store.Save(job.Record); err != nil {
+		log.Printf("更新任务记录失败: JobID=%s, %v", job.ID, err)
+	}
+
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("任务执行panic: JobID=%s, %v", job.ID, r)
+			err = fmt.Errorf("任务执行panic: %v", r)
+		}
+		// 命令处理器在进度评论中报告取消，不一定返回错误
+		if err == nil {
+			err = canceledError(jobCtx)
+		}
+		q.finish(job.Record, err)
+	}()
+
+	if err = q.processor.ProcessEvent(jobCtx, job.Event); err != nil {
+		log.Printf("任务处理失败: JobID=%s, %v", job.ID, err)
+		return
+	}
+
+	job.Record.Result = fmt.Sprintf("处理完成，耗时: %v", time.Since(startTime))
+	log.Printf("任务处理完成: JobID=%s, 耗时: %v", job.ID, time.Since(startTime))
+}
+
+// finish 记录任务的最终结果
+func (q *JobQueue) finish(record *JobRecord, err error) {
+	if errors.Is(err, ErrJobCanceled) {
+		record.Status = JobStatusCanceled
+		record.Error = err.Error()
+	} else if err != nil {
+		record.Status = JobStatusFailed
+		record.Error = err.Error()
+	} else {
+		record.Status = JobStatusSucceeded
+	}
+
+	if saveErr := q.store.Save(record); saveErr != nil {
+		log.Printf("保存任务结果失败: JobID=%s, %v", record.ID, saveErr)
+	}
+}
+
+// notifyRecovery 在任务关联的Issue/PR中说明恢复情况
+func (q *JobQueue) notifyRecovery(record *JobRecord, message string) {
+	if record.Event == nil {
+		return
+	}
+
+	_, ctx := q.processor.PeekCommands(record.Event)
+	if ctx == nil {
+		return
+	}
+
+	if err := q.processor.createResponse(ctx, message); err != nil {
+		log.Printf("发送任务恢复通知失败: JobID=%s, %v", record.ID, err)
+	}
+}
+
+// queueNotice 任务排队时在Issue/PR中发布的评论，任务开始后由第一个进度评论接管继续更新
+type queueNotice struct {
+	owner     string
+	repo      string
+	commentID int64
+	claimed   bool
+}
+
+// queueNoticeKey 排队评论在任务context中的键
+type queueNoticeKey struct{}
+
+// withQueueNotice 将排队评论放入任务的context
+func withQueueNotice(ctx context.Context, notice *queueNotice) context.Context {
+	return context.WithValue(ctx, queueNoticeKey{}, notice)
+}
+
+// claimQueueNotice 取出任务的排队评论ID，只有第一次调用返回评论ID，没有排队评论时返回0
+func claimQueueNotice(ctx context.Context) int64 {
+	if ctx == nil {
+		return 0
+	}
+	notice, ok := ctx.Value(queueNoticeKey{}).(*queueNotice)
+	if !ok || notice.claimed {
+		return 0
+	}
+	notice.claimed = true
+	return notice.commentID
+}
+
+// notifyQueued 在Issue/PR中显示任务的排队位置，首次排队时发布评论，之后原地更新
+// 没有命令的任务（如自动审查）不发布排队评论
+func (q *JobQueue) notifyQueued(job *Job, position int) {
+	log.Printf("任务排队中: JobID=%s, Repo=%s, 位置: %d", job.ID, job.repo, position)
+	if job.ctx == nil || len(job.Record.Commands) == 0 {
+		return
+	}
+
+	body := fmt.Sprintf("⬜ **任务排队中**\n\n命令 `%s` 当前排在第 %d 位，同一仓库的任务依次执行。\n\n使用 `/cancel` 取消排队中的任务。",
+		commandNames(job.Record.Commands), position)
+	if job.notice != nil {
+		q.updateNotice(job, body)
+		return
+	}
+
+	number := 0
+	if job.ctx.Issue != nil {
+		number = job.ctx.Issue.Number
+	} else if job.ctx.PullRequest != nil {
+		number = job.ctx.PullRequest.Number
+	}
+	if number == 0 {
+		return
+	}
+
+	notice := &queueNotice{owner: job.ctx.Repository.Owner.Login, repo: job.ctx.Repository.Name}
+	comment, err := q.processor.githubService.CreateCommentWithResponse(notice.owner, notice.repo, number, body)
+	if err != nil {
+		log.Printf("发布排队评论失败: JobID=%s, %v", job.ID, err)
+		return
+	}
+	notice.commentID = comment.ID
+	job.notice = notice
+}
+
+// updateNotice 更新任务的排队评论，没有排队评论时什么都不做
+func (q *JobQueue) updateNotice(job *Job, body string) {
+	if job.notice == nil {
+		return
+	}
+	if err := q.processor.githubService.UpdateComment(job.notice.owner, job.notice.repo, job.notice.commentID, body); err != nil {
+		log.Printf("更新排队评论失败: JobID=%s, %v", job.ID, err)
+	}
+}
+
+// newJobID 生成任务ID
+func newJobID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("job_%d", time.Now().UnixNano())
+	}
+	return "job_" + hex.EncodeToString(buf)
+}
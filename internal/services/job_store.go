@@ -0,0 +1,187 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webhook-demo/internal/models"
+)
+
+// JobStatus 任务状态
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"    // 排队中
+	JobStatusRunning   JobStatus = "running"   // 执行中
+	JobStatusSucceeded JobStatus = "succeeded" // 执行成功
+	JobStatusFailed    JobStatus = "failed"    // 执行失败
+	JobStatusCanceled  JobStatus = "canceled"  // 已通过 /cancel 取消
+)
+
+// JobRecord 持久化的任务记录
+type JobRecord struct {
+	ID        string              `json:"id"`
+	Event     *models.GitHubEvent `json:"event"`
+	Commands  []*Command          `json:"commands,omitempty"`
+	Status    JobStatus           `json:"status"`
+	Attempts  int                 `json:"attempts"`
+	Result    string              `json:"result,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// Finished 任务是否已结束
+func (r *JobRecord) Finished() bool {
+	return r.Status == JobStatusSucceeded || r.Status == JobStatusFailed || r.Status == JobStatusCanceled
+}
+
+// JobStore 基于文件的任务存储，每个任务保存为一个JSON文件
+type JobStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewJobStore 创建新的任务存储
+func NewJobStore(dir string) (*JobStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建任务存储目录失败: %v", err)
+	}
+
+	return &JobStore{dir: dir}, nil
+}
+
+// Save 保存任务记录（先写临时文件再重命名，保证写入原子性）
+func (s *JobStore) Save(record *JobRecord) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化任务记录失败: %v", err)
+	}
+
+	tmpPath := s.path(record.ID) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("写入任务记录失败: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, s.path(record.ID)); err != nil {
+		return fmt.Errorf("保存任务记录失败: %v", err)
+	}
+
+	return nil
+}
+
+// Get 获取任务记录
+func (s *JobStore) Get(id string) (*JobRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load(s.path(id))
+}
+
+// List 列出所有任务记录，按创建时间排序
+func (s *JobStore) List() ([]*JobRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取任务存储目录失败: %v", err)
+	}
+
+	var records []*JobRecord
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		record, err := s.load(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			log.Printf("跳过损坏的任务记录 %s: %v", entry.Name(), err)
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
+
+// Unfinished 列出未结束的任务（排队中或执行中）
+func (s *JobStore) Unfinished() ([]*JobRecord, error) {
+	records, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var unfinished []*JobRecord
+	for _, record := range records {
+		if !record.Finished() {
+			unfinished = append(unfinished, record)
+		}
+	}
+
+	return unfinished, nil
+}
+
+// Prune 删除结束时间早于maxAge的任务记录
+func (s *JobStore) Prune(maxAge time.Duration) error {
+	records, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	removed := 0
+	for _, record := range records {
+		if record.Finished() && time.Since(record.UpdatedAt) > maxAge {
+			if err := os.Remove(s.path(record.ID)); err != nil && !os.IsNotExist(err) {
+				log.Printf("删除任务记录失败 %s: %v", record.ID, err)
+				continue
+			}
+			removed++
+		}
+	}
+
+	if removed > 0 {
+		log.Printf("已清理 %d 条过期任务记录", removed)
+	}
+
+	return nil
+}
+
+// path 任务记录文件路径
+func (s *JobStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// load 从文件加载任务记录
+func (s *JobStore) load(path string) (*JobRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取任务记录失败: %v", err)
+	}
+
+	var record JobRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("解析任务记录失败: %v", err)
+	}
+
+	return &record, nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrJobCanceled 任务已通过 /cancel 取消
+var ErrJobCanceled = errors.New("任务已取消")
+
+// TrackedJob 排队或执行中的任务
+type TrackedJob struct {
+	ID         string
+	Key        string    // 关联的Issue/PR，格式为 owner/repo#number
+	Commands   string    // 命令列表，如 "/code, /review"
+	User       string    // 触发者
+	Status     JobStatus // queued 或 running
+	EnqueuedAt time.Time
+	StartedAt  time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// JobTracker 按Issue/PR跟踪排队和执行中的任务，每个任务持有可取消的context
+type JobTracker struct {
+	jobs  map[string]*TrackedJob
+	mutex sync.Mutex
+}
+
+// NewJobTracker 创建任务跟踪器
+func NewJobTracker() *JobTracker {
+	return &JobTracker{
+		jobs: make(map[string]*TrackedJob),
+	}
+}
+
+// jobKey 任务关联的Issue/PR标识
+func jobKey(ctx *CommandContext) string {
+	if ctx == nil {
+		return ""
+	}
+
+	number := 0
+	if ctx.Issue != nil {
+		number = ctx.Issue.Number
+	} else if ctx.PullRequest != nil {
+		number = ctx.PullRequest.Number
+	}
+	if number == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s#%d", ctx.Repository.FullName, number)
+}
+
+// Track 开始跟踪排队中的任务
+func (t *JobTracker) Track(id, key, commands, user string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.jobs[id] = &TrackedJob{
+		ID:         id,
+		Key:        key,
+		Commands:   commands,
+		User:       user,
+		Status:     JobStatusQueued,
+		EnqueuedAt: time.Now(),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Context 返回任务的context，不改变任务状态，用于排队时响应取消；未跟踪的任务返回不可取消的context
+func (t *JobTracker) Context(id string) context.Context {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if job, exists := t.jobs[id]; exists {
+		return job.ctx
+	}
+	return context.Background()
+}
+
+// Start 标记任务开始执行并返回任务的context，未跟踪的任务返回不可取消的context
+func (t *JobTracker) Start(id string) context.Context {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	job, exists := t.jobs[id]
+	if !exists {
+		return context.Background()
+	}
+
+	job.Status = JobStatusRunning
+	job.StartedAt = time.Now()
+	return job.ctx
+}
+
+// Done 任务结束，停止跟踪
+func (t *JobTracker) Done(id string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if job, exists := t.jobs[id]; exists {
+		job.cancel()
+		delete(t.jobs, id)
+	}
+}
+
+// Cancel 取消Issue/PR关联的所有任务，返回被取消的任务
+// 执行中的任务由context取消终止子进程，排队中的任务在worker取出时跳过
+func (t *JobTracker) Cancel(key string) []TrackedJob {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var canceled []TrackedJob
+	for _, job := range t.jobs {
+		if job.Key != key || job.ctx.Err() != nil {
+			continue
+		}
+		job.cancel()
+		canceled = append(canceled, *job)
+	}
+
+	sortTrackedJobs(canceled)
+	return canceled
+}
+
+// List Issue/PR关联的排队和执行中的任务，按入队时间排序
+func (t *JobTracker) List(key string) []TrackedJob {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var jobs []TrackedJob
+	for _, job := range t.jobs {
+		if job.Key == key && job.ctx.Err() == nil {
+			jobs = append(jobs, *job)
+		}
+	}
+
+	sortTrackedJobs(jobs)
+	return jobs
+}
+
+// sortTrackedJobs 按入队时间排序
+func sortTrackedJobs(jobs []TrackedJob) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].EnqueuedAt.Before(jobs[j].EnqueuedAt)
+	})
+}
+
+// canceledError 任务被取消时返回 ErrJobCanceled，否则返回nil
+func canceledError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ErrJobCanceled
+	}
+	return nil
+}
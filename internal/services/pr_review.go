@@ -0,0 +1,296 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PR审查的结论
+const (
+	ReviewEventComment        = "COMMENT"
+	ReviewEventRequestChanges = "REQUEST_CHANGES"
+)
+
+// ReviewFinding AI审查发现的一个问题
+type ReviewFinding struct {
+	File       string `json:"file"`       // 相对仓库根目录的路径
+	Line       int    `json:"line"`       // 新文件中的行号
+	Severity   string `json:"severity"`   // low / medium / high / critical
+	Message    string `json:"message"`    // 问题说明
+	Suggestion string `json:"suggestion"` // 可选，替换该行的代码
+}
+
+// ReviewReport AI输出的结构化审查结果
+type ReviewReport struct {
+	Summary  string          `json:"summary"`  // 总体评价和合并建议
+	Findings []ReviewFinding `json:"findings"` // 发现的问题
+	Skipped  []SkippedFile   `json:"-"`        // 未审查的文件
+}
+
+// parseReviewReport 从AI输出中解析审查结果，兼容 ```json 代码块和前后的说明文字
+func parseReviewReport(output string) (*ReviewReport, error) {
+	text := strings.TrimSpace(output)
+	if match := jsonFencePattern.FindStringSubmatch(text); match != nil {
+		text = match[1]
+	}
+
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("审查结果中没有JSON对象")
+	}
+
+	var report ReviewReport
+	if err := json.Unmarshal([]byte(text[start:end+1]), &report); err != nil {
+		return nil, fmt.Errorf("解析审查结果失败: %v", err)
+	}
+
+	for i := range report.Findings {
+		finding := &report.Findings[i]
+		finding.File = strings.TrimPrefix(strings.TrimSpace(finding.File), "./")
+		finding.Severity = strings.ToLower(strings.TrimSpace(finding.Severity))
+		if severityRank(finding.Severity) < 0 {
+			finding.Severity = "medium"
+		}
+	}
+	return &report, nil
+}
+
+// jsonFencePattern ```json 代码块
+var jsonFencePattern = regexp.MustCompile("(?s)```(?:json)?\\s*\\n(.*?)\\n\\s*```")
+
+// severityRank 严重程度的排序，未知的严重程度返回-1
+func severityRank(severity string) int {
+	for i, level := range reviewSeverities {
+		if level == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// FilterBySeverity 只保留不低于minSeverity的问题，minSeverity为空时不过滤
+func (r *ReviewReport) FilterBySeverity(minSeverity string) {
+	minRank := severityRank(minSeverity)
+	if minRank <= 0 {
+		return
+	}
+
+	filtered := r.Findings[:0]
+	for _, finding := range r.Findings {
+		if severityRank(finding.Severity) >= minRank {
+			filtered = append(filtered, finding)
+		}
+	}
+	r.Findings = filtered
+}
+
+// Event 审查结论：存在high及以上的问题时要求修改，否则只评论
+func (r *ReviewReport) Event() string {
+	for _, finding := range r.Findings {
+		if severityRank(finding.Severity) >= severityRank("high") {
+			return ReviewEventRequestChanges
+		}
+	}
+	return ReviewEventComment
+}
+
+// Markdown 完整的审查报告，提交PR审查失败时发布为评论
+func (r *ReviewReport) Markdown() string {
+	var content strings.Builder
+	content.WriteString(r.Summary)
+
+	if len(r.Findings) == 0 {
+		content.WriteString("\n\n未发现问题。")
+	} else {
+		content.WriteString("\n\n### 发现的问题\n")
+		for _, finding := range r.Findings {
+			content.WriteString("\n")
+			content.WriteString(finding.markdown())
+		}
+	}
+
+	if skipped := skippedFilesMarkdown(r.Skipped); skipped != "" {
+		content.WriteString("\n\n")
+		content.WriteString(skipped)
+	}
+	return content.String()
+}
+
+// mergeReviewReports 合并分块审查的结果，多个部分的总结按部分列出，重复的问题只保留一个
+func mergeReviewReports(reports []*ReviewReport, chunks []reviewChunk) *ReviewReport {
+	if len(reports) == 1 {
+		return reports[0]
+	}
+
+	merged := &ReviewReport{}
+	var summary strings.Builder
+	seen := make(map[string]bool)
+	for i, report := range reports {
+		if i > 0 {
+			summary.WriteString("\n\n")
+		}
+		fmt.Fprintf(&summary, "#### 第 %d/%d 部分：%s\n\n%s", i+1, len(reports), formatFileList(chunks[i].Files), strings.TrimSpace(report.Summary))
+
+		for _, finding := range report.Findings {
+			key := fmt.Sprintf("%s:%d:%s", finding.File, finding.Line, finding.Message)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged.Findings = append(merged.Findings, finding)
+		}
+	}
+	merged.Summary = summary.String()
+	return merged
+}
+
+// formatFileList 文件列表的简短展示，文件较多时只列出前几个
+func formatFileList(files []string) string {
+	const maxShown = 5
+
+	shown := files
+	if len(shown) > maxShown {
+		shown = shown[:maxShown]
+	}
+	text := "`" + strings.Join(shown, "`、`") + "`"
+	if len(files) > maxShown {
+		text += fmt.Sprintf(" 等 %d 个文件", len(files))
+	}
+	return text
+}
+
+// severityIcons 严重程度的显示图标
+var severityIcons = map[string]string{
+	"low":      "🔵",
+	"medium":   "🟡",
+	"high":     "🟠",
+	"critical": "🔴",
+}
+
+// commentBody 行内评论内容，有修改建议时附带GitHub suggestion代码块
+func (f ReviewFinding) commentBody() string {
+	body := fmt.Sprintf("%s **%s** %s", severityIcons[f.Severity], f.Severity, f.Message)
+	if f.Suggestion != "" {
+		body += fmt.Sprintf("\n\n```suggestion\n%s\n```", strings.TrimRight(f.Suggestion, "\n"))
+	}
+	return body
+}
+
+// markdown 无法定位到diff行的问题在审查正文中的展示
+func (f ReviewFinding) markdown() string {
+	location := f.File
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", f.File, f.Line)
+	}
+
+	text := fmt.Sprintf("- %s **%s** `%s` %s", severityIcons[f.Severity], f.Severity, location, f.Message)
+	if f.Suggestion != "" {
+		text += fmt.Sprintf("\n  ```\n  %s\n  ```", strings.ReplaceAll(strings.TrimRight(f.Suggestion, "\n"), "\n", "\n  "))
+	}
+	return text
+}
+
+// diffPositions 新文件行号到review评论position的映射：文件路径 -> 行号 -> position
+// position从文件第一个 @@ 之后的行开始计数，之后的 @@ 行同样计数，直到下一个文件
+type diffPositions map[string]map[int]int
+
+// diffHunkPattern hunk头，捕获新文件的起始行号
+var diffHunkPattern = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// parseDiffPositions 解析unified diff，只记录新文件一侧可评论的行（新增行和上下文行）
+func parseDiffPositions(diff string) diffPositions {
+	positions := make(diffPositions)
+
+	file := ""
+	position, newLine := 0, 0
+	inHunk := false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			file, position, inHunk = "", 0, false
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = ""
+			}
+		case strings.HasPrefix(line, "@@"):
+			match := diffHunkPattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			if inHunk {
+				position++
+			}
+			inHunk = true
+			newLine, _ = strconv.Atoi(match[1])
+		case inHunk && line != "":
+			position++
+			switch line[0] {
+			case '+', ' ':
+				if file != "" {
+					if positions[file] == nil {
+						positions[file] = make(map[int]int)
+					}
+					positions[file][newLine] = position
+				}
+				newLine++
+			}
+		}
+	}
+
+	return positions
+}
+
+// diffFiles diff中变更的文件路径，重命名的文件使用新路径
+func diffFiles(diff string) []string {
+	var files []string
+	for _, line := range strings.Split(diff, "\n") {
+		if !strings.HasPrefix(line, "diff --git ") {
+			continue
+		}
+		if index := strings.LastIndex(line, " b/"); index >= 0 {
+			files = append(files, line[index+len(" b/"):])
+		}
+	}
+	return files
+}
+
+// Position 新文件行号对应的position，行不在diff中时返回false
+func (d diffPositions) Position(file string, line int) (int, bool) {
+	position, exists := d[file][line]
+	return position, exists
+}
+
+// annotateDiff 在diff的新增行和上下文行前标注新文件行号，便于AI给出准确的行号
+func annotateDiff(diff string) string {
+	var annotated strings.Builder
+
+	newLine := 0
+	inHunk := false
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			inHunk = false
+		case strings.HasPrefix(line, "@@"):
+			if match := diffHunkPattern.FindStringSubmatch(line); match != nil {
+				newLine, _ = strconv.Atoi(match[1])
+				inHunk = true
+			}
+		case inHunk && line != "" && (line[0] == '+' || line[0] == ' '):
+			fmt.Fprintf(&annotated, "%5d %s\n", newLine, line)
+			newLine++
+			continue
+		case inHunk && line != "" && line[0] == '-':
+			fmt.Fprintf(&annotated, "%5s %s\n", "", line)
+			continue
+		}
+		annotated.WriteString(line)
+		annotated.WriteString("\n")
+	}
+
+	return strings.TrimSuffix(annotated.String(), "\n")
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 命令执行的阶段名称
+const (
+	StageClone  = "克隆仓库"
+	StageAgent  = "AI处理"
+	StageCommit = "提交更改"
+	StagePush   = "推送分支"
+	StagePR     = "创建Pull Request"
+	StageReview = "提交审查"
+)
+
+// StageStatus 阶段状态
+type StageStatus int
+
+const (
+	StagePending   StageStatus = iota // 等待执行
+	StageRunning                      // 执行中
+	StageSucceeded                    // 已完成
+	StageFailed                       // 失败
+	StageSkipped                      // 因前面的阶段失败未执行
+)
+
+// String 状态的显示文本
+func (s StageStatus) String() string {
+	switch s {
+	case StageRunning:
+		return "⏳ 进行中"
+	case StageSucceeded:
+		return "✅ 完成"
+	case StageFailed:
+		return "❌ 失败"
+	case StageSkipped:
+		return "⏭️ 未执行"
+	default:
+		return "⬜ 等待"
+	}
+}
+
+// progressStage 一个执行阶段
+type progressStage struct {
+	name       string
+	status     StageStatus
+	detail     string
+	startedAt  time.Time
+	finishedAt time.Time
+}
+
+// ProgressReporter 进度评论，命令开始时发布，每个阶段开始或结束时原地更新
+// 所有方法在接收者为nil时什么都不做，调用方不需要判断是否启用了进度评论
+type ProgressReporter struct {
+	githubService *GitHubService
+	owner         string
+	repo          string
+	number        int
+	title         string
+	stages        []*progressStage
+	current       *progressStage
+	startedAt     time.Time
+	commentID     int64
+	posted        bool
+}
+
+// startProgress 发布"处理中"评论并返回进度评论，stages为按执行顺序排列的阶段名称
+func (ep *EventProcessor) startProgress(ctx *CommandContext, title string, stages ...string) *ProgressReporter {
+	number := 0
+	if ctx.Issue != nil {
+		number = ctx.Issue.Number
+	} else if ctx.PullRequest != nil {
+		number = ctx.PullRequest.Number
+	}
+
+	progress := &ProgressReporter{
+		githubService: ep.githubService,
+		owner:         ctx.Repository.Owner.Login,
+		repo:          ctx.Repository.Name,
+		number:        number,
+		title:         title,
+		startedAt:     time.Now(),
+	}
+	for _, name := range stages {
+		progress.stages = append(progress.stages, &progressStage{name: name})
+	}
+
+	// 任务排队时发布过排队评论的，直接在该评论上更新进度
+	if commentID := claimQueueNotice(ctx.Context); commentID != 0 {
+		progress.commentID = commentID
+		progress.posted = true
+	}
+
+	progress.update(fmt.Sprintf("⏳ **%s处理中**", title), "")
+	return progress
+}
+
+// Begin 开始执行阶段
+func (p *ProgressReporter) Begin(name string) {
+	if p == nil {
+		return
+	}
+
+	stage := p.stage(name)
+	stage.status = StageRunning
+	stage.startedAt = time.Now()
+	p.current = stage
+
+	p.update(fmt.Sprintf("⏳ **%s处理中**", p.title), "")
+}
+
+// Done 当前阶段完成，detail为可选的说明
+func (p *ProgressReporter) Done(detail string) {
+	if p == nil || p.current == nil {
+		return
+	}
+
+	p.finishCurrent(StageSucceeded, detail)
+	p.update(fmt.Sprintf("⏳ **%s处理中**", p.title), "")
+}
+
+// Fail 当前阶段失败，后续阶段仍可继续执行
+func (p *ProgressReporter) Fail(err error) {
+	if p == nil || p.current == nil {
+		return
+	}
+
+	p.finishCurrent(StageFailed, err.Error())
+	p.update(fmt.Sprintf("⏳ **%s处理中**", p.title), "")
+}
+
+// Finish 所有阶段执行结束，更新为最终结果，body为结果内容
+func (p *ProgressReporter) Finish(body string) error {
+	if p == nil {
+		return nil
+	}
+
+	header := fmt.Sprintf("✅ **%s已完成**", p.title)
+	for _, stage := range p.stages {
+		if stage.status == StageFailed {
+			header = fmt.Sprintf("⚠️ **%s已完成，部分步骤失败**", p.title)
+			break
+		}
+	}
+
+	p.skipPending()
+	return p.final(header, body)
+}
+
+// Abort 当前阶段失败并终止，后续阶段标记为未执行
+func (p *ProgressReporter) Abort(err error) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.current != nil {
+		p.finishCurrent(StageFailed, err.Error())
+	}
+	p.skipPending()
+
+	return p.final(fmt.Sprintf("❌ **%s失败**", p.title), fmt.Sprintf("错误信息: %s", err.Error()))
+}
+
+// stage 按名称查找阶段，未声明的阶段追加到末尾
+func (p *ProgressReporter) stage(name string) *progressStage {
+	for _, stage := range p.stages {
+		if stage.name == name {
+			return stage
+		}
+	}
+
+	stage := &progressStage{name: name}
+	p.stages = append(p.stages, stage)
+	return stage
+}
+
+// finishCurrent 结束当前阶段
+func (p *ProgressReporter) finishCurrent(status StageStatus, detail string) {
+	p.current.status = status
+	p.current.detail = detail
+	p.current.finishedAt = time.Now()
+	p.current = nil
+}
+
+// skipPending 将未开始的阶段标记为未执行
+func (p *ProgressReporter) skipPending() {
+	for _, stage := range p.stages {
+		if stage.status == StagePending || stage.status == StageRunning {
+			stage.status = StageSkipped
+		}
+	}
+}
+
+// final 更新为最终结果，更新失败时改为发布新评论，保证结果不会丢失
+func (p *ProgressReporter) final(header, body string) error {
+	if p.update(header, body) {
+		return nil
+	}
+	return p.githubService.CreateComment(p.owner, p.repo, p.number, p.render(header, body))
+}
+
+// update 发布或更新进度评论，返回是否成功；进度更新失败不影响命令执行
+func (p *ProgressReporter) update(header, body string) bool {
+	content := p.render(header, body)
+
+	if !p.posted {
+		comment, err := p.githubService.CreateCommentWithResponse(p.owner, p.repo, p.number, content)
+		if err != nil {
+			log.Printf("发布进度评论失败: %v", err)
+			return false
+		}
+		p.commentID = comment.ID
+		p.posted = true
+		return true
+	}
+
+	if err := p.githubService.UpdateComment(p.owner, p.repo, p.commentID, content); err != nil {
+		log.Printf("更新进度评论失败: %v", err)
+		return false
+	}
+	return true
+}
+
+// render 生成评论内容
+func (p *ProgressReporter) render(header, body string) string {
+	var content strings.Builder
+	content.WriteString(header)
+	content.WriteString("\n\n| 步骤 | 状态 | 耗时 | 说明 |\n|------|------|------|------|\n")
+
+	now := time.Now()
+	for _, stage := range p.stages {
+		elapsed := ""
+		switch stage.status {
+		case StageRunning:
+			elapsed = formatElapsed(now.Sub(stage.startedAt))
+		case StageSucceeded, StageFailed:
+			elapsed = formatElapsed(stage.finishedAt.Sub(stage.startedAt))
+		}
+		fmt.Fprintf(&content, "| %s | %s | %s | %s |\n", stage.name, stage.status, elapsed, escapeTableCell(stage.detail))
+	}
+
+	if body != "" {
+		content.WriteString("\n")
+		content.WriteString(body)
+		content.WriteString("\n")
+	}
+
+	fmt.Fprintf(&content, "\n---\n*开始时间: %s，已用时: %s*",
+		p.startedAt.Format("2006-01-02 15:04:05"), formatElapsed(now.Sub(p.startedAt)))
+	return content.String()
+}
+
+// formatElapsed 格式化耗时
+func formatElapsed(d time.Duration) string {
+	if d < time.Second {
+		return "<1s"
+	}
+	return d.Round(time.Second).String()
+}
+
+// escapeTableCell 转义Markdown表格单元格内容
+func escapeTableCell(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len([]rune(text)) > 200 {
+		text = string([]rune(text)[:200]) + "..."
+	}
+	return strings.ReplaceAll(text, "|", "\\|")
+}
@@ -0,0 +1,379 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webhook-demo/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile 仓库配置文件，从仓库默认分支读取
+const RepoConfigFile = ".codeagent.yml"
+
+// defaultBranchPattern 默认的分支命名规则
+const defaultBranchPattern = "auto-fix-issue-{issue}-{timestamp}"
+
+// maxRepoTimeoutSeconds 仓库配置允许的最大AI调用超时
+const maxRepoTimeoutSeconds = 3600
+
+// RepoConfig 仓库级配置，覆盖全局环境变量
+type RepoConfig struct {
+	Version        int               `yaml:"version"`         // 配置格式版本，目前只支持1
+	Commands       []string          `yaml:"commands"`        // 启用的命令，为空时全部启用
+	Model          string            `yaml:"model"`           // AI模型
+	TimeoutSeconds int               `yaml:"timeout_seconds"` // AI调用超时（秒）
+	BranchPattern  string            `yaml:"branch_pattern"`  // 分支命名规则，支持 {issue}、{timestamp}、{user}
+	PRTemplate     string            `yaml:"pr_template"`     // PR描述模板，支持 {issue}、{title}、{branch}、{user}
+	CommitScopes   []CommitScopeRule `yaml:"commit_scopes"`   // 路径到commit scope的映射，按顺序匹配
+	Paths          PathRules         `yaml:"paths"`           // 允许修改的路径
+	Reviewers      []string          `yaml:"reviewers"`       // PR审查者，org/team 形式表示团队
+	Provider       string            `yaml:"provider"`        // 默认AI提供方
+	Providers      map[string]string `yaml:"providers"`       // 按命令指定AI提供方
+	AutoReview     AutoReviewConfig  `yaml:"auto_review"`     // PR打开和推送时自动审查
+}
+
+// AutoReviewConfig 自动审查配置，默认关闭
+type AutoReviewConfig struct {
+	Enabled          bool     `yaml:"enabled"`           // 是否启用自动审查
+	Drafts           bool     `yaml:"drafts"`            // 是否审查草稿PR，默认跳过
+	Bots             bool     `yaml:"bots"`              // 是否审查机器人创建的PR，默认跳过
+	SkipLabels       []string `yaml:"skip_labels"`       // 带有这些标签的PR跳过审查
+	SkipPaths        []string `yaml:"skip_paths"`        // 变更的文件全部匹配这些路径时跳过审查
+	UntrustedAuthors bool     `yaml:"untrusted_authors"` // 是否审查fork仓库或没有写权限的作者创建的PR，默认跳过
+}
+
+// CommitScopeRule 路径到commit scope的映射规则
+type CommitScopeRule struct {
+	Path  string `yaml:"path"`  // 路径模式，支持 ** 通配
+	Scope string `yaml:"scope"` // commit scope
+}
+
+// PathRules 允许修改的路径规则
+type PathRules struct {
+	Allow []string `yaml:"allow"` // 只允许修改匹配的路径，为空时不限制
+	Deny  []string `yaml:"deny"`  // 禁止修改匹配的路径，优先于allow
+}
+
+// RepoConfigError 仓库配置错误，包含所有校验失败的字段
+type RepoConfigError struct {
+	Problems []string
+}
+
+// Error 错误信息
+func (e *RepoConfigError) Error() string {
+	return fmt.Sprintf("%s 配置无效: %s", RepoConfigFile, strings.Join(e.Problems, "; "))
+}
+
+// RepoConfigReadError 无法读取仓库配置文件（如网络或认证失败），此时无法确认仓库对命令和路径的限制
+type RepoConfigReadError struct {
+	Err error
+}
+
+// Error 错误信息
+func (e *RepoConfigReadError) Error() string {
+	return fmt.Sprintf("读取 %s 失败: %v", RepoConfigFile, e.Err)
+}
+
+// Unwrap 返回读取失败的原因
+func (e *RepoConfigReadError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// scopeNamePattern 合法的commit scope
+	scopeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	// reviewerPattern 合法的审查者：GitHub用户名或 org/team
+	reviewerPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?(?:/[A-Za-z0-9][A-Za-z0-9_.-]*)?$`)
+	// branchPlaceholderPattern 分支命名规则中的占位符
+	branchPlaceholderPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+	// unknownFieldPattern YAML未知字段错误
+	unknownFieldPattern = regexp.MustCompile(`field (\S+) not found in type \S+`)
+	// invalidBranchPattern 分支名中不允许出现的内容
+	invalidBranchPattern = regexp.MustCompile(`[\s~^:?*\[\\]|\.\.|@\{|//|^/|/$|\.lock$|^-`)
+)
+
+// ParseRepoConfig 解析并校验仓库配置，未知字段、未注册的命令和AI提供方视为错误
+func ParseRepoConfig(data []byte, commands *CommandRegistry, agents *AgentRegistry) (*RepoConfig, error) {
+	cfg := &RepoConfig{}
+	var problems []string
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		// 字段类型错误时其余字段仍会解析，继续校验以便一次报告所有问题
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, &RepoConfigError{Problems: []string{fmt.Sprintf("YAML解析失败: %v", err)}}
+		}
+		for _, message := range typeErr.Errors {
+			problems = append(problems, unknownFieldPattern.ReplaceAllString(message, "未知字段 $1"))
+		}
+	}
+
+	problems = append(problems, cfg.validate(commands, agents)...)
+	if len(problems) > 0 {
+		return nil, &RepoConfigError{Problems: problems}
+	}
+
+	return cfg, nil
+}
+
+// validate 校验配置字段，返回所有问题
+func (c *RepoConfig) validate(commands *CommandRegistry, agents *AgentRegistry) []string {
+	var problems []string
+
+	for i, name := range c.Commands {
+		if _, exists := commands.Lookup(name); !exists {
+			problems = append(problems, fmt.Sprintf("commands[%d]: 未知命令 %q", i, name))
+		}
+	}
+
+	if c.Provider != "" && !agents.Has(c.Provider) {
+		problems = append(problems, fmt.Sprintf("provider: 未启用的AI提供方 %q（可用: %s）", c.Provider, strings.Join(agents.Names(), ", ")))
+	}
+	for _, name := range sortedKeys(c.Providers) {
+		handler, exists := commands.Lookup(name)
+		if !exists {
+			problems = append(problems, fmt.Sprintf("providers.%s: 未知命令", name))
+		}
+		provider := c.Providers[name]
+		if !agents.Has(provider) {
+			problems = append(problems, fmt.Sprintf("providers.%s: 未启用的AI提供方 %q（可用: %s）", name, provider, strings.Join(agents.Names(), ", ")))
+		} else if exists && repoCommands[handler.Name()] && !agents.CanAccessRepo(provider) {
+			problems = append(problems, fmt.Sprintf("providers.%s: AI提供方 %q 无法读写仓库文件，该命令需要可以读写仓库文件的提供方（如 %s）", name, provider, ProviderClaudeCLI))
+		}
+	}
+
+	if c.Version != 0 && c.Version != 1 {
+		problems = append(problems, fmt.Sprintf("version: 不支持的版本 %d，目前只支持 1", c.Version))
+	}
+
+	if c.TimeoutSeconds < 0 || c.TimeoutSeconds > maxRepoTimeoutSeconds {
+		problems = append(problems, fmt.Sprintf("timeout_seconds: 必须在 0 到 %d 之间", maxRepoTimeoutSeconds))
+	}
+
+	if c.Model != "" && strings.ContainsAny(c.Model, " \t\n") {
+		problems = append(problems, "model: 不能包含空白字符")
+	}
+
+	if c.BranchPattern != "" {
+		if !strings.Contains(c.BranchPattern, "{issue}") {
+			problems = append(problems, "branch_pattern: 必须包含 {issue}")
+		}
+		for _, match := range branchPlaceholderPattern.FindAllStringSubmatch(c.BranchPattern, -1) {
+			if match[1] != "issue" && match[1] != "timestamp" && match[1] != "user" {
+				problems = append(problems, fmt.Sprintf("branch_pattern: 不支持的占位符 {%s}", match[1]))
+			}
+		}
+		if invalidBranchPattern.MatchString(branchPlaceholderPattern.ReplaceAllString(c.BranchPattern, "x")) {
+			problems = append(problems, fmt.Sprintf("branch_pattern: %q 不是合法的分支名", c.BranchPattern))
+		}
+	}
+
+	for i, rule := range c.CommitScopes {
+		if rule.Path == "" {
+			problems = append(problems, fmt.Sprintf("commit_scopes[%d].path: 不能为空", i))
+		}
+		if !scopeNamePattern.MatchString(rule.Scope) {
+			problems = append(problems, fmt.Sprintf("commit_scopes[%d].scope: %q 只能包含小写字母、数字、- 和 _", i, rule.Scope))
+		}
+	}
+
+	for i, pattern := range c.Paths.Allow {
+		if strings.TrimSpace(pattern) == "" {
+			problems = append(problems, fmt.Sprintf("paths.allow[%d]: 不能为空", i))
+		}
+	}
+	for i, pattern := range c.Paths.Deny {
+		if strings.TrimSpace(pattern) == "" {
+			problems = append(problems, fmt.Sprintf("paths.deny[%d]: 不能为空", i))
+		}
+	}
+
+	for i, label := range c.AutoReview.SkipLabels {
+		if strings.TrimSpace(label) == "" {
+			problems = append(problems, fmt.Sprintf("auto_review.skip_labels[%d]: 不能为空", i))
+		}
+	}
+	for i, pattern := range c.AutoReview.SkipPaths {
+		if strings.TrimSpace(pattern) == "" {
+			problems = append(problems, fmt.Sprintf("auto_review.skip_paths[%d]: 不能为空", i))
+		}
+	}
+
+	for i, reviewer := range c.Reviewers {
+		if !reviewerPattern.MatchString(reviewer) {
+			problems = append(problems, fmt.Sprintf("reviewers[%d]: %q 不是合法的用户名或 org/team", i, reviewer))
+		}
+	}
+
+	return problems
+}
+
+// CommandEnabled 检查命令是否启用
+func (c *RepoConfig) CommandEnabled(name string) bool {
+	if c == nil || len(c.Commands) == 0 {
+		return true
+	}
+	return containsString(c.Commands, name)
+}
+
+// BranchName 根据分支命名规则生成分支名
+func (c *RepoConfig) BranchName(issueNumber int, user string) string {
+	pattern := defaultBranchPattern
+	if c != nil && c.BranchPattern != "" {
+		pattern = c.BranchPattern
+	}
+
+	return strings.NewReplacer(
+		"{issue}", fmt.Sprintf("%d", issueNumber),
+		"{timestamp}", time.Now().Format("20060102-150405"),
+		"{user}", user,
+	).Replace(pattern)
+}
+
+// IssueBranchGlob 匹配Issue所有机器人分支的通配模式，用于列出远程分支
+func (c *RepoConfig) IssueBranchGlob(issueNumber int) string {
+	pattern := defaultBranchPattern
+	if c != nil && c.BranchPattern != "" {
+		pattern = c.BranchPattern
+	}
+
+	return strings.NewReplacer(
+		"{issue}", fmt.Sprintf("%d", issueNumber),
+		"{timestamp}", "*",
+		"{user}", "*",
+	).Replace(pattern)
+}
+
+// LatestIssueBranch 从分支列表中选出Issue最新的机器人分支，没有匹配的分支时返回空字符串
+// 分支按命名规则精确匹配，同一规则下按时间戳排序
+func (c *RepoConfig) LatestIssueBranch(issueNumber int, branches []string) string {
+	pattern := defaultBranchPattern
+	if c != nil && c.BranchPattern != "" {
+		pattern = c.BranchPattern
+	}
+
+	expr := strings.NewReplacer(
+		`\{issue\}`, fmt.Sprintf("%d", issueNumber),
+		`\{timestamp\}`, `(?P<timestamp>\d{8}-\d{6})`,
+		`\{user\}`, `[^/]+`,
+	).Replace(regexp.QuoteMeta(pattern))
+	matcher, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ""
+	}
+	timestampIndex := matcher.SubexpIndex("timestamp")
+
+	latest, latestTimestamp := "", ""
+	for _, branch := range branches {
+		match := matcher.FindStringSubmatch(branch)
+		if match == nil {
+			continue
+		}
+
+		timestamp := ""
+		if timestampIndex >= 0 {
+			timestamp = match[timestampIndex]
+		}
+		if latest == "" || timestamp > latestTimestamp || (timestamp == latestTimestamp && branch > latest) {
+			latest, latestTimestamp = branch, timestamp
+		}
+	}
+	return latest
+}
+
+// PRBody 根据PR模板生成PR描述，未配置模板时返回空字符串
+func (c *RepoConfig) PRBody(issueNumber int, title, branch, user string) string {
+	if c == nil || c.PRTemplate == "" {
+		return ""
+	}
+
+	return strings.NewReplacer(
+		"{issue}", fmt.Sprintf("%d", issueNumber),
+		"{title}", title,
+		"{branch}", branch,
+		"{user}", user,
+	).Replace(c.PRTemplate)
+}
+
+// AllowsPath 检查路径是否允许修改
+func (c *RepoConfig) AllowsPath(path string) bool {
+	if c == nil {
+		return true
+	}
+	if matchAnyPathPattern(c.Paths.Deny, path) {
+		return false
+	}
+	return len(c.Paths.Allow) == 0 || matchAnyPathPattern(c.Paths.Allow, path)
+}
+
+// AutoReviewSkipReason 检查PR是否需要跳过自动审查，返回跳过的原因，需要审查时返回空字符串
+func (c *RepoConfig) AutoReviewSkipReason(pr *models.PullRequest) string {
+	if c == nil || !c.AutoReview.Enabled {
+		return "仓库未启用自动审查"
+	}
+	if pr.Draft && !c.AutoReview.Drafts {
+		return "草稿PR"
+	}
+	if isBotUser(pr.User) && !c.AutoReview.Bots {
+		return fmt.Sprintf("机器人 %s 创建的PR", pr.User.Login)
+	}
+	for _, label := range pr.Labels {
+		if containsString(c.AutoReview.SkipLabels, label.Name) {
+			return fmt.Sprintf("带有标签 %s", label.Name)
+		}
+	}
+	return ""
+}
+
+// AutoReviewSkipsFiles 检查变更的文件是否全部匹配skip_paths，没有文件时返回false
+func (c *RepoConfig) AutoReviewSkipsFiles(files []string) bool {
+	if c == nil || len(c.AutoReview.SkipPaths) == 0 || len(files) == 0 {
+		return false
+	}
+	for _, file := range files {
+		if !matchAnyPathPattern(c.AutoReview.SkipPaths, file) {
+			return false
+		}
+	}
+	return true
+}
+
+// isBotUser 检查用户是否为机器人账号，GitHub App的用户名以 [bot] 结尾
+func isBotUser(user models.User) bool {
+	return user.Type == "Bot" || strings.HasSuffix(user.Login, "[bot]")
+}
+
+// SplitReviewers 将审查者拆分为用户和团队
+func (c *RepoConfig) SplitReviewers() (users []string, teams []string) {
+	if c == nil {
+		return nil, nil
+	}
+
+	for _, reviewer := range c.Reviewers {
+		if index := strings.Index(reviewer, "/"); index >= 0 {
+			teams = append(teams, reviewer[index+1:])
+		} else {
+			users = append(users, reviewer)
+		}
+	}
+	return users, teams
+}
+
+// sortedKeys 按字母顺序返回map的键，保证错误信息顺序稳定
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
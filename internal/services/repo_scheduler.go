@@ -0,0 +1,162 @@
+package services
+
+import (
+	"context"
+	"sync"
+)
+
+// RepoScheduler 按仓库调度任务：同一仓库的任务依次执行，不同仓库的任务并行执行，同时执行的任务不超过limit
+// 等待的任务按入队顺序获得执行机会，排在前面的任务所在仓库正忙时，后面其他仓库的任务可以先执行
+type RepoScheduler struct {
+	limit   int
+	running int
+	active  map[string]bool // 正在执行任务的仓库
+	waiting []*repoTicket   // 按入队顺序排列的等待任务
+	started bool
+	mutex   sync.Mutex
+}
+
+// repoTicket 任务的排队凭证
+type repoTicket struct {
+	repo     string        // 仓库全名，为空时只受全局并发限制
+	ready    chan struct{} // 获得执行机会时关闭
+	position chan int      // 排队位置变化，只保留最新的位置
+	notified int           // 最近一次通知的排队位置
+	granted  bool
+	released bool
+}
+
+// NewRepoScheduler 创建调度器，limit为全局并发上限
+func NewRepoScheduler(limit int) *RepoScheduler {
+	if limit <= 0 {
+		limit = 1
+	}
+	return &RepoScheduler{
+		limit:  limit,
+		active: make(map[string]bool),
+	}
+}
+
+// Start 开始调度，之前入队的任务只排队不执行
+func (s *RepoScheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.started = true
+	s.dispatch()
+}
+
+// Enqueue 任务排队，返回的凭证通过Wait等待执行机会，任务结束后必须调用Release
+func (s *RepoScheduler) Enqueue(repo string) *repoTicket {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ticket := &repoTicket{
+		repo:     repo,
+		ready:    make(chan struct{}),
+		position: make(chan int, 1),
+	}
+	s.waiting = append(s.waiting, ticket)
+	s.dispatch()
+	return ticket
+}
+
+// Wait 等待任务获得执行机会，onWait在需要排队及排队位置变化时调用，位置从1开始
+// ctx结束时放弃排队，任务被取消时返回 ErrJobCanceled
+func (s *RepoScheduler) Wait(ctx context.Context, ticket *repoTicket, onWait func(position int)) error {
+	for {
+		select {
+		case <-ticket.ready:
+			return nil
+		case position := <-ticket.position:
+			// 位置变化和获得执行机会同时发生时不再通知排队
+			select {
+			case <-ticket.ready:
+				return nil
+			default:
+			}
+			if onWait != nil {
+				onWait(position)
+			}
+		case <-ctx.Done():
+			s.Release(ticket)
+			if canceled := canceledError(ctx); canceled != nil {
+				return canceled
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// Release 任务结束或放弃排队，释放仓库和全局并发名额，可重复调用
+func (s *RepoScheduler) Release(ticket *repoTicket) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ticket.released {
+		return
+	}
+	ticket.released = true
+
+	if ticket.granted {
+		s.running--
+		delete(s.active, ticket.repo)
+	} else {
+		for i, waiting := range s.waiting {
+			if waiting == ticket {
+				s.waiting = append(s.waiting[:i], s.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	s.dispatch()
+}
+
+// Waiting 排队等待的任务数量
+func (s *RepoScheduler) Waiting() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.waiting)
+}
+
+// Running 正在执行的任务数量
+func (s *RepoScheduler) Running() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running
+}
+
+// dispatch 按入队顺序为仓库空闲的任务分配执行机会，并通知其余任务新的排队位置，调用方需持有mutex
+func (s *RepoScheduler) dispatch() {
+	if s.started {
+		remaining := s.waiting[:0]
+		for _, ticket := range s.waiting {
+			if s.running >= s.limit || (ticket.repo != "" && s.active[ticket.repo]) {
+				remaining = append(remaining, ticket)
+				continue
+			}
+			s.running++
+			if ticket.repo != "" {
+				s.active[ticket.repo] = true
+			}
+			ticket.granted = true
+			close(ticket.ready)
+		}
+		for i := len(remaining); i < len(s.waiting); i++ {
+			s.waiting[i] = nil
+		}
+		s.waiting = remaining
+	}
+
+	for i, ticket := range s.waiting {
+		if ticket.notified == i+1 {
+			continue
+		}
+		ticket.notified = i + 1
+		select {
+		case <-ticket.position:
+		default:
+		}
+		ticket.position <- i + 1
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,21 +20,74 @@ import (
 )
 
 func main() {
+	// 作为git的askpass被调用时只输出凭据
+	if services.RunAskpass(os.Args) {
+		return
+	}
+
+	// 离线运行命令模式
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runOffline(os.Args[2:]))
+	}
+
 	// 加载配置
 	cfg := config.Load()
 
 	// 初始化服务
 	githubService := services.NewGitHubService(cfg.GitHub.Token)
+	githubService.SetPermissionCacheTTL(time.Duration(cfg.GitHub.PermissionCacheMinutes) * time.Minute)
 	claudeCodeService := services.NewClaudeCodeCLIService(&cfg.ClaudeCodeCLI)
 	gitConfig := config.LoadGitConfig()
 	gitService := services.NewGitServiceWithToken(gitConfig.WorkDir, cfg.GitHub.Token)
+	endpoints := services.NewGitHubEndpoints(cfg.GitHub.APIURL, cfg.GitHub.GitHost)
+	githubService.SetEndpoints(endpoints)
+	gitService.SetCredentialHost(endpoints.GitHost)
+	githubService.SetEnterpriseHosts(cfg.GitHub.EnterpriseHosts)
+	log.Printf("GitHub API地址: %s, git主机: %s", endpoints.APIURL, endpoints.GitHost)
+	if len(cfg.GitHub.EnterpriseHosts) > 0 {
+		log.Printf("允许的GitHub Enterprise Server主机: %s", strings.Join(cfg.GitHub.EnterpriseHosts, ", "))
+	}
+	if err := setupGitHubApp(cfg, endpoints, githubService, gitService); err != nil {
+		log.Fatalf("初始化GitHub App认证失败: %v", err)
+	}
 	eventProcessor := services.NewEventProcessor(githubService, claudeCodeService, gitService)
+	if err := setupAgents(eventProcessor, cfg); err != nil {
+		log.Fatalf("初始化AI提供方失败: %v", err)
+	}
+
+	// 初始化任务存储
+	jobStore, err := services.NewJobStore(filepath.Join(gitConfig.WorkDir, "jobs"))
+	if err != nil {
+		log.Fatalf("初始化任务存储失败: %v", err)
+	}
+
+	// 恢复未完成的任务并启动后台任务队列
+	jobQueue := services.NewJobQueue(eventProcessor, jobStore, &cfg.Queue)
+	if err := jobQueue.Recover(); err != nil {
+		log.Printf("恢复未完成任务失败: %v", err)
+	}
+	jobQueue.Start()
+
+	// 初始化投递记录，用于过滤重复投递
+	deliveryLedger := services.NewDeliveryLedger(
+		filepath.Join(gitConfig.WorkDir, "deliveries.json"),
+		time.Duration(cfg.GitHub.DeliveryTTLHours)*time.Hour)
+
+	// 初始化投递记录器，保存原始投递用于重放
+	deliveryRecorder, err := services.NewDeliveryRecorder(
+		filepath.Join(gitConfig.WorkDir, "deliveries"),
+		time.Duration(cfg.Admin.DeliveryRetentionHours)*time.Hour,
+		cfg.Admin.MaxDeliveries)
+	if err != nil {
+		log.Fatalf("初始化投递记录器失败: %v", err)
+	}
 
 	// 初始化处理器
-	webhookHandler := handlers.NewWebhookHandler(eventProcessor, cfg.GitHub.WebhookSecret)
+	webhookHandler := handlers.NewWebhookHandler(jobQueue, eventProcessor, deliveryLedger, deliveryRecorder, cfg.GitHub.WebhookSecret)
+	adminHandler := handlers.NewAdminHandler(deliveryRecorder, jobQueue, jobStore, eventProcessor)
 
 	// 设置路由
-	router := setupRouter(webhookHandler, cfg)
+	router := setupRouter(webhookHandler, adminHandler, cfg)
 
 	// 启动服务器
 	srv := &http.Server{
@@ -58,17 +114,69 @@ func main() {
 	log.Println("正在关闭服务器...")
 
 	// 优雅关闭超时
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeoutSeconds)*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("服务器强制关闭:", err)
 	}
 
+	// 停止接收新任务，等待后台任务处理完成
+	if err := jobQueue.Shutdown(ctx); err != nil {
+		log.Printf("后台任务未全部完成: %v", err)
+	}
+
 	log.Println("服务器已退出")
 }
 
-func setupRouter(webhookHandler *handlers.WebhookHandler, cfg *config.Config) *gin.Engine {
+// setupGitHubApp 配置了GitHub App时，GitHub API和git操作使用仓库所属安装的访问token代替 GITHUB_TOKEN，App位于endpoints所指的实例
+func setupGitHubApp(cfg *config.Config, endpoints services.GitHubEndpoints, githubService *services.GitHubService, gitService *services.GitService) error {
+	if cfg.GitHub.AppID == "" {
+		return nil
+	}
+
+	privateKey := []byte(cfg.GitHub.AppPrivateKey)
+	if cfg.GitHub.AppPrivateKeyPath != "" {
+		data, err := os.ReadFile(cfg.GitHub.AppPrivateKeyPath)
+		if err != nil {
+			return fmt.Errorf("读取GitHub App私钥失败: %v", err)
+		}
+		privateKey = data
+	}
+
+	app, err := services.NewGitHubApp(cfg.GitHub.AppID, privateKey)
+	if err != nil {
+		return err
+	}
+	app.SetEndpoints(endpoints)
+	githubService.UseApp(app)
+	gitService.SetTokenSource(app.TokenForURL)
+
+	log.Printf("使用GitHub App认证，App ID: %s", cfg.GitHub.AppID)
+	return nil
+}
+
+// setupAgents 注册可用的AI提供方，并按配置设置默认提供方和命令提供方
+func setupAgents(eventProcessor *services.EventProcessor, cfg *config.Config) error {
+	agents := eventProcessor.Agents()
+	if cfg.Claude.APIKey != "" {
+		agents.Register(services.NewAnthropicAPIService(&cfg.Claude))
+	}
+
+	if err := agents.SetDefault(cfg.Agent.Provider); err != nil {
+		return err
+	}
+	for command, provider := range cfg.Agent.CommandProviders {
+		if err := agents.SetCommandProvider(command, provider); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("已启用AI提供方: %v，默认: %s", agents.Names(), cfg.Agent.Provider)
+	return nil
+}
+
+func setupRouter(webhookHandler *handlers.WebhookHandler, adminHandler *handlers.AdminHandler, cfg *config.Config) *gin.Engine {
 	if cfg.Server.Mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
@@ -91,6 +199,15 @@ func setupRouter(webhookHandler *handlers.WebhookHandler, cfg *config.Config) *g
 	// Webhook端点
 	router.POST("/webhook", webhookHandler.HandleWebhook)
 
+	// 管理端点
+	admin := router.Group("/admin", middleware.AdminAuth(cfg.Admin.Token))
+	{
+		admin.GET("/deliveries", adminHandler.ListDeliveries)
+		admin.GET("/deliveries/:id", adminHandler.GetDelivery)
+		admin.POST("/deliveries/:id/replay", adminHandler.ReplayDelivery)
+		admin.GET("/jobs/:id", adminHandler.GetJob)
+	}
+
 	// API信息
 	router.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -100,6 +217,7 @@ func setupRouter(webhookHandler *handlers.WebhookHandler, cfg *config.Config) *g
 			"endpoints": map[string]string{
 				"webhook": "/webhook",
 				"health":  "/health",
+				"admin":   "/admin/deliveries",
 			},
 		})
 	})
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/webhook-demo/internal/config"
+	"github.com/webhook-demo/internal/models"
+	"github.com/webhook-demo/internal/services"
+)
+
+// runOffline 离线运行命令：webhook-demo run --repo ./path [--issue-file issue.json] [--pr-file pr.json] "/review security"
+// 使用本地仓库构建CommandContext，GitHub写操作输出到stdout，git push推送到本地bare仓库
+func runOffline(args []string) int {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	repoDir := flags.String("repo", "", "本地仓库路径（必填）")
+	issueFile := flags.String("issue-file", "", "Issue JSON文件（GitHub Issue结构）")
+	prFile := flags.String("pr-file", "", "Pull Request JSON文件（GitHub Pull Request结构）")
+	pushRemote := flags.String("push-remote", "", "接收推送的本地bare仓库路径或HTTP(S)仓库URL，默认自动创建临时bare仓库")
+	user := flags.String("user", "local", "触发命令的用户名")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), `用法: webhook-demo run --repo <路径> [选项] "<命令>"
+
+示例:
+  webhook-demo run --repo . "/summary"
+  webhook-demo run --repo ./myrepo --issue-file issue.json "/code 添加分页"
+  webhook-demo run --repo ./myrepo --pr-file pr.json "/review security"
+
+选项:`)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *repoDir == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	commandText := strings.Join(flags.Args(), " ")
+
+	repoPath, err := filepath.Abs(*repoDir)
+	if err != nil {
+		log.Printf("解析仓库路径失败: %v", err)
+		return 1
+	}
+
+	remotePath, err := prepareOfflineRemote(repoPath, *pushRemote)
+	if err != nil {
+		log.Printf("准备本地远程仓库失败: %v", err)
+		return 1
+	}
+
+	commandCtx, err := buildOfflineContext(repoPath, remotePath, *issueFile, *prFile, *user, commandText)
+	if err != nil {
+		log.Printf("构建命令上下文失败: %v", err)
+		return 1
+	}
+
+	// 初始化服务，GitHub写操作输出到stdout
+	cfg := config.Load()
+	gitConfig := config.LoadGitConfig()
+	githubService := services.NewOfflineGitHubService(os.Stdout)
+	claudeCodeService := services.NewClaudeCodeCLIService(&cfg.ClaudeCodeCLI)
+	gitService := services.NewGitServiceWithToken(gitConfig.WorkDir, "")
+	if remoteURL, err := url.Parse(remotePath); err == nil && (remoteURL.Scheme == "http" || remoteURL.Scheme == "https") {
+		// 推送到HTTP(S)仓库时使用 GITHUB_TOKEN 认证
+		gitService = services.NewGitServiceWithToken(gitConfig.WorkDir, cfg.GitHub.Token)
+		gitService.SetCredentialHost(remoteURL.Host)
+	}
+	eventProcessor := services.NewEventProcessor(githubService, claudeCodeService, gitService)
+	if err := setupAgents(eventProcessor, cfg); err != nil {
+		log.Printf("初始化AI提供方失败: %v", err)
+		return 1
+	}
+
+	log.Printf("离线运行命令: %s, 仓库: %s, 推送目标: %s", commandText, repoPath, remotePath)
+
+	if err := eventProcessor.RunCommand(commandText, commandCtx); err != nil {
+		log.Printf("命令执行失败: %v", err)
+		return 1
+	}
+
+	fmt.Printf("\n✅ 命令执行完成，推送的分支可在本地bare仓库中查看: git -C %s branch\n", remotePath)
+	return 0
+}
+
+// prepareOfflineRemote 准备接收推送的本地bare仓库
+func prepareOfflineRemote(repoPath, pushRemote string) (string, error) {
+	if strings.HasPrefix(pushRemote, "http://") || strings.HasPrefix(pushRemote, "https://") {
+		return pushRemote, nil
+	}
+	if pushRemote != "" {
+		return filepath.Abs(pushRemote)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "webhook-demo-remote-")
+	if err != nil {
+		return "", fmt.Errorf("创建临时目录失败: %v", err)
+	}
+
+	remotePath := filepath.Join(tmpDir, filepath.Base(repoPath)+".git")
+	output, err := exec.Command("git", "clone", "--bare", repoPath, remotePath).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("创建bare仓库失败: %v, %s", err, string(output))
+	}
+
+	return remotePath, nil
+}
+
+// buildOfflineContext 根据本地仓库和JSON文件构建命令上下文
+func buildOfflineContext(repoPath, remotePath, issueFile, prFile, user, commandText string) (*services.CommandContext, error) {
+	output, err := exec.Command("git", "-C", repoPath, "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("获取当前分支失败: %v", err)
+	}
+
+	name := filepath.Base(repoPath)
+	owner := models.User{Login: "local"}
+	sender := models.User{Login: user}
+
+	ctx := &services.CommandContext{
+		Repository: models.Repository{
+			Name:          name,
+			FullName:      owner.Login + "/" + name,
+			HTMLURL:       "file://" + repoPath,
+			CloneURL:      remotePath,
+			DefaultBranch: strings.TrimSpace(string(output)),
+			Owner:         owner,
+		},
+		User: sender,
+		Comment: &models.Comment{
+			Body: commandText,
+			User: sender,
+		},
+	}
+
+	if prFile != "" {
+		var pr models.PullRequest
+		if err := readJSONFile(prFile, &pr); err != nil {
+			return nil, err
+		}
+		ctx.PullRequest = &pr
+	}
+
+	if issueFile != "" {
+		var issue models.Issue
+		if err := readJSONFile(issueFile, &issue); err != nil {
+			return nil, err
+		}
+		ctx.Issue = &issue
+	} else if ctx.PullRequest == nil {
+		// 未提供Issue时使用命令文本构造一个本地Issue
+		ctx.Issue = &models.Issue{
+			Number: 1,
+			Title:  commandText,
+			State:  "open",
+			User:   sender,
+		}
+	}
+
+	return ctx, nil
+}
+
+// readJSONFile 读取JSON文件
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取文件失败 %s: %v", path, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("解析JSON失败 %s: %v", path, err)
+	}
+
+	return nil
+}
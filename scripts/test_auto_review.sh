@@ -0,0 +1,326 @@
+#!/bin/bash
+
+# 自动审查测试脚本
+# 启动模拟的GitHub API和git HTTP服务，仓库启用 auto_review 后向webhook服务发送带签名的 pull_request 事件，
+# 检查只自动审查本仓库分支上、作者拥有写权限的PR，fork仓库和只读作者的PR被跳过，untrusted_authors 开启后才审查；
+# 同时检查AI进程的环境变量中没有GitHub token和webhook密钥
+
+set -e
+
+cd "$(dirname "$0")/.."
+
+echo "🧪 开始测试自动审查..."
+
+for tool in go git python3 curl; do
+    if ! command -v $tool &> /dev/null; then
+        echo "❌ 错误: 未找到 $tool"
+        exit 1
+    fi
+done
+
+HTTP_BACKEND="$(git --exec-path)/git-http-backend"
+if [ ! -x "$HTTP_BACKEND" ]; then
+    echo "❌ 错误: 未找到 git-http-backend"
+    exit 1
+fi
+
+TMP=$(mktemp -d)
+GITHUB_PID=""
+SERVICE_PID=""
+cleanup() {
+    [ -n "$SERVICE_PID" ] && kill $SERVICE_PID 2>/dev/null || true
+    [ -n "$GITHUB_PID" ] && kill $GITHUB_PID 2>/dev/null || true
+    rm -rf "$TMP"
+}
+trap cleanup EXIT
+
+TOKEN="review-$(date +%s)-$RANDOM$RANDOM"
+SECRET="review-webhook-secret-$RANDOM$RANDOM"
+FAILED=0
+
+pass() { echo "✅ $1"; }
+fail() { echo "❌ $1"; FAILED=1; }
+
+free_port() {
+    python3 -c 'import socket; s=socket.socket(); s.bind(("127.0.0.1", 0)); print(s.getsockname()[1])'
+}
+
+# 编译项目
+echo "🔨 编译项目..."
+go build -o "$TMP/webhook-demo" .
+echo "✅ 编译成功"
+
+# 隔离的HOME
+export HOME="$TMP/home"
+mkdir -p "$HOME"
+git config --global user.name "CodeAgent Test"
+git config --global user.email "test@codeagent.com"
+git config --global init.defaultBranch main
+
+# 准备启用自动审查的仓库 org/test，feature 分支为PR的head
+git init -q "$TMP/repo"
+echo "# test" > "$TMP/repo/README.md"
+printf 'auto_review:\n  enabled: true\n' > "$TMP/repo/.codeagent.yml"
+git -C "$TMP/repo" add README.md .codeagent.yml
+git -C "$TMP/repo" commit -qm "init"
+git -C "$TMP/repo" checkout -qb feature
+echo "hello" > "$TMP/repo/feature.txt"
+git -C "$TMP/repo" add feature.txt
+git -C "$TMP/repo" commit -qm "add feature"
+git -C "$TMP/repo" checkout -q main
+mkdir -p "$TMP/srv/org"
+git clone -q --bare "$TMP/repo" "$TMP/srv/org/test.git"
+git -C "$TMP/srv/org/test.git" config http.receivepack true
+git -C "$TMP/srv/org/test.git" update-ref refs/pull/5/head refs/heads/feature
+BASE_SHA=$(git -C "$TMP/srv/org/test.git" rev-parse refs/heads/main)
+FEATURE_SHA=$(git -C "$TMP/srv/org/test.git" rev-parse refs/heads/feature)
+
+# 模拟的GitHub：/api/v3/ 下为REST API，其余路径为git HTTP服务，都要求token认证
+# 只有alice是仓库管理员，其他用户只有读权限
+cat > "$TMP/github.py" <<'EOF'
+import base64, http.server, itertools, json, os, re, subprocess, sys
+
+root, token, port, log_path = sys.argv[1], sys.argv[2], int(sys.argv[3]), sys.argv[4]
+git_auth = "Basic " + base64.b64encode(("x-access-token:" + token).encode()).decode()
+ids = itertools.count(1000)
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def log(self, kind, body=b""):
+        with open(log_path, "a") as log:
+            log.write("%s %s %s\n" % (kind, self.command, self.path))
+            if body:
+                log.write("BODY %s\n" % body.decode())
+
+    def reply(self, status, data=None, headers=()):
+        content = json.dumps(data).encode() if data is not None else b""
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    def handle_api(self):
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        self.log("API", body)
+        if self.headers.get("Authorization") != "token " + token:
+            return self.reply(401, {"message": "Bad credentials"})
+
+        path = self.path[len("/api/v3"):].partition("?")[0]
+        match = re.match(r"^/repos/org/test/collaborators/([^/]+)/permission$", path)
+        if self.command == "GET" and match:
+            if match.group(1) == "carol":
+                return self.reply(200, {"permission": "write", "role_name": "security-reviewer"})
+            role = "admin" if match.group(1) == "alice" else "read"
+            return self.reply(200, {"permission": role, "role_name": role})
+        if self.command == "GET" and re.match(r"^/repos/org/test/pulls/\d+/reviews$", path):
+            return self.reply(200, [])
+        if self.command == "POST" and re.match(r"^/repos/org/test/issues/\d+/comments$", path):
+            return self.reply(201, {"id": next(ids), "body": json.loads(body or b"{}").get("body", "")})
+        if self.command == "POST" and re.match(r"^/repos/org/test/pulls/\d+/reviews$", path):
+            return self.reply(200, {"id": next(ids), "body": json.loads(body or b"{}").get("body", "")})
+        if self.command in ("POST", "PATCH", "PUT"):
+            return self.reply(200, {})
+        return self.reply(404, {"message": "Not Found"})
+
+    def handle_git(self):
+        self.log("GIT")
+        if self.headers.get("Authorization") != git_auth:
+            return self.reply(401, headers=[("WWW-Authenticate", 'Basic realm="github"')])
+
+        path, _, query = self.path.partition("?")
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        env = dict(os.environ, GIT_PROJECT_ROOT=root, GIT_HTTP_EXPORT_ALL="1", PATH_INFO=path,
+                   QUERY_STRING=query, REQUEST_METHOD=self.command, REMOTE_USER="x-access-token",
+                   CONTENT_TYPE=self.headers.get("Content-Type", ""), CONTENT_LENGTH=str(len(body)),
+                   HTTP_CONTENT_ENCODING=self.headers.get("Content-Encoding", ""),
+                   GIT_PROTOCOL=self.headers.get("Git-Protocol", ""))
+        output = subprocess.run([os.environ["HTTP_BACKEND"]], input=body, env=env, capture_output=True).stdout
+        header, _, content = output.partition(b"\r\n\r\n")
+        status = 200
+        headers = []
+        for line in header.decode().split("\r\n"):
+            name, _, value = line.partition(": ")
+            if name == "Status":
+                status = int(value.split()[0])
+            elif name:
+                headers.append((name, value))
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    def handle(self):
+        try:
+            super().handle()
+        except BrokenPipeError:
+            pass
+
+    def dispatch(self):
+        if self.path.startswith("/api/v3/"):
+            return self.handle_api()
+        return self.handle_git()
+
+    do_GET = dispatch
+    do_POST = dispatch
+    do_PATCH = dispatch
+    do_PUT = dispatch
+
+    def log_message(self, *args):
+        pass
+
+http.server.ThreadingHTTPServer(("127.0.0.1", port), Handler).serve_forever()
+EOF
+
+GITHUB_PORT=$(free_port)
+GITHUB="http://127.0.0.1:$GITHUB_PORT"
+HTTP_BACKEND="$HTTP_BACKEND" python3 "$TMP/github.py" "$TMP/srv" "$TOKEN" "$GITHUB_PORT" "$TMP/github.log" &
+GITHUB_PID=$!
+
+# 模拟的claude命令行：记录收到的环境变量，对 feature.txt 第1行报告问题
+mkdir -p "$TMP/bin"
+cat > "$TMP/bin/claude" <<'EOF'
+#!/bin/bash
+if [ "$1" = "--version" ]; then echo "fake 1.0"; exit 0; fi
+cat > /dev/null
+env > "$AGENT_ENV_FILE"
+echo '{"type":"result","subtype":"success","is_error":false,"result":"{\"summary\": \"自动审查完成\", \"findings\": [{\"file\": \"feature.txt\", \"line\": 1, \"severity\": \"low\", \"message\": \"auto-finding\"}]}","num_turns":1}'
+EOF
+chmod +x "$TMP/bin/claude"
+
+# 启动webhook服务，工作目录为空目录，避免读取仓库中的 .env
+SERVICE_PORT=$(free_port)
+mkdir -p "$TMP/service"
+(cd "$TMP/service" && exec env SERVER_PORT=$SERVICE_PORT GIN_MODE=release GITHUB_TOKEN="$TOKEN" \
+    GITHUB_WEBHOOK_SECRET="$SECRET" GITHUB_API_URL="$GITHUB/api/v3" GITHUB_GIT_HOST="127.0.0.1:$GITHUB_PORT" \
+    GIT_WORK_DIR="$TMP/service/work" PATH="$TMP/bin:$PATH" AGENT_ENV_FILE="$TMP/agent.env" \
+    "$TMP/webhook-demo" > "$TMP/service/service.log" 2>&1) &
+SERVICE_PID=$!
+for _ in $(seq 1 50); do
+    curl -sf "http://127.0.0.1:$SERVICE_PORT/health" > /dev/null && break
+    sleep 0.2
+done
+if ! curl -sf "http://127.0.0.1:$SERVICE_PORT/health" > /dev/null; then
+    echo "❌ 服务启动失败"
+    tail -20 "$TMP/service/service.log"
+    exit 1
+fi
+
+# 发送带签名的 pull_request opened 事件，$1为delivery ID，$2为PR作者，$3为head所在仓库
+send_pull_request() {
+    cat > "$TMP/payload.json" <<EOF
+{
+  "action": "opened",
+  "number": 5,
+  "pull_request": {"id": 50, "number": 5, "title": "添加feature", "body": "", "state": "open",
+                   "html_url": "$GITHUB/org/test/pull/5", "user": {"id": 2, "login": "$2", "type": "User"},
+                   "head": {"ref": "feature", "sha": "$FEATURE_SHA",
+                            "repo": {"id": 6, "name": "test", "full_name": "$3", "clone_url": "$GITHUB/$3.git"}},
+                   "base": {"ref": "main", "sha": "$BASE_SHA",
+                            "repo": {"id": 3, "name": "test", "full_name": "org/test", "clone_url": "$GITHUB/org/test.git"}}},
+  "repository": {"id": 3, "name": "test", "full_name": "org/test", "default_branch": "main",
+                 "html_url": "$GITHUB/org/test", "clone_url": "$GITHUB/org/test.git",
+                 "owner": {"id": 4, "login": "org", "type": "Organization"}},
+  "sender": {"id": 2, "login": "$2", "type": "User"}
+}
+EOF
+    local signature
+    signature=$(python3 -c 'import hashlib, hmac, sys; print("sha256=" + hmac.new(sys.argv[1].encode(), open(sys.argv[2], "rb").read(), hashlib.sha256).hexdigest())' "$SECRET" "$TMP/payload.json")
+    curl -sf -X POST "http://127.0.0.1:$SERVICE_PORT/webhook" \
+        -H "Content-Type: application/json" \
+        -H "X-GitHub-Event: pull_request" \
+        -H "X-GitHub-Delivery: $1" \
+        -H "X-Hub-Signature-256: $signature" \
+        --data-binary @"$TMP/payload.json" > /dev/null
+}
+
+# 等待第$1个任务结束
+wait_for_jobs() {
+    for _ in $(seq 1 150); do
+        [ "$(grep -c "任务处理完成\|任务处理失败" "$TMP/service/service.log")" -ge "$1" ] && return 0
+        sleep 0.2
+    done
+    return 1
+}
+
+# 已提交的PR审查数量
+review_count() {
+    grep -c "^API POST /api/v3/repos/org/test/pulls/5/reviews$" "$TMP/github.log" || true
+}
+
+# 场景一：有写权限的作者在本仓库分支上打开PR，自动审查
+echo "🚀 场景一: 管理员的PR..."
+send_pull_request "review-trusted" alice org/test
+wait_for_jobs 1 || fail "任务没有结束"
+
+if [ "$(review_count)" = "1" ]; then
+    pass "自动审查了管理员的PR"
+else
+    fail "没有自动审查管理员的PR"
+    tail -20 "$TMP/service/service.log"
+fi
+
+if [ -f "$TMP/agent.env" ] && ! grep -qF "$TOKEN" "$TMP/agent.env" && ! grep -qF "$SECRET" "$TMP/agent.env"; then
+    pass "AI进程的环境变量中没有GitHub token和webhook密钥"
+else
+    fail "AI进程收到了GitHub token或webhook密钥"
+fi
+
+# 场景二：只读用户的PR不自动审查
+echo "🚀 场景二: 只读用户的PR..."
+send_pull_request "review-readonly" mallory org/test
+wait_for_jobs 2 || fail "任务没有结束"
+
+if [ "$(review_count)" = "1" ] && grep -q "作者 mallory 没有写权限" "$TMP/service/service.log"; then
+    pass "跳过了只读用户的PR"
+else
+    fail "只读用户的PR被自动审查"
+fi
+
+# 场景三：fork仓库的PR不自动审查
+echo "🚀 场景三: fork仓库的PR..."
+send_pull_request "review-fork" alice mallory/test
+wait_for_jobs 3 || fail "任务没有结束"
+
+if [ "$(review_count)" = "1" ] && grep -q "来自fork仓库 mallory/test 的PR" "$TMP/service/service.log"; then
+    pass "跳过了fork仓库的PR"
+else
+    fail "fork仓库的PR被自动审查"
+fi
+
+# 场景四：自定义角色按 permission 中的基础权限判断
+echo "🚀 场景四: 自定义角色的PR..."
+send_pull_request "review-custom-role" carol org/test
+wait_for_jobs 4 || fail "任务没有结束"
+
+if [ "$(review_count)" = "2" ]; then
+    pass "自定义角色按基础权限 write 自动审查"
+else
+    fail "自定义角色的PR没有自动审查"
+    tail -20 "$TMP/service/service.log"
+fi
+
+# 场景五：配置 untrusted_authors 后审查只读用户的PR
+echo "🚀 场景五: 启用 untrusted_authors..."
+printf 'auto_review:\n  enabled: true\n  untrusted_authors: true\n' > "$TMP/repo/.codeagent.yml"
+git -C "$TMP/repo" commit -qam "review untrusted authors"
+git -C "$TMP/repo" push -q "$TMP/srv/org/test.git" main
+send_pull_request "review-opt-in" mallory org/test
+wait_for_jobs 5 || fail "任务没有结束"
+
+if [ "$(review_count)" = "3" ]; then
+    pass "启用 untrusted_authors 后审查了只读用户的PR"
+else
+    fail "启用 untrusted_authors 后仍然跳过只读用户的PR"
+    tail -20 "$TMP/service/service.log"
+fi
+
+echo ""
+if [ $FAILED -ne 0 ]; then
+    echo "❌ 自动审查测试失败"
+    exit 1
+fi
+echo "🎉 自动审查测试全部通过"
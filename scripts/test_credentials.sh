@@ -0,0 +1,251 @@
+#!/bin/bash
+
+# Git凭据测试脚本
+# 启动需要Basic认证的本地git HTTP服务，离线运行 /code 完成克隆、读取配置和推送，
+# 检查token没有出现在日志、git命令行参数、git配置（git remote -v）和工作目录中；服务端支持部分克隆时，私有仓库的 .codeagent.yml 同样能读取
+
+set -e
+
+cd "$(dirname "$0")/.."
+
+echo "🧪 开始测试Git凭据..."
+
+for tool in go git python3; do
+    if ! command -v $tool &> /dev/null; then
+        echo "❌ 错误: 未找到 $tool"
+        exit 1
+    fi
+done
+
+REAL_GIT=$(command -v git)
+HTTP_BACKEND="$($REAL_GIT --exec-path)/git-http-backend"
+if [ ! -x "$HTTP_BACKEND" ]; then
+    echo "❌ 错误: 未找到 git-http-backend"
+    exit 1
+fi
+
+TMP=$(mktemp -d)
+SERVER_PID=""
+cleanup() {
+    [ -n "$SERVER_PID" ] && kill $SERVER_PID 2>/dev/null || true
+    rm -rf "$TMP"
+}
+trap cleanup EXIT
+
+TOKEN="canary-$(date +%s)-$RANDOM$RANDOM"
+FAILED=0
+
+pass() { echo "✅ $1"; }
+fail() { echo "❌ $1"; FAILED=1; }
+
+# 检查文件或目录中不包含token
+assert_no_token() {
+    if grep -rqF "$TOKEN" "$2" 2>/dev/null; then
+        fail "$1 中出现了token"
+        grep -rnF "$TOKEN" "$2" | head -5
+    else
+        pass "$1 中没有token"
+    fi
+}
+
+# 编译项目
+echo "🔨 编译项目..."
+go build -o "$TMP/webhook-demo" .
+echo "✅ 编译成功"
+
+# 隔离的HOME，全局配置了会把凭据写入磁盘的 credential.helper=store，用于验证凭据助手被停用
+export HOME="$TMP/home"
+mkdir -p "$HOME"
+git config --global user.name "CodeAgent Test"
+git config --global user.email "test@codeagent.com"
+git config --global credential.helper store
+git config --global init.defaultBranch main
+
+# 准备仓库和服务端bare仓库
+git init -q "$TMP/repo"
+echo "# test" > "$TMP/repo/README.md"
+git -C "$TMP/repo" add README.md
+git -C "$TMP/repo" commit -qm "init"
+mkdir -p "$TMP/srv"
+git clone -q --bare "$TMP/repo" "$TMP/srv/test.git"
+git -C "$TMP/srv/test.git" config http.receivepack true
+
+# 需要Basic认证的git HTTP服务，用户名为 x-access-token，密码为token
+cat > "$TMP/server.py" <<'EOF'
+import base64, http.server, os, subprocess, sys
+
+root, token, port, log_path = sys.argv[1], sys.argv[2], int(sys.argv[3]), sys.argv[4]
+expected = "Basic " + base64.b64encode(("x-access-token:" + token).encode()).decode()
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def handle_git(self):
+        authorized = self.headers.get("Authorization") == expected
+        with open(log_path, "a") as log:
+            log.write("%s %s %s\n" % (self.command, self.path, "authorized" if authorized else "unauthorized"))
+        if not authorized:
+            self.send_response(401)
+            self.send_header("WWW-Authenticate", 'Basic realm="test"')
+            self.send_header("Content-Length", "0")
+            self.end_headers()
+            return
+
+        path, _, query = self.path.partition("?")
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        env = dict(os.environ, GIT_PROJECT_ROOT=root, GIT_HTTP_EXPORT_ALL="1", PATH_INFO=path,
+                   QUERY_STRING=query, REQUEST_METHOD=self.command, REMOTE_USER="x-access-token",
+                   CONTENT_TYPE=self.headers.get("Content-Type", ""), CONTENT_LENGTH=str(len(body)),
+                   HTTP_CONTENT_ENCODING=self.headers.get("Content-Encoding", ""),
+                   GIT_PROTOCOL=self.headers.get("Git-Protocol", ""))
+        output = subprocess.run([os.environ["HTTP_BACKEND"]], input=body, env=env, capture_output=True).stdout
+        header, _, content = output.partition(b"\r\n\r\n")
+        status = 200
+        headers = []
+        for line in header.decode().split("\r\n"):
+            name, _, value = line.partition(": ")
+            if name == "Status":
+                status = int(value.split()[0])
+            elif name:
+                headers.append((name, value))
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    do_GET = handle_git
+    do_POST = handle_git
+
+    def log_message(self, *args):
+        pass
+
+http.server.ThreadingHTTPServer(("127.0.0.1", port), Handler).serve_forever()
+EOF
+
+PORT=$(python3 -c 'import socket; s=socket.socket(); s.bind(("127.0.0.1", 0)); print(s.getsockname()[1])')
+HTTP_BACKEND="$HTTP_BACKEND" python3 "$TMP/server.py" "$TMP/srv" "$TOKEN" "$PORT" "$TMP/server.log" &
+SERVER_PID=$!
+sleep 1
+REMOTE="http://127.0.0.1:$PORT/test.git"
+
+# 模拟的claude命令行，以及记录每次git调用参数的git包装脚本
+mkdir -p "$TMP/bin"
+cat > "$TMP/bin/claude" <<'EOF'
+#!/bin/bash
+if [ "$1" = "--version" ]; then echo "fake 1.0"; exit 0; fi
+cat > /dev/null
+echo "credential test" > generated.txt
+echo '{"type":"result","subtype":"success","is_error":false,"result":"已创建 generated.txt","num_turns":1}'
+EOF
+cat > "$TMP/bin/git" <<EOF
+#!/bin/bash
+printf '%s\n' "\$*" >> "$TMP/git-argv.log"
+exec "$REAL_GIT" "\$@"
+EOF
+chmod +x "$TMP/bin/claude" "$TMP/bin/git"
+echo '{"number":1,"title":"添加文件","body":"添加 generated.txt","state":"open"}' > "$TMP/issue.json"
+
+# 使用正确的token离线运行 /code，克隆、读取配置和推送都需要认证
+echo "🚀 离线运行 /code，推送到 $REMOTE ..."
+if GITHUB_TOKEN="$TOKEN" GIT_WORK_DIR="$TMP/work" PATH="$TMP/bin:$PATH" \
+    "$TMP/webhook-demo" run --repo "$TMP/repo" --issue-file "$TMP/issue.json" --push-remote "$REMOTE" "/code 添加文件" \
+    > "$TMP/run.log" 2>&1; then
+    pass "命令执行成功"
+else
+    fail "命令执行失败"
+    tail -20 "$TMP/run.log"
+fi
+
+if git -C "$TMP/srv/test.git" for-each-ref --format='%(refname:short)' refs/heads/ | grep -qv '^main$'; then
+    pass "分支已推送到需要认证的远程仓库"
+else
+    fail "远程仓库中没有推送的分支"
+fi
+
+if grep -q " unauthorized" "$TMP/server.log" && grep -q " authorized" "$TMP/server.log"; then
+    pass "git在收到401后通过askpass完成认证"
+else
+    fail "服务端没有记录到认证过程"
+fi
+
+assert_no_token "运行日志" "$TMP/run.log"
+assert_no_token "git命令行参数" "$TMP/git-argv.log"
+assert_no_token "工作目录（镜像和工作树的git配置）" "$TMP/work"
+assert_no_token "HOME目录" "$HOME"
+
+MIRROR=$(ls -d "$TMP"/work/mirrors/*.git 2>/dev/null | head -1)
+if [ -n "$MIRROR" ] && [ "$(git -C "$MIRROR" remote get-url origin)" = "$REMOTE" ] && ! git -C "$MIRROR" remote -v | grep -qF "$TOKEN"; then
+    pass "git remote -v 只包含不带认证信息的URL"
+else
+    fail "镜像的远程URL不正确: $(git -C "$MIRROR" remote -v 2>&1 | head -1)"
+fi
+
+if [ ! -e "$HOME/.git-credentials" ]; then
+    pass "全局配置的凭据助手没有保存token"
+else
+    fail "token被保存到了 ~/.git-credentials"
+fi
+
+# 私有仓库的配置文件：服务端支持部分克隆时，读取 .codeagent.yml 的内容需要按需下载，同样需要认证
+# 配置只启用 /help，/code 应被拒绝且不推送分支
+echo "🚀 离线运行 /code，仓库配置只启用 /help ..."
+git init -q "$TMP/private"
+printf 'commands: [help]\n' > "$TMP/private/.codeagent.yml"
+git -C "$TMP/private" add .codeagent.yml
+git -C "$TMP/private" commit -qm "init"
+git clone -q --bare "$TMP/private" "$TMP/srv/private.git"
+git -C "$TMP/srv/private.git" config http.receivepack true
+git -C "$TMP/srv/private.git" config uploadpack.allowFilter true
+git -C "$TMP/srv/private.git" config uploadpack.allowAnySHA1InWant true
+GITHUB_TOKEN="$TOKEN" GIT_WORK_DIR="$TMP/work-private" PATH="$TMP/bin:$PATH" \
+    "$TMP/webhook-demo" run --repo "$TMP/private" --issue-file "$TMP/issue.json" --push-remote "http://127.0.0.1:$PORT/private.git" "/code 添加文件" \
+    > "$TMP/run-private.log" 2>&1 || true
+if grep -q "未在本仓库的" "$TMP/run-private.log" &&
+    ! git -C "$TMP/srv/private.git" for-each-ref --format='%(refname:short)' refs/heads/ | grep -qv '^main$'; then
+    pass "私有仓库的配置文件被读取，未启用的 /code 没有执行"
+else
+    fail "私有仓库的配置文件没有生效"
+    grep -n "仓库配置\|读取文件" "$TMP/run-private.log" | head -5
+fi
+assert_no_token "私有仓库的运行日志" "$TMP/run-private.log"
+
+# 使用错误的token，认证失败时的错误输出同样不能包含token；命令的失败在评论中报告
+echo "🚀 使用错误的token运行..."
+GITHUB_TOKEN="$TOKEN-invalid" GIT_WORK_DIR="$TMP/work-invalid" PATH="$TMP/bin:$PATH" \
+    "$TMP/webhook-demo" run --repo "$TMP/repo" --issue-file "$TMP/issue.json" --push-remote "$REMOTE" "/code 添加文件" \
+    > "$TMP/run-invalid.log" 2>&1 || true
+if grep -q "Authentication failed" "$TMP/run-invalid.log"; then
+    pass "错误的token认证失败"
+else
+    fail "错误的token不应认证成功"
+fi
+if grep -q "无法读取仓库配置文件" "$TMP/run-invalid.log" && ! ls -d "$TMP"/work-invalid/mirrors/*.git > /dev/null 2>&1; then
+    pass "无法读取仓库配置时命令未执行"
+else
+    fail "无法读取仓库配置时仍然执行了命令"
+fi
+assert_no_token "认证失败的运行日志" "$TMP/run-invalid.log"
+
+# askpass只向配置的主机提供token
+ask() {
+    CODEAGENT_GIT_ASKPASS=1 CODEAGENT_GIT_TOKEN="$TOKEN" CODEAGENT_GIT_HOST=github.com "$TMP/webhook-demo" "$1"
+}
+if [ "$(ask "Username for 'https://github.com': ")" = "x-access-token" ] &&
+    [ "$(ask "Password for 'https://x-access-token@github.com': ")" = "$TOKEN" ]; then
+    pass "askpass向配置的主机提供用户名和token"
+else
+    fail "askpass没有向配置的主机提供凭据"
+fi
+if [ -z "$(ask "Password for 'https://x-access-token@evil.example.com': ")" ] &&
+    [ -z "$(ask "Password for 'https://github.com.evil.example.com': ")" ]; then
+    pass "askpass不向其他主机提供token"
+else
+    fail "askpass向其他主机提供了token"
+fi
+
+echo ""
+if [ $FAILED -ne 0 ]; then
+    echo "❌ Git凭据测试失败"
+    exit 1
+fi
+echo "🎉 Git凭据测试全部通过"
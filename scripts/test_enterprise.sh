@@ -0,0 +1,334 @@
+#!/bin/bash
+
+# GitHub Enterprise Server测试脚本
+# 启动模拟GHES的本地服务（/api/v3 下的REST API和需要Basic认证的git HTTP服务），向webhook服务发送带签名的 /code 评论，
+# 检查API调用和git克隆、推送都发往由 repository.html_url 推导出的地址并带有token；再配置 GITHUB_API_URL 检查配置的地址优先；
+# 最后检查不在 GITHUB_ENTERPRISE_HOSTS 中的主机不会被使用，也收不到token
+
+set -e
+
+cd "$(dirname "$0")/.."
+
+echo "🧪 开始测试GitHub Enterprise Server支持..."
+
+for tool in go git python3 curl; do
+    if ! command -v $tool &> /dev/null; then
+        echo "❌ 错误: 未找到 $tool"
+        exit 1
+    fi
+done
+
+HTTP_BACKEND="$(git --exec-path)/git-http-backend"
+if [ ! -x "$HTTP_BACKEND" ]; then
+    echo "❌ 错误: 未找到 git-http-backend"
+    exit 1
+fi
+
+TMP=$(mktemp -d)
+GHES_PID=""
+SERVICE_PID=""
+cleanup() {
+    [ -n "$SERVICE_PID" ] && kill $SERVICE_PID 2>/dev/null || true
+    [ -n "$GHES_PID" ] && kill $GHES_PID 2>/dev/null || true
+    rm -rf "$TMP"
+}
+trap cleanup EXIT
+
+TOKEN="ghes-$(date +%s)-$RANDOM$RANDOM"
+SECRET="ghes-webhook-secret"
+FAILED=0
+
+pass() { echo "✅ $1"; }
+fail() { echo "❌ $1"; FAILED=1; }
+
+free_port() {
+    python3 -c 'import socket; s=socket.socket(); s.bind(("127.0.0.1", 0)); print(s.getsockname()[1])'
+}
+
+# 编译项目
+echo "🔨 编译项目..."
+go build -o "$TMP/webhook-demo" .
+echo "✅ 编译成功"
+
+# 隔离的HOME
+export HOME="$TMP/home"
+mkdir -p "$HOME"
+git config --global user.name "CodeAgent Test"
+git config --global user.email "test@codeagent.com"
+git config --global init.defaultBranch main
+
+# 准备GHES上的仓库 org/test
+git init -q "$TMP/repo"
+echo "# test" > "$TMP/repo/README.md"
+git -C "$TMP/repo" add README.md
+git -C "$TMP/repo" commit -qm "init"
+mkdir -p "$TMP/srv/org"
+git clone -q --bare "$TMP/repo" "$TMP/srv/org/test.git"
+git -C "$TMP/srv/org/test.git" config http.receivepack true
+
+# 模拟的GHES：/api/v3/ 和 /custom-api/ 下为REST API，其余路径为git HTTP服务，都要求token认证
+cat > "$TMP/ghes.py" <<'EOF'
+import base64, http.server, itertools, json, os, re, subprocess, sys
+
+root, token, port, log_path = sys.argv[1], sys.argv[2], int(sys.argv[3]), sys.argv[4]
+git_auth = "Basic " + base64.b64encode(("x-access-token:" + token).encode()).decode()
+ids = itertools.count(1000)
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def log(self, kind, authorized):
+        with open(log_path, "a") as log:
+            log.write("%s %s %s %s\n" % (kind, self.command, self.path, "authorized" if authorized else "unauthorized"))
+
+    def reply(self, status, data=None, headers=()):
+        content = json.dumps(data).encode() if data is not None else b""
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    def handle_api(self, prefix):
+        authorized = self.headers.get("Authorization") == "token " + token
+        self.log("API", authorized)
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        if not authorized:
+            return self.reply(401, {"message": "Bad credentials"})
+
+        path = self.path[len(prefix):].partition("?")[0]
+        if self.command == "GET" and re.match(r"^/repos/[^/]+/[^/]+/collaborators/[^/]+/permission$", path):
+            return self.reply(200, {"permission": "admin", "role_name": "admin"})
+        if self.command == "GET" and re.match(r"^/repos/[^/]+/[^/]+/pulls$", path):
+            return self.reply(200, [])
+        if self.command == "POST" and re.match(r"^/repos/[^/]+/[^/]+/issues/\d+/comments$", path):
+            return self.reply(201, {"id": next(ids), "body": json.loads(body or b"{}").get("body", "")})
+        if self.command == "POST" and re.match(r"^/repos/[^/]+/[^/]+/pulls$", path):
+            number = next(ids)
+            return self.reply(201, {"id": number, "number": number, "html_url": "http://127.0.0.1:%d/org/test/pull/%d" % (port, number)})
+        if self.command in ("POST", "PATCH", "PUT"):
+            return self.reply(200, {})
+        return self.reply(404, {"message": "Not Found"})
+
+    def handle_git(self):
+        authorized = self.headers.get("Authorization") == git_auth
+        self.log("GIT", authorized)
+        if not authorized:
+            return self.reply(401, headers=[("WWW-Authenticate", 'Basic realm="ghes"')])
+
+        path, _, query = self.path.partition("?")
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        env = dict(os.environ, GIT_PROJECT_ROOT=root, GIT_HTTP_EXPORT_ALL="1", PATH_INFO=path,
+                   QUERY_STRING=query, REQUEST_METHOD=self.command, REMOTE_USER="x-access-token",
+                   CONTENT_TYPE=self.headers.get("Content-Type", ""), CONTENT_LENGTH=str(len(body)),
+                   HTTP_CONTENT_ENCODING=self.headers.get("Content-Encoding", ""),
+                   GIT_PROTOCOL=self.headers.get("Git-Protocol", ""))
+        output = subprocess.run([os.environ["HTTP_BACKEND"]], input=body, env=env, capture_output=True).stdout
+        header, _, content = output.partition(b"\r\n\r\n")
+        status = 200
+        headers = []
+        for line in header.decode().split("\r\n"):
+            name, _, value = line.partition(": ")
+            if name == "Status":
+                status = int(value.split()[0])
+            elif name:
+                headers.append((name, value))
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    def handle(self):
+        try:
+            super().handle()
+        except BrokenPipeError:
+            pass
+
+    def dispatch(self):
+        for prefix in ("/api/v3", "/custom-api"):
+            if self.path.startswith(prefix + "/"):
+                return self.handle_api(prefix)
+        return self.handle_git()
+
+    do_GET = dispatch
+    do_POST = dispatch
+    do_PATCH = dispatch
+    do_PUT = dispatch
+
+    def log_message(self, *args):
+        pass
+
+http.server.ThreadingHTTPServer(("127.0.0.1", port), Handler).serve_forever()
+EOF
+
+GHES_PORT=$(free_port)
+GHES="http://127.0.0.1:$GHES_PORT"
+HTTP_BACKEND="$HTTP_BACKEND" python3 "$TMP/ghes.py" "$TMP/srv" "$TOKEN" "$GHES_PORT" "$TMP/ghes.log" &
+GHES_PID=$!
+
+# 模拟的claude命令行
+mkdir -p "$TMP/bin"
+cat > "$TMP/bin/claude" <<'EOF'
+#!/bin/bash
+if [ "$1" = "--version" ]; then echo "fake 1.0"; exit 0; fi
+cat > /dev/null
+echo "enterprise test" > generated.txt
+echo '{"type":"result","subtype":"success","is_error":false,"result":"已创建 generated.txt","num_turns":1}'
+EOF
+chmod +x "$TMP/bin/claude"
+
+# 启动webhook服务，$1为名称，之后为额外的环境变量；工作目录为空目录，避免读取仓库中的 .env
+start_service() {
+    local name=$1
+    shift
+    SERVICE_PORT=$(free_port)
+    mkdir -p "$TMP/$name"
+    (cd "$TMP/$name" && exec env "$@" SERVER_PORT=$SERVICE_PORT GIN_MODE=release GITHUB_TOKEN="$TOKEN" \
+        GITHUB_WEBHOOK_SECRET="$SECRET" GIT_WORK_DIR="$TMP/$name/work" PATH="$TMP/bin:$PATH" \
+        "$TMP/webhook-demo" > "$TMP/$name/service.log" 2>&1) &
+    SERVICE_PID=$!
+    for _ in $(seq 1 50); do
+        curl -sf "http://127.0.0.1:$SERVICE_PORT/health" > /dev/null && return 0
+        sleep 0.2
+    done
+    echo "❌ 服务启动失败"
+    tail -20 "$TMP/$name/service.log"
+    exit 1
+}
+
+stop_service() {
+    kill $SERVICE_PID 2>/dev/null || true
+    wait $SERVICE_PID 2>/dev/null || true
+    SERVICE_PID=""
+}
+
+# 发送带签名的issue_comment webhook，评论内容为 /code
+send_comment() {
+    cat > "$TMP/payload.json" <<EOF
+{
+  "action": "created",
+  "issue": {"id": 1, "number": 1, "title": "添加文件", "body": "添加 generated.txt", "state": "open",
+            "html_url": "$GHES/org/test/issues/1", "user": {"id": 2, "login": "alice", "type": "User"}},
+  "comment": {"id": 10, "body": "/code 添加文件", "user": {"id": 2, "login": "alice", "type": "User"}},
+  "repository": {"id": 3, "name": "test", "full_name": "org/test", "default_branch": "main",
+                 "html_url": "$GHES/org/test", "clone_url": "$GHES/org/test.git",
+                 "owner": {"id": 4, "login": "org", "type": "Organization"}},
+  "sender": {"id": 2, "login": "alice", "type": "User"}
+}
+EOF
+    local signature
+    signature=$(python3 -c 'import hashlib, hmac, sys; print("sha256=" + hmac.new(sys.argv[1].encode(), open(sys.argv[2], "rb").read(), hashlib.sha256).hexdigest())' "$SECRET" "$TMP/payload.json")
+    curl -sf -X POST "http://127.0.0.1:$SERVICE_PORT/webhook" \
+        -H "Content-Type: application/json" \
+        -H "X-GitHub-Event: issue_comment" \
+        -H "X-GitHub-Delivery: $1" \
+        -H "X-Hub-Signature-256: $signature" \
+        --data-binary @"$TMP/payload.json" > /dev/null
+}
+
+# 等待指定的API请求出现在GHES日志中
+wait_for_request() {
+    for _ in $(seq 1 150); do
+        grep -q "$1" "$TMP/ghes.log" 2>/dev/null && return 0
+        sleep 0.2
+    done
+    return 1
+}
+
+# 场景一：没有配置GHES地址，主机在 GITHUB_ENTERPRISE_HOSTS 中，由webhook中的 repository.html_url 推导
+echo "🚀 场景一: 由 repository.html_url 推导GHES地址..."
+start_service derived GITHUB_ENTERPRISE_HOSTS="127.0.0.1:$GHES_PORT"
+send_comment "ghes-derived"
+
+if wait_for_request "^API POST /api/v3/repos/org/test/pulls authorized"; then
+    pass "PR通过 /api/v3 创建"
+else
+    fail "没有通过 /api/v3 创建PR"
+    tail -20 "$TMP/derived/service.log"
+fi
+stop_service
+
+if grep -q "^API GET /api/v3/repos/org/test/collaborators/alice/permission authorized" "$TMP/ghes.log" &&
+    grep -q "^API POST /api/v3/repos/org/test/issues/1/comments authorized" "$TMP/ghes.log"; then
+    pass "权限查询和评论发往推导出的API地址并带有token"
+else
+    fail "权限查询或评论没有发往推导出的API地址"
+fi
+
+if ! grep -q "^API .* unauthorized" "$TMP/ghes.log"; then
+    pass "所有API请求都带有token"
+else
+    fail "存在没有token的API请求"
+    grep "^API .* unauthorized" "$TMP/ghes.log" | head -5
+fi
+
+if grep -q "^GIT .*git-receive-pack authorized" "$TMP/ghes.log" &&
+    git -C "$TMP/srv/org/test.git" for-each-ref --format='%(refname:short)' refs/heads/ | grep -qv '^main$'; then
+    pass "git克隆和推送通过askpass向GHES主机提供token"
+else
+    fail "没有向GHES推送分支"
+    tail -20 "$TMP/derived/service.log"
+fi
+
+if grep -qF "$TOKEN" "$TMP/derived/service.log"; then
+    fail "服务日志中出现了token"
+else
+    pass "服务日志中没有token"
+fi
+
+# 场景二：配置 GITHUB_API_URL 和 GITHUB_GIT_HOST，与webhook中的主机相同时使用配置的地址
+echo "🚀 场景二: 使用配置的API地址..."
+: > "$TMP/ghes.log"
+start_service configured GITHUB_API_URL="$GHES/custom-api" GITHUB_GIT_HOST="127.0.0.1:$GHES_PORT"
+send_comment "ghes-configured"
+
+if wait_for_request "^API POST /custom-api/repos/org/test/pulls authorized"; then
+    pass "PR通过配置的API地址创建"
+else
+    fail "没有通过配置的API地址创建PR"
+    tail -20 "$TMP/configured/service.log"
+fi
+stop_service
+
+if ! grep -q "^API [A-Z]* /api/v3/" "$TMP/ghes.log"; then
+    pass "配置了API地址时不使用推导出的地址"
+else
+    fail "配置了API地址时仍然请求了 /api/v3"
+fi
+
+# 场景三：主机不在 GITHUB_ENTERPRISE_HOSTS 中，伪造的 repository.html_url 不能把token引到该主机
+# 配置的API地址指向没有服务的端口，避免测试访问外部网络
+echo "🚀 场景三: 未允许的主机..."
+: > "$TMP/ghes.log"
+UNUSED_PORT=$(free_port)
+start_service untrusted GITHUB_API_URL="http://127.0.0.1:$UNUSED_PORT/api/v3" GITHUB_GIT_HOST="127.0.0.1:$UNUSED_PORT"
+send_comment "ghes-untrusted"
+
+for _ in $(seq 1 150); do
+    grep -q "任务处理完成\|任务处理失败" "$TMP/untrusted/service.log" && break
+    sleep 0.2
+done
+stop_service
+
+if grep -q "不在 GITHUB_ENTERPRISE_HOSTS 中" "$TMP/untrusted/service.log"; then
+    pass "没有使用未允许的主机推导出的地址"
+else
+    fail "未允许的主机没有被拒绝"
+    tail -20 "$TMP/untrusted/service.log"
+fi
+
+if ! grep -q " authorized$" "$TMP/ghes.log"; then
+    pass "未允许的主机没有收到token"
+else
+    fail "未允许的主机收到了token"
+    grep " authorized$" "$TMP/ghes.log" | head -5
+fi
+
+echo ""
+if [ $FAILED -ne 0 ]; then
+    echo "❌ GitHub Enterprise Server测试失败"
+    exit 1
+fi
+echo "🎉 GitHub Enterprise Server测试全部通过"
@@ -0,0 +1,345 @@
+#!/bin/bash
+
+# PR对话评论测试脚本
+# issue_comment 事件的payload只在 issue.pull_request 中标记所属PR，不包含分支信息；
+# 启动模拟的GitHub API和git HTTP服务，向webhook服务发送PR对话中的 /fix 和 /review 评论，
+# 检查服务通过API加载PR后直接推送到PR的head分支，而不是按Issue处理另外创建PR；
+# /review 审查PR的diff并提交带行内评论的PR审查，而不是审查整个项目；
+# fork仓库PR中的 /fix 被拒绝：fork的提交未经审查，推送到本仓库会以本仓库的密钥运行其中的workflow，这是有意的信任边界
+
+set -e
+
+cd "$(dirname "$0")/.."
+
+echo "🧪 开始测试PR对话中的命令..."
+
+for tool in go git python3 curl; do
+    if ! command -v $tool &> /dev/null; then
+        echo "❌ 错误: 未找到 $tool"
+        exit 1
+    fi
+done
+
+HTTP_BACKEND="$(git --exec-path)/git-http-backend"
+if [ ! -x "$HTTP_BACKEND" ]; then
+    echo "❌ 错误: 未找到 git-http-backend"
+    exit 1
+fi
+
+TMP=$(mktemp -d)
+GITHUB_PID=""
+SERVICE_PID=""
+cleanup() {
+    [ -n "$SERVICE_PID" ] && kill $SERVICE_PID 2>/dev/null || true
+    [ -n "$GITHUB_PID" ] && kill $GITHUB_PID 2>/dev/null || true
+    rm -rf "$TMP"
+}
+trap cleanup EXIT
+
+TOKEN="pr-$(date +%s)-$RANDOM$RANDOM"
+SECRET="pr-webhook-secret"
+FAILED=0
+
+pass() { echo "✅ $1"; }
+fail() { echo "❌ $1"; FAILED=1; }
+
+free_port() {
+    python3 -c 'import socket; s=socket.socket(); s.bind(("127.0.0.1", 0)); print(s.getsockname()[1])'
+}
+
+# 编译项目
+echo "🔨 编译项目..."
+go build -o "$TMP/webhook-demo" .
+echo "✅ 编译成功"
+
+# 隔离的HOME
+export HOME="$TMP/home"
+mkdir -p "$HOME"
+git config --global user.name "CodeAgent Test"
+git config --global user.email "test@codeagent.com"
+git config --global init.defaultBranch main
+
+# 准备仓库 org/test，PR #5 从 feature 合并到 main
+git init -q "$TMP/repo"
+echo "# test" > "$TMP/repo/README.md"
+git -C "$TMP/repo" add README.md
+git -C "$TMP/repo" commit -qm "init"
+git -C "$TMP/repo" checkout -qb feature
+echo "hello" > "$TMP/repo/feature.txt"
+git -C "$TMP/repo" add feature.txt
+git -C "$TMP/repo" commit -qm "add feature"
+git -C "$TMP/repo" checkout -q main
+mkdir -p "$TMP/srv/org"
+git clone -q --bare "$TMP/repo" "$TMP/srv/org/test.git"
+git -C "$TMP/srv/org/test.git" config http.receivepack true
+git -C "$TMP/srv/org/test.git" update-ref refs/pull/5/head refs/heads/feature
+FEATURE_SHA=$(git -C "$TMP/srv/org/test.git" rev-parse refs/heads/feature)
+
+# 模拟的GitHub：/api/v3/ 下为REST API，其余路径为git HTTP服务，都要求token认证
+# GET /repos/org/test/pulls/5 按服务端仓库当前的分支返回PR信息，PR #6 来自fork仓库 mallory/test
+cat > "$TMP/github.py" <<'EOF'
+import base64, http.server, itertools, json, os, re, subprocess, sys
+
+root, token, port, log_path = sys.argv[1], sys.argv[2], int(sys.argv[3]), sys.argv[4]
+git_auth = "Basic " + base64.b64encode(("x-access-token:" + token).encode()).decode()
+base_url = "http://127.0.0.1:%d" % port
+ids = itertools.count(1000)
+
+def branch(ref, owner="org"):
+    sha = subprocess.run(["git", "-C", os.path.join(root, "org/test.git"), "rev-parse", "refs/heads/" + ref],
+                         capture_output=True, text=True).stdout.strip()
+    repo = {"id": 3, "name": "test", "full_name": owner + "/test", "clone_url": base_url + "/" + owner + "/test.git",
+            "html_url": base_url + "/" + owner + "/test", "owner": {"id": 4, "login": owner, "type": "User"}}
+    return {"ref": ref, "sha": sha, "repo": repo}
+
+class Handler(http.server.BaseHTTPRequestHandler):
+    def log(self, kind, body=b""):
+        with open(log_path, "a") as log:
+            log.write("%s %s %s\n" % (kind, self.command, self.path))
+            if body:
+                log.write("BODY %s\n" % body.decode())
+
+    def reply(self, status, data=None, headers=()):
+        content = json.dumps(data).encode() if data is not None else b""
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    def handle_api(self):
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        self.log("API", body)
+        if self.headers.get("Authorization") != "token " + token:
+            return self.reply(401, {"message": "Bad credentials"})
+
+        path = self.path[len("/api/v3"):].partition("?")[0]
+        if self.command == "GET" and re.match(r"^/repos/org/test/collaborators/[^/]+/permission$", path):
+            return self.reply(200, {"permission": "admin", "role_name": "admin"})
+        if self.command == "GET" and path == "/repos/org/test/pulls/5":
+            return self.reply(200, {"id": 50, "number": 5, "title": "添加feature", "body": "", "state": "open",
+                                    "html_url": base_url + "/org/test/pull/5",
+                                    "user": {"id": 2, "login": "alice", "type": "User"},
+                                    "head": branch("feature"), "base": branch("main")})
+        if self.command == "GET" and path == "/repos/org/test/pulls/6":
+            return self.reply(200, {"id": 60, "number": 6, "title": "来自fork的修改", "body": "", "state": "open",
+                                    "html_url": base_url + "/org/test/pull/6",
+                                    "user": {"id": 5, "login": "mallory", "type": "User"},
+                                    "head": branch("feature", "mallory"), "base": branch("main")})
+        if self.command == "GET" and re.match(r"^/repos/org/test/(pulls|pulls/\d+/reviews|issues/\d+/comments)$", path):
+            return self.reply(200, [])
+        if self.command == "POST" and re.match(r"^/repos/org/test/issues/\d+/comments$", path):
+            return self.reply(201, {"id": next(ids), "body": json.loads(body or b"{}").get("body", "")})
+        if self.command == "POST" and re.match(r"^/repos/org/test/pulls/\d+/reviews$", path):
+            return self.reply(200, {"id": next(ids), "body": json.loads(body or b"{}").get("body", "")})
+        if self.command == "POST" and path == "/repos/org/test/pulls":
+            number = next(ids)
+            return self.reply(201, {"id": number, "number": number, "html_url": base_url + "/org/test/pull/%d" % number})
+        if self.command in ("POST", "PATCH", "PUT"):
+            return self.reply(200, {})
+        return self.reply(404, {"message": "Not Found"})
+
+    def handle_git(self):
+        self.log("GIT")
+        if self.headers.get("Authorization") != git_auth:
+            return self.reply(401, headers=[("WWW-Authenticate", 'Basic realm="github"')])
+
+        path, _, query = self.path.partition("?")
+        body = self.rfile.read(int(self.headers.get("Content-Length") or 0))
+        env = dict(os.environ, GIT_PROJECT_ROOT=root, GIT_HTTP_EXPORT_ALL="1", PATH_INFO=path,
+                   QUERY_STRING=query, REQUEST_METHOD=self.command, REMOTE_USER="x-access-token",
+                   CONTENT_TYPE=self.headers.get("Content-Type", ""), CONTENT_LENGTH=str(len(body)),
+                   HTTP_CONTENT_ENCODING=self.headers.get("Content-Encoding", ""),
+                   GIT_PROTOCOL=self.headers.get("Git-Protocol", ""))
+        output = subprocess.run([os.environ["HTTP_BACKEND"]], input=body, env=env, capture_output=True).stdout
+        header, _, content = output.partition(b"\r\n\r\n")
+        status = 200
+        headers = []
+        for line in header.decode().split("\r\n"):
+            name, _, value = line.partition(": ")
+            if name == "Status":
+                status = int(value.split()[0])
+            elif name:
+                headers.append((name, value))
+        self.send_response(status)
+        for name, value in headers:
+            self.send_header(name, value)
+        self.send_header("Content-Length", str(len(content)))
+        self.end_headers()
+        self.wfile.write(content)
+
+    def handle(self):
+        try:
+            super().handle()
+        except BrokenPipeError:
+            pass
+
+    def dispatch(self):
+        if self.path.startswith("/api/v3/"):
+            return self.handle_api()
+        return self.handle_git()
+
+    do_GET = dispatch
+    do_POST = dispatch
+    do_PATCH = dispatch
+    do_PUT = dispatch
+
+    def log_message(self, *args):
+        pass
+
+http.server.ThreadingHTTPServer(("127.0.0.1", port), Handler).serve_forever()
+EOF
+
+GITHUB_PORT=$(free_port)
+GITHUB="http://127.0.0.1:$GITHUB_PORT"
+HTTP_BACKEND="$HTTP_BACKEND" python3 "$TMP/github.py" "$TMP/srv" "$TOKEN" "$GITHUB_PORT" "$TMP/github.log" &
+GITHUB_PID=$!
+
+# 模拟的claude命令行：PR审查时对 feature.txt 第1行报告问题，修复时写入文件
+mkdir -p "$TMP/bin"
+cat > "$TMP/bin/claude" <<'EOF'
+#!/bin/bash
+if [ "$1" = "--version" ]; then echo "fake 1.0"; exit 0; fi
+if grep -q "请对以下Pull Request" -; then
+    echo '{"type":"result","subtype":"success","is_error":false,"result":"{\"summary\": \"PR审查完成\", \"findings\": [{\"file\": \"feature.txt\", \"line\": 1, \"severity\": \"low\", \"message\": \"inline-finding\"}]}","num_turns":1}'
+    exit 0
+fi
+echo "fixed" > fixed.txt
+echo '{"type":"result","subtype":"success","is_error":false,"result":"已创建 fixed.txt","num_turns":1}'
+EOF
+chmod +x "$TMP/bin/claude"
+
+# 启动webhook服务，工作目录为空目录，避免读取仓库中的 .env
+SERVICE_PORT=$(free_port)
+mkdir -p "$TMP/service"
+(cd "$TMP/service" && exec env SERVER_PORT=$SERVICE_PORT GIN_MODE=release GITHUB_TOKEN="$TOKEN" \
+    GITHUB_WEBHOOK_SECRET="$SECRET" GITHUB_API_URL="$GITHUB/api/v3" GITHUB_GIT_HOST="127.0.0.1:$GITHUB_PORT" \
+    GIT_WORK_DIR="$TMP/service/work" PATH="$TMP/bin:$PATH" \
+    "$TMP/webhook-demo" > "$TMP/service/service.log" 2>&1) &
+SERVICE_PID=$!
+for _ in $(seq 1 50); do
+    curl -sf "http://127.0.0.1:$SERVICE_PORT/health" > /dev/null && break
+    sleep 0.2
+done
+if ! curl -sf "http://127.0.0.1:$SERVICE_PORT/health" > /dev/null; then
+    echo "❌ 服务启动失败"
+    tail -20 "$TMP/service/service.log"
+    exit 1
+fi
+
+# 发送带签名的PR对话评论，$1为delivery ID，$2为评论内容，$3为PR编号（默认5）
+send_pr_comment() {
+    local number=${3:-5}
+    cat > "$TMP/payload.json" <<EOF
+{
+  "action": "created",
+  "issue": {"id": 50, "number": $number, "title": "添加feature", "body": "", "state": "open",
+            "html_url": "$GITHUB/org/test/pull/$number", "user": {"id": 2, "login": "alice", "type": "User"},
+            "pull_request": {"url": "$GITHUB/api/v3/repos/org/test/pulls/$number", "html_url": "$GITHUB/org/test/pull/$number"}},
+  "comment": {"id": 10, "body": "$2", "user": {"id": 2, "login": "alice", "type": "User"}},
+  "repository": {"id": 3, "name": "test", "full_name": "org/test", "default_branch": "main",
+                 "html_url": "$GITHUB/org/test", "clone_url": "$GITHUB/org/test.git",
+                 "owner": {"id": 4, "login": "org", "type": "Organization"}},
+  "sender": {"id": 2, "login": "alice", "type": "User"}
+}
+EOF
+    local signature
+    signature=$(python3 -c 'import hashlib, hmac, sys; print("sha256=" + hmac.new(sys.argv[1].encode(), open(sys.argv[2], "rb").read(), hashlib.sha256).hexdigest())' "$SECRET" "$TMP/payload.json")
+    curl -sf -X POST "http://127.0.0.1:$SERVICE_PORT/webhook" \
+        -H "Content-Type: application/json" \
+        -H "X-GitHub-Event: issue_comment" \
+        -H "X-GitHub-Delivery: $1" \
+        -H "X-Hub-Signature-256: $signature" \
+        --data-binary @"$TMP/payload.json" > /dev/null
+}
+
+# 等待第$1个任务结束
+wait_for_jobs() {
+    for _ in $(seq 1 150); do
+        [ "$(grep -c "任务处理完成\|任务处理失败" "$TMP/service/service.log")" -ge "$1" ] && return 0
+        sleep 0.2
+    done
+    return 1
+}
+
+# 场景一：PR对话中的 /fix 修改PR分支
+echo "🚀 场景一: PR对话中的 /fix..."
+send_pr_comment "pr-fix" "/fix 补充文件"
+
+if ! wait_for_jobs 1; then
+    fail "任务没有结束"
+fi
+
+if grep -q "^API GET /api/v3/repos/org/test/pulls/5$" "$TMP/github.log"; then
+    pass "通过API加载了PR #5"
+else
+    fail "没有通过API加载PR"
+fi
+
+if [ "$(git -C "$TMP/srv/org/test.git" rev-parse refs/heads/feature)" != "$FEATURE_SHA" ] &&
+    git -C "$TMP/srv/org/test.git" show refs/heads/feature:fixed.txt > /dev/null 2>&1; then
+    pass "修复推送到了PR的head分支 feature"
+else
+    fail "修复没有推送到PR的head分支"
+    tail -20 "$TMP/service/service.log"
+fi
+
+if ! grep -q "^API POST /api/v3/repos/org/test/pulls$" "$TMP/github.log" &&
+    ! git -C "$TMP/srv/org/test.git" for-each-ref --format='%(refname:short)' refs/heads/ | grep -qv '^\(main\|feature\)$'; then
+    pass "没有按Issue处理另外创建分支和PR"
+else
+    fail "PR对话中的 /fix 被当作Issue处理"
+fi
+
+# 场景二：PR对话中的 /review 提交PR审查
+echo "🚀 场景二: PR对话中的 /review..."
+send_pr_comment "pr-review" "/review"
+
+if ! wait_for_jobs 2; then
+    fail "任务没有结束"
+fi
+
+if grep -A1 "^API POST /api/v3/repos/org/test/pulls/5/reviews$" "$TMP/github.log" | grep -q '"path":"feature.txt".*inline-finding'; then
+    pass "审查了PR的diff并提交了带行内评论的PR审查"
+else
+    fail "没有向PR #5 提交行内审查"
+    tail -20 "$TMP/service/service.log"
+fi
+
+if ! grep -q "执行一般代码审查" "$TMP/service/service.log"; then
+    pass "没有按Issue执行一般代码审查"
+else
+    fail "PR对话中的 /review 被当作Issue处理"
+fi
+
+# 场景三：fork仓库PR中的 /fix 被拒绝，不向本仓库推送任何分支
+echo "🚀 场景三: fork仓库PR中的 /fix..."
+BRANCHES_BEFORE=$(git -C "$TMP/srv/org/test.git" for-each-ref --format='%(refname) %(objectname)' refs/heads/)
+send_pr_comment "pr-fork-fix" "/fix 补充文件" 6
+
+if ! wait_for_jobs 3; then
+    fail "任务没有结束"
+fi
+
+if [ "$(git -C "$TMP/srv/org/test.git" for-each-ref --format='%(refname) %(objectname)' refs/heads/)" = "$BRANCHES_BEFORE" ] &&
+    ! grep -q "^API POST /api/v3/repos/org/test/pulls$" "$TMP/github.log"; then
+    pass "没有把fork的提交推送到本仓库"
+else
+    fail "fork仓库PR中的 /fix 向本仓库推送了分支"
+fi
+
+if grep -A1 "^API POST /api/v3/repos/org/test/issues/6/comments$" "$TMP/github.log" | grep -q "不处理fork仓库的PR"; then
+    pass "在PR中说明了拒绝的原因"
+else
+    fail "没有说明拒绝fork仓库PR的原因"
+    tail -20 "$TMP/service/service.log"
+fi
+
+echo ""
+if [ $FAILED -ne 0 ]; then
+    echo "❌ PR对话命令测试失败"
+    exit 1
+fi
+echo "🎉 PR对话命令测试全部通过"